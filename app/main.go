@@ -1,22 +1,420 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/codecrafters-io/dns-server-starter-go/app/server"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/resolver"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
 	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
 )
 
+// shutdownTimeout bounds how long in-flight queries may take to finish on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// dnsServer is implemented by every transport.
+type dnsServer interface {
+	Start(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
-	addr := "127.0.0.1:2053"
+	var zoneFiles stringList
+	flag.Var(&zoneFiles, "zone", "serve a zone authoritatively from a master file, given as file or origin=file (repeatable)")
+	var secondaryZones stringList
+	flag.Var(&secondaryZones, "secondary", "serve a zone as a secondary, transferred from a primary given as origin=ip[:port][/key] where key names a -tsig-key (repeatable)")
+	var tsigKeys stringList
+	flag.Var(&tsigKeys, "tsig-key", "TSIG key given as [algorithm:]name:base64-secret, algorithm one of hmac-sha256 (default), hmac-sha384 and hmac-sha512 (repeatable)")
+	dnssecKeys := flag.String("dnssec-keys", "", "sign every -zone online with a key kept in this directory as <origin>.key, generated on first use (signing disabled if empty)")
+	dnssecAlgorithm := flag.String("dnssec-algorithm", "ecdsap256sha256", "algorithm of newly generated DNSSEC keys: ecdsap256sha256 or ed25519")
+	nsec3 := flag.Bool("nsec3", false, "deny existence in signed zones with NSEC3, without salt or extra iterations, instead of NSEC")
+	signatureValidity := flag.Duration("signature-validity", zone.DefaultSignatureValidity, "how long DNSSEC signatures are valid for; they are renewed at half that")
+	allowUpdate := flag.String("allow-update", "", "comma-separated addresses, CIDR prefixes and key:name TSIG keys allowed to send dynamic updates; changes last until the zone file is reloaded")
+	allowTransfer := flag.String("allow-transfer", "", "comma-separated addresses, CIDR prefixes and key:name TSIG keys allowed to transfer zones over TCP")
+	addr := flag.String("addr", "127.0.0.1:2053", "address for the UDP and TCP listeners")
+	tlsAddr := flag.String("tls-addr", "", "address for the DNS-over-TLS listener, e.g. :853 (disabled if empty)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for DNS-over-TLS and DNS-over-HTTPS")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for DNS-over-TLS and DNS-over-HTTPS")
+	dohAddr := flag.String("doh-addr", "", "address for the DNS-over-HTTPS and JSON API listener, e.g. :443 (disabled if empty); uses -tls-cert and -tls-key when set, plain HTTP otherwise")
+	udpWorkers := flag.Int("udp-workers", 4*runtime.NumCPU(), "number of goroutines processing UDP queries")
+	udpQueue := flag.Int("udp-queue", 1024, "number of UDP queries that may wait for a worker")
+	overload := flag.String("overload", "servfail", "how to shed UDP queries when the queue is full: servfail, refused or drop")
+	resolverAddr := flag.String("resolver", "", "forward queries to this upstream resolver (ip:port) instead of answering them locally")
+	recursive := flag.Bool("recursive", false, "resolve queries iteratively starting from the root servers")
+	rootHints := flag.String("root-hints", "", "comma-separated root server addresses for -recursive (built-in hints if empty)")
+	nsPort := flag.String("ns-port", "53", "port name servers are queried on in -recursive mode")
+	staleMaxAge := flag.Duration("stale-max-age", resolver.DefaultMaxStale, "how long past expiry cached data may be served when upstreams fail (0 disables serve-stale)")
+	validate := flag.Bool("dnssec-validate", false, "validate DNSSEC in -resolver and -recursive modes against the root zone's trust anchors")
+	trustAnchors := flag.String("trust-anchors", "", "master file of DS or DNSKEY records to validate DNSSEC against instead of the root zone's; implies -dnssec-validate")
+	cacheSize := flag.Int("cache-size", resolver.DefaultCacheSize, "number of RRsets cached in -resolver and -recursive modes (0 disables caching)")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(out, "\nWithout -zone, -secondary, -resolver or -recursive, every query is answered with an A record for 8.8.8.8.")
+		fmt.Fprintln(out, "With zones but no resolver, queries for names outside them are refused.")
+	}
+	flag.Parse()
 
 	log := gotracer.New()
 	log.SetLevel(gotracer.LevelDebug)
 	log.AddOutput(os.Stdout)
 
-	srv := server.New(addr, log)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	policy, err := server.ParseOverloadPolicy(*overload)
+	if err != nil {
+		log.Error.Printf("Invalid -overload: %v", err)
+		os.Exit(1)
+	}
+
+	if *udpWorkers < 1 {
+		log.Error.Println("-udp-workers must be at least 1")
+		os.Exit(1)
+	}
+	if *udpQueue < 0 {
+		log.Error.Println("-udp-queue cannot be negative")
+		os.Exit(1)
+	}
+
+	if *resolverAddr != "" && *recursive {
+		log.Error.Println("-resolver and -recursive cannot be used together")
+		os.Exit(1)
+	}
+
+	var upstream resolver.Handler
+	switch {
+	case *resolverAddr != "":
+		upstream = resolver.NewForwarder(*resolverAddr, log)
+	case *recursive:
+		recursiveOpts := []resolver.RecursiveOption{resolver.WithPort(*nsPort)}
+		if *rootHints != "" {
+			recursiveOpts = append(recursiveOpts, resolver.WithRootHints(strings.Split(*rootHints, ",")...))
+		}
+		upstream = resolver.NewRecursive(log, recursiveOpts...)
+	}
+
+	if *validate || *trustAnchors != "" {
+		if upstream == nil {
+			log.Error.Println("-dnssec-validate and -trust-anchors need -resolver or -recursive")
+			os.Exit(1)
+		}
+		anchors := resolver.RootTrustAnchors
+		if *trustAnchors != "" {
+			if anchors, err = zone.ParseFile(*trustAnchors, ""); err != nil {
+				log.Error.Printf("Invalid -trust-anchors: %v", err)
+				os.Exit(1)
+			}
+		}
+		upstream = resolver.NewValidator(upstream, anchors, log)
+	}
+
+	// Certificates and zone files are reloaded on SIGHUP without dropping
+	// connections
+	var reloaders []func() error
+
+	var handlerOpts []server.HandlerOption
+	keys := make(map[string]message.TSIGKey)
+	for _, spec := range tsigKeys {
+		key, err := message.ParseTSIGKey(spec)
+		if err != nil {
+			log.Error.Printf("Invalid -tsig-key: %v", err)
+			os.Exit(1)
+		}
+		keys[strings.ToLower(key.Name)] = key
+		handlerOpts = append(handlerOpts, server.WithTSIGKeys(key))
+	}
+
+	var signerOpts []zone.SignerOption
+	var algorithm uint8
+	if *dnssecKeys != "" {
+		var err error
+		if algorithm, err = parseAlgorithm(*dnssecAlgorithm); err != nil {
+			log.Error.Printf("Invalid -dnssec-algorithm: %v", err)
+			os.Exit(1)
+		}
+		signerOpts = append(signerOpts, zone.WithSignatureValidity(*signatureValidity))
+		if *nsec3 {
+			signerOpts = append(signerOpts, zone.WithNSEC3(0, nil))
+		}
+	}
+
+	var secondaries []*zone.Secondary
+	var signers []*zone.Signer
+	if len(zoneFiles) > 0 || len(secondaryZones) > 0 {
+		zones := zone.NewStore()
+		for _, spec := range zoneFiles {
+			origin, path, ok := strings.Cut(spec, "=")
+			if !ok {
+				origin, path = "", spec
+			}
+			z, err := zone.Load(path, origin)
+			if err != nil {
+				log.Error.Printf("Failed to load zone: %v", err)
+				os.Exit(1)
+			}
+			log.Info.Printf("Loaded zone %s from %s (%d records)", message.Fqdn(z.Origin()), path, len(z.Records()))
+			if *dnssecKeys != "" {
+				signer, err := signZone(z, *dnssecKeys, algorithm, log, signerOpts...)
+				if err != nil {
+					log.Error.Printf("Failed to sign zone %s: %v", message.Fqdn(z.Origin()), err)
+					os.Exit(1)
+				}
+				signers = append(signers, signer)
+			}
+			zones.Add(z)
+			reloaders = append(reloaders, func() error {
+				records, err := zone.ParseFile(path, origin)
+				if err != nil {
+					return err
+				}
+				if err := z.Replace(records); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				log.Info.Printf("Reloaded zone %s at serial %d", message.Fqdn(z.Origin()), zone.Serial(z.SOA()))
+				return nil
+			})
+		}
+		for _, spec := range secondaryZones {
+			origin, primary, ok := strings.Cut(spec, "=")
+			if !ok {
+				log.Error.Printf("Invalid -secondary %q: want origin=ip[:port]", spec)
+				os.Exit(1)
+			}
+			var secondaryOpts []zone.SecondaryOption
+			primary, keyName, hasKey := strings.Cut(primary, "/")
+			if hasKey {
+				key, ok := keys[strings.ToLower(strings.TrimSuffix(keyName, "."))]
+				if !ok {
+					log.Error.Printf("Invalid -secondary %q: no -tsig-key named %s", spec, keyName)
+					os.Exit(1)
+				}
+				secondaryOpts = append(secondaryOpts, zone.WithTSIGKey(key))
+			}
+			addr, err := parseAddrPort(primary, 53)
+			if err != nil {
+				log.Error.Printf("Invalid -secondary %q: %v", spec, err)
+				os.Exit(1)
+			}
+			secondaries = append(secondaries, zone.NewSecondary(origin, addr, zones, log, secondaryOpts...))
+		}
+		handlerOpts = append(handlerOpts, server.WithZones(zones), server.WithSecondaries(secondaries...))
+	}
+	if *allowTransfer != "" {
+		prefixes, keyNames, err := parseACL(*allowTransfer, keys)
+		if err != nil {
+			log.Error.Printf("Invalid -allow-transfer: %v", err)
+			os.Exit(1)
+		}
+		handlerOpts = append(handlerOpts, server.WithTransferACL(prefixes...), server.WithTransferKeys(keyNames...))
+	}
+	if *allowUpdate != "" {
+		prefixes, keyNames, err := parseACL(*allowUpdate, keys)
+		if err != nil {
+			log.Error.Printf("Invalid -allow-update: %v", err)
+			os.Exit(1)
+		}
+		handlerOpts = append(handlerOpts, server.WithUpdateACL(prefixes...), server.WithUpdateKeys(keyNames...))
+	}
+	var cache *resolver.Cache
+	if upstream != nil {
+		if *cacheSize > 0 {
+			cache = resolver.NewCache(upstream, *cacheSize, log, resolver.WithServeStale(*staleMaxAge))
+			upstream = cache
+		}
+		handlerOpts = append(handlerOpts, server.WithResolver(upstream))
+	}
+
+	handler := server.NewDefaultMessageHandler(log, handlerOpts...)
+	servers := []dnsServer{
+		server.New(*addr, log,
+			server.WithMessageHandler(handler),
+			server.WithUDPWorkers(*udpWorkers),
+			server.WithUDPQueueSize(*udpQueue),
+			server.WithOverloadPolicy(policy),
+		),
+		server.NewTCP(*addr, log, server.WithMessageHandler(handler)),
+	}
+
+	if *tlsAddr != "" {
+		dot, err := server.NewTLS(*tlsAddr, *tlsCert, *tlsKey, log, server.WithMessageHandler(handler))
+		if err != nil {
+			log.Error.Printf("Server error: %v", err)
+			os.Exit(1)
+		}
+		reloaders = append(reloaders, dot.Reload)
+		servers = append(servers, dot)
+	}
+
+	if *dohAddr != "" {
+		var doh *server.HTTPServer
+		if *tlsCert != "" {
+			var err error
+			if doh, err = server.NewHTTPS(*dohAddr, *tlsCert, *tlsKey, log, server.WithMessageHandler(handler)); err != nil {
+				log.Error.Printf("Server error: %v", err)
+				os.Exit(1)
+			}
+		} else {
+			doh = server.NewHTTP(*dohAddr, log, server.WithMessageHandler(handler))
+		}
+		reloaders = append(reloaders, doh.Reload)
+		servers = append(servers, doh)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, reload := range reloaders {
+				if err := reload(); err != nil {
+					log.Error.Printf("Reload failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	for _, s := range secondaries {
+		go s.Run(ctx)
+	}
+	for _, s := range signers {
+		go s.Run(ctx)
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv dnsServer) { errs <- srv.Start(ctx) }(srv)
+	}
+
+	failed := false
+	select {
+	case <-ctx.Done():
+		log.Info.Println("Received shutdown signal")
+	case err := <-errs:
+		if err != nil {
+			log.Error.Printf("Server error: %v", err)
+			failed = true
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv dnsServer) {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Error.Printf("Shutdown error: %v", err)
+			}
+		}(srv)
+	}
+	if cache != nil {
+		// Stale data refreshes run in the background of any transport
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cache.Shutdown(shutdownCtx); err != nil {
+				log.Error.Printf("Shutdown error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// signZone signs z with the key for it in dir, generating one for
+// algorithm if there is none yet, and logs the DS record the parent zone
+// needs to hold for the signatures to be trusted.
+func signZone(z *zone.Zone, dir string, algorithm uint8, log *gotracer.Logger, opts ...zone.SignerOption) (*zone.Signer, error) {
+	origin := message.Fqdn(z.Origin())
+	key, err := zone.LoadKey(filepath.Join(dir, strings.ToLower(origin)+"key"), algorithm)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := zone.NewSigner(z, key, log, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := key.DNSKEY().ToDS(z.Origin(), message.DigestSHA256)
+	if err != nil {
+		return nil, err
+	}
+	log.Info.Printf("Signed zone %s with key %d; DS record for the parent zone: %s IN DS %s", origin, key.KeyTag(), origin, ds)
+	return signer, nil
+}
+
+// parseAlgorithm parses the name of a DNSSEC algorithm new keys may be
+// generated for.
+func parseAlgorithm(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "ecdsap256sha256":
+		return message.AlgECDSAP256SHA256, nil
+	case "ed25519":
+		return message.AlgED25519, nil
+	}
+	return 0, fmt.Errorf("unsupported algorithm %q", s)
+}
+
+// parseACL parses a comma-separated list of CIDR prefixes and key:name
+// entries naming keys in keys. A bare address stands for a prefix holding
+// only that address.
+func parseACL(list string, keys map[string]message.TSIGKey) ([]netip.Prefix, []string, error) {
+	var prefixes []netip.Prefix
+	var keyNames []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if name, ok := strings.CutPrefix(s, "key:"); ok {
+			if _, ok := keys[strings.ToLower(strings.TrimSuffix(name, "."))]; !ok {
+				return nil, nil, fmt.Errorf("no -tsig-key named %s", name)
+			}
+			keyNames = append(keyNames, name)
+			continue
+		}
+		if addr, err := netip.ParseAddr(s); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, keyNames, nil
+}
 
-	if err := srv.Start(); err != nil {
-		log.Error.Printf("Server error: %v", err)
+// parseAddrPort parses an ip:port address, or a bare IP address that
+// stands for port on it.
+func parseAddrPort(s string, port uint16) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.AddrPortFrom(addr, port), nil
 	}
+	return netip.ParseAddrPort(s)
 }
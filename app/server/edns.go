@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+const (
+	// ednsUDPSize is the UDP payload size the server advertises in its OPT
+	// records, following the DNS Flag Day 2020 recommendation.
+	ednsUDPSize = 1232
+
+	// maxUDPRequestSize bounds the read buffer for incoming UDP queries.
+	maxUDPRequestSize = 4096
+)
+
+// ednsRequest captures the EDNS parameters a client sent with its query.
+type ednsRequest struct {
+	present bool
+	edns    message.EDNS
+}
+
+// parseEDNSRequest extracts the client's EDNS parameters from a raw query.
+// Queries that cannot be parsed are treated as plain DNS; the handler is
+// responsible for reporting the parse error.
+func parseEDNSRequest(data []byte) (message.Message, ednsRequest) {
+	request, err := message.ParseMessage(data)
+	if err != nil {
+		return message.Message{}, ednsRequest{}
+	}
+	edns, ok := request.EDNS()
+	return request, ednsRequest{present: ok, edns: edns}
+}
+
+// udpLimit returns the largest UDP response the client can accept: the
+// smaller of its advertised size and ours, but never below 512 bytes.
+func (e ednsRequest) udpLimit() int {
+	if !e.present {
+		return message.MinUDPSize
+	}
+	limit := min(int(e.edns.UDPSize), ednsUDPSize)
+	if limit < message.MinUDPSize {
+		return message.MinUDPSize
+	}
+	return limit
+}
+
+// unsupportedVersion reports whether the client asked for an EDNS version
+// this server does not implement.
+func (e ednsRequest) unsupportedVersion() bool {
+	return e.present && e.edns.Version > message.EDNSVersion
+}
+
+// badVersionResponse answers a query that used an unsupported EDNS version
+// with BADVERS, advertising the version we do support (RFC 6891 §6.1.3).
+func badVersionResponse(request message.Message) message.Message {
+	header := request.Header
+	header.QR = 1
+	header.AA = 0
+	header.TC = 0
+	header.RA = 0
+
+	response := message.Message{
+		Header:    header,
+		Questions: request.Questions,
+	}
+	response.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, Version: message.EDNSVersion})
+	response.SetRCode(message.RCodeBadVersion)
+	return response
+}
+
+// applyEDNS makes the response's OPT record match the client's request.
+// Clients that sent an OPT record get one back with our payload size and
+// their DO bit; any extended RCODE or options the handler set are kept.
+// Clients that did not use EDNS must not receive an OPT record.
+func applyEDNS(req ednsRequest, response *message.Message) {
+	if !req.present {
+		response.RemoveEDNS()
+		return
+	}
+
+	edns, _ := response.EDNS()
+	edns.UDPSize = ednsUDPSize
+	edns.Version = message.EDNSVersion
+	edns.DO = req.edns.DO
+	response.SetEDNS(edns)
+}
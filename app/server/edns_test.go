@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func TestUDPLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ednsRequest
+		want int
+	}{
+		{"no EDNS", ednsRequest{}, message.MinUDPSize},
+		{"small buffer", ednsRequest{present: true, edns: message.EDNS{UDPSize: 100}}, message.MinUDPSize},
+		{"client limit", ednsRequest{present: true, edns: message.EDNS{UDPSize: 1000}}, 1000},
+		{"server limit", ednsRequest{present: true, edns: message.EDNS{UDPSize: 4096}}, ednsUDPSize},
+	}
+	for _, tt := range tests {
+		if got := tt.req.udpLimit(); got != tt.want {
+			t.Errorf("%s: udpLimit = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExchangeEDNS(t *testing.T) {
+	query := func(edns *message.EDNS) []byte {
+		msg := message.Message{
+			Header:    message.Header{ID: 9, RD: 1},
+			Questions: []message.Question{{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}},
+		}
+		if edns != nil {
+			msg.SetEDNS(*edns)
+		}
+		return msg.Encode()
+	}
+	// nxdomain also adds an OPT record, which clients without EDNS must
+	// not get
+	nxdomain := handlerFunc(func(ctx context.Context, data []byte) (message.Message, error) {
+		response, err := answerA(ctx, data)
+		response.Answers = nil
+		response.SetRCode(message.RCodeNameError)
+		response.AddExtendedError(message.EDEOther, "")
+		return response, err
+	})
+	tests := []struct {
+		name    string
+		handler MessageHandler
+		edns    *message.EDNS
+		rcode   int
+		opt     bool
+		do      bool
+		answers int
+	}{
+		{"no EDNS", answerA, nil, message.RCodeSuccess, false, false, 1},
+		{"EDNS", answerA, &message.EDNS{UDPSize: 4096}, message.RCodeSuccess, true, false, 1},
+		{"DO echoed", answerA, &message.EDNS{UDPSize: 4096, DO: true}, message.RCodeSuccess, true, true, 1},
+		{"version 1", answerA, &message.EDNS{UDPSize: 4096, Version: 1}, message.RCodeBadVersion, true, false, 0},
+		{"OPT removed without EDNS", nxdomain, nil, message.RCodeNameError, false, false, 0},
+		{"handler options kept", nxdomain, &message.EDNS{UDPSize: 4096}, message.RCodeNameError, true, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, _, err := exchange(context.Background(), tt.handler, testLogger(), query(tt.edns), RequestInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := response.RCode(); got != tt.rcode {
+				t.Errorf("got %s, want %s", message.RCodeString(got), message.RCodeString(tt.rcode))
+			}
+			if len(response.Answers) != tt.answers {
+				t.Errorf("%d answers, want %d", len(response.Answers), tt.answers)
+			}
+			edns, ok := response.EDNS()
+			if ok != tt.opt {
+				t.Fatalf("has OPT = %v, want %v", ok, tt.opt)
+			}
+			if !ok {
+				return
+			}
+			if edns.UDPSize != ednsUDPSize || edns.Version != message.EDNSVersion || edns.DO != tt.do {
+				t.Errorf("OPT = %+v, want size %d, version %d and DO %v", edns, ednsUDPSize, message.EDNSVersion, tt.do)
+			}
+			if tt.rcode == message.RCodeNameError && len(response.ExtendedErrors()) != 1 {
+				t.Error("handler's extended error lost")
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+// exchange runs a raw query through the message handler and applies the
+// EDNS processing shared by every transport. info is attached to the
+// handler's context. The client's EDNS parameters are returned so the
+// caller can apply its own size limits.
+func exchange(ctx context.Context, h MessageHandler, log *gotracer.Logger, data []byte, info RequestInfo) (message.Message, ednsRequest, error) {
+	request, edns := parseEDNSRequest(data)
+	if edns.unsupportedVersion() {
+		log.Debugf("Rejecting unsupported EDNS version", map[string]interface{}{
+			"client":  info.Client.String(),
+			"version": edns.edns.Version,
+		})
+		return badVersionResponse(request), edns, nil
+	}
+
+	response, err := h.Handle(WithRequestInfo(ctx, info), data)
+	if err != nil {
+		return message.Message{}, edns, err
+	}
+	applyEDNS(edns, &response)
+
+	return response, edns, nil
+}
+
+// recoverPanic logs a panic raised while serving client, so that one bad
+// request drops only its own reply rather than the whole server. It must
+// be deferred directly.
+func recoverPanic(log *gotracer.Logger, client string) {
+	if r := recover(); r != nil {
+		log.Errorf("Recovered from panic while handling request", map[string]interface{}{
+			"panic":  fmt.Sprint(r),
+			"client": client,
+			"stack":  string(debug.Stack()),
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"net/netip"
 	"strings"
 
 	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
 	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
 )
 
@@ -12,25 +15,69 @@ import (
 // It provides a method to process incoming data and return a response or an error.
 type MessageHandler interface {
 	// Handle processes the given byte slice representing a DNS message.
-	// It returns a byte slice containing the response or an error if processing fails.
-	Handle(data []byte) (message.Message, error)
+	// It returns the response message or an error if processing fails.
+	// ctx is cancelled if the server gives up on the request, for example
+	// when a shutdown deadline expires. data may be reused once Handle
+	// returns, so implementations must not retain it.
+	Handle(ctx context.Context, data []byte) (message.Message, error)
 }
 
 // DefaultMessageHandler is a default implementation of the MessageHandler interface.
 // It uses a logger to log information about the DNS message processing.
 type DefaultMessageHandler struct {
-	log *gotracer.Logger
+	log      *gotracer.Logger
+	zones    *zone.Store
+	resolver MessageHandler
+
+	// transferACL lists the client prefixes allowed to transfer zones
+	transferACL []netip.Prefix
+
+	// updateACL lists the client prefixes allowed to send dynamic updates
+	updateACL []netip.Prefix
+
+	// tsigKeys holds the keys signed requests are verified with, by
+	// lowercased name; transferKeys and updateKeys name those that
+	// authorise zone transfers and dynamic updates
+	tsigKeys     map[string]message.TSIGKey
+	transferKeys []string
+	updateKeys   []string
+
+	// secondaries are the zones pulled from a primary, told about NOTIFYs
+	secondaries []*zone.Secondary
+}
+
+// HandlerOption configures a DefaultMessageHandler.
+type HandlerOption func(*DefaultMessageHandler)
+
+// WithResolver makes the handler answer queries through r, such as a
+// forwarding or recursive resolver, instead of synthesizing answers.
+func WithResolver(r MessageHandler) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.resolver = r
+	}
+}
+
+// WithZones makes the handler answer authoritatively for the zones in
+// store. Questions outside them go to the resolver, if there is one.
+func WithZones(store *zone.Store) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.zones = store
+	}
 }
 
 // NewDefaultMessageHandler creates a new instance of DefaultMessageHandler.
 // It takes a logger as an argument to enable logging of message handling activities.
-func NewDefaultMessageHandler(log *gotracer.Logger) *DefaultMessageHandler {
-	return &DefaultMessageHandler{
+func NewDefaultMessageHandler(log *gotracer.Logger, opts ...HandlerOption) *DefaultMessageHandler {
+	h := &DefaultMessageHandler{
 		log: log,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
-func (h *DefaultMessageHandler) Handle(data []byte) (message.Message, error) {
+func (h *DefaultMessageHandler) Handle(ctx context.Context, data []byte) (message.Message, error) {
 	h.log.Debugf("Parsing DNS message", map[string]interface{}{
 		"data_length": len(data),
 		"raw_data":    fmt.Sprintf("%v", data),
@@ -48,8 +95,9 @@ func (h *DefaultMessageHandler) Handle(data []byte) (message.Message, error) {
 		"question_count": header.QDCount,
 	})
 
-	// If opcode is not a standard query (0), return NotImplemented (4)
-	if header.Opcode != message.StandardQuery {
+	// Only standard queries (0), NOTIFY (4) and UPDATE (5) are supported;
+	// anything else gets NotImplemented (4)
+	if header.Opcode != message.StandardQuery && header.Opcode != message.Notify && header.Opcode != message.Update {
 		responseHeader := header
 		responseHeader.QR = 1    // Response
 		responseHeader.RCode = 4 // Not Implemented
@@ -59,26 +107,30 @@ func (h *DefaultMessageHandler) Handle(data []byte) (message.Message, error) {
 			Header:     responseHeader,
 			Questions:  []message.Question{},
 			Answers:    []message.Answer{},
-			Authority:  []byte{},
-			Additional: []byte{},
+			Authority:  []message.Answer{},
+			Additional: []message.Answer{},
 		}, nil
 	}
 
 	var questions []message.Question
-	var answers []message.Answer
 	offset := 12 // Start after header
 
 	// Parse all questions
 	for i := uint16(0); i < header.QDCount; i++ {
-		h.log.Debugf("Starting question parse", map[string]interface{}{
+		// The message may end before the questions its header promises;
+		// ParseQuestion reports that, so only log what is there
+		fields := map[string]interface{}{
 			"question_number": i + 1,
 			"offset":          offset,
-			"data_slice":      fmt.Sprintf("%v", data[offset:]),
-			"first_byte":      fmt.Sprintf("0x%02x", data[offset]),
-		})
+			"data_slice":      fmt.Sprintf("%v", data[min(offset, len(data)):]),
+		}
+		if offset < len(data) {
+			fields["first_byte"] = fmt.Sprintf("0x%02x", data[offset])
+		}
+		h.log.Debugf("Starting question parse", fields)
 
 		// Check for compression pointer
-		if offset < len(data) && (data[offset]&0xC0) == 0xC0 {
+		if offset+1 < len(data) && (data[offset]&0xC0) == 0xC0 {
 			h.log.Debugf("Detected compression pointer", map[string]interface{}{
 				"pointer_bytes": fmt.Sprintf("0x%02x%02x", data[offset], data[offset+1]),
 				"offset":        offset,
@@ -92,7 +144,7 @@ func (h *DefaultMessageHandler) Handle(data []byte) (message.Message, error) {
 				"error":        err.Error(),
 				"offset":       offset,
 				"data_length":  len(data),
-				"partial_data": fmt.Sprintf("%v", data[offset:min(offset+10, len(data))]),
+				"partial_data": fmt.Sprintf("%v", data[min(offset, len(data)):min(offset+10, len(data))]),
 			})
 			return message.Message{}, fmt.Errorf("failed to parse question %d: %w", i, err)
 		}
@@ -110,81 +162,168 @@ func (h *DefaultMessageHandler) Handle(data []byte) (message.Message, error) {
 		offset += bytesRead
 	}
 
-	// Create answers for each question
-	for _, question := range questions {
-		answer := message.Answer{
-			Name:   question.Name,
-			Type:   question.Type,
-			Class:  question.Class,
-			TTL:    60,
-			Length: 4,
-			RData:  []byte{8, 8, 8, 8},
+	// A signed request gets a response signed with the same key, or a TSIG
+	// error if its signature does not check out (RFC 8945 §5.2)
+	signer, err := message.VerifyTSIG(data, h.tsigKey)
+	if err != nil {
+		info, _ := RequestInfoFromContext(ctx)
+		h.log.Info.Printf("Rejected request from %s: %v", info.Client.Addr(), err)
+		rcode := message.RCodeNotAuth
+		if signer == nil {
+			rcode = message.RCodeFormatError
 		}
-		answers = append(answers, answer)
+		msg := rcodeResponse(header, questions, rcode)
+		msg.SetTSIG(signer)
+		return msg, nil
 	}
 
-	// Update response header
-	responseHeader := header
-	responseHeader.QR = 1                   // Set QR bit to 1 for response
-	responseHeader.ANCount = header.QDCount // One answer per question
+	var msg message.Message
+	switch {
+	case header.Opcode == message.Notify:
+		msg = h.notify(ctx, header, questions, signer)
+	case header.Opcode == message.Update:
+		msg = h.update(ctx, data, header, questions, signer)
+	case len(questions) == 1 && (questions[0].Type == message.TypeAXFR || questions[0].Type == message.TypeIXFR):
+		// Zone transfers stream the whole zone rather than answering a question
+		if msg, err = h.transfer(ctx, data, header, questions[0], signer); err != nil {
+			return message.Message{}, err
+		}
+	default:
+		msg = h.answer(ctx, data, header, questions)
 
-	msg := message.Message{
-		Header:     responseHeader,
-		Questions:  questions,
-		Answers:    answers,
-		Authority:  []byte{},
-		Additional: []byte{},
+		h.log.Debugf("Created DNS response", map[string]interface{}{
+			"rcode":         message.RCodeString(msg.RCode()),
+			"answers":       len(msg.Answers),
+			"authoritative": msg.Header.AA == 1,
+		})
 	}
 
-	h.log.Debugf("Created DNS response", map[string]interface{}{
-		"answers":       len(answers),
-		"response_size": len(msg.Encode()),
-	})
-
+	msg.SetTSIG(signer)
 	return msg, nil
 }
 
-// buildResponseHeader creates a response header based on the request header
-func (h *DefaultMessageHandler) buildResponseHeader(header message.Header) message.Header {
-	const (
-		ResponseBit = 1
-		NoError     = 0
-		NotImpl     = 4
-	)
+// answer builds the response to questions. Each question is answered from
+// the zone that contains it or, failing that, through the resolver as its
+// own query, since resolvers generally answer only the first question. The
+// results are merged back under the original ID and flags. A handler
+// with neither zones nor a resolver answers every question with an A
+// record for 8.8.8.8. Otherwise a question that can be answered neither
+// way is REFUSED, and if any resolver lookup fails the client gets
+// SERVFAIL.
+func (h *DefaultMessageHandler) answer(ctx context.Context, data []byte, header message.Header, questions []message.Question) message.Message {
+	var edns message.EDNS
+	var hasEDNS bool
+	if request, err := message.ParseMessage(data); err == nil {
+		edns, hasEDNS = request.EDNS()
+	}
+
+	responseHeader := header
+	responseHeader.QR = 1
+	responseHeader.AA = 0
+	responseHeader.TC = 0
+	responseHeader.RA = 0
+	if h.resolver != nil {
+		responseHeader.RA = 1
+	}
+	responseHeader.Z = header.Z & message.ZCheckingDisabled
+	response := message.Message{Header: responseHeader, Questions: questions}
+
+	rcode := message.RCodeSuccess
+	authoritative := len(questions) > 0
+	authenticated := len(questions) > 0
+	var extendedErrors []message.EDNSOption
+	for _, question := range questions {
+		var result message.Message
+		if z, ok := h.findZone(question); ok {
+			result = z.Lookup(question, hasEDNS && edns.DO)
+		} else if h.resolver != nil {
+			query := message.Message{
+				Header: message.Header{
+					ID: message.RandomID(),
+					RD: header.RD,
+					Z:  header.Z & (message.ZCheckingDisabled | message.ZAuthenticData),
+				},
+				Questions: []message.Question{question},
+			}
+			if hasEDNS {
+				query.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: edns.DO})
+			}
 
-	response := header
-	response.QR = ResponseBit
-	response.AA = 0
-	response.TC = 0
-	response.RA = 0
-	response.Z = 0
-	response.ANCount = 1
+			var err error
+			if result, err = h.resolver.Handle(ctx, query.Encode()); err != nil {
+				h.log.Errorf("Failed to resolve question", map[string]interface{}{
+					"error": err.Error(),
+					"name":  question.Name,
+					"type":  message.TypeString(question.Type),
+				})
+				failure := rcodeResponse(header, questions, message.RCodeServerFailure)
+				failure.Header.RA = 1
+				return failure
+			}
+		} else if h.zones == nil {
+			// With nothing configured to answer from, every name gets
+			// the starter's placeholder address
+			result.Answers = []message.Answer{*message.NewAnswer(question.Name)}
+		} else {
+			return rcodeResponse(header, questions, message.RCodeRefused)
+		}
 
-	if header.Opcode == message.StandardQuery {
-		response.RCode = NoError
-	} else {
-		response.RCode = NotImpl
+		if rcode == message.RCodeSuccess {
+			rcode = result.RCode()
+		}
+		if result.Header.AA == 0 {
+			authoritative = false
+		}
+		if result.Header.Z&message.ZAuthenticData == 0 {
+			authenticated = false
+		}
+		extendedErrors = append(extendedErrors, result.ExtendedErrors()...)
+		response.Answers = append(response.Answers, result.Answers...)
+		response.Authority = append(response.Authority, result.Authority...)
+		for _, rr := range result.Additional {
+			if rr.Type != message.TypeOPT {
+				response.Additional = append(response.Additional, rr)
+			}
+		}
 	}
 
+	if len(extendedErrors) > 0 {
+		response.SetEDNS(message.EDNS{UDPSize: message.MinUDPSize, Options: extendedErrors})
+	}
+	response.SetRCode(rcode)
+	if authoritative {
+		response.Header.AA = 1
+	}
+	if authenticated {
+		response.Header.Z |= message.ZAuthenticData
+	}
 	return response
 }
 
-// buildAnswer creates an answer section for the DNS response
-func (h *DefaultMessageHandler) buildAnswer(question message.Question) message.Answer {
-	const (
-		DefaultTTL    = 60
-		IPv4Length    = 4
-		DefaultIPAddr = 0x08080808 // 8.8.8.8
-	)
-
-	return message.Answer{
-		Name:   question.Name,
-		Type:   question.Type,
-		Class:  question.Class,
-		TTL:    DefaultTTL,
-		Length: IPv4Length,
-		RData:  []byte{8, 8, 8, 8},
+// findZone returns the loaded zone that holds the answer to q, if any.
+func (h *DefaultMessageHandler) findZone(q message.Question) (*zone.Zone, bool) {
+	if h.zones == nil {
+		return nil, false
 	}
+	z, ok := h.zones.Find(q.Name)
+	if !ok || z.Class() != q.Class {
+		return nil, false
+	}
+	return z, true
+}
+
+// rcodeResponse builds an empty response to a query that echoes its
+// questions and carries rcode.
+func rcodeResponse(header message.Header, questions []message.Question, rcode int) message.Message {
+	responseHeader := header
+	responseHeader.QR = 1
+	responseHeader.AA = 0
+	responseHeader.TC = 0
+	responseHeader.RA = 0
+	responseHeader.Z = 0
+	response := message.Message{Header: responseHeader, Questions: questions}
+	response.SetRCode(rcode)
+	return response
 }
 
 func min(a, b int) int {
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+func testLogger() *gotracer.Logger {
+	log := gotracer.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// A header promising more questions than the message holds is a parse
+// error, not a panic, even with debug logging on.
+func TestHandleTruncatedQuestions(t *testing.T) {
+	h := NewDefaultMessageHandler(testLogger())
+	header := message.Header{ID: 1, QDCount: 1}
+	data := header.Encode()
+	for _, data := range [][]byte{data, append(data, 0xC0)} {
+		if _, err := h.Handle(context.Background(), data); err == nil {
+			t.Errorf("%d-byte query: no error", len(data))
+		}
+	}
+}
+
+func TestHandleDefaultAnswers(t *testing.T) {
+	query := message.Message{
+		Header: message.Header{ID: 7, RD: 1},
+		Questions: []message.Question{
+			{Name: "codecrafters.io", Type: message.TypeA, Class: message.ClassINET},
+			{Name: "example.com", Type: message.TypeA, Class: message.ClassINET},
+		},
+	}
+	tests := []struct {
+		name    string
+		opts    []HandlerOption
+		rcode   int
+		answers int
+	}{
+		{"nothing configured", nil, message.RCodeSuccess, 2},
+		{"zones without a resolver", []HandlerOption{WithZones(zone.NewStore())}, message.RCodeRefused, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewDefaultMessageHandler(testLogger(), tt.opts...)
+			response, err := h.Handle(context.Background(), query.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.RCode() != tt.rcode {
+				t.Errorf("got %s, want %s", message.RCodeString(response.RCode()), message.RCodeString(tt.rcode))
+			}
+			if len(response.Answers) != tt.answers {
+				t.Fatalf("%d answers, want %d", len(response.Answers), tt.answers)
+			}
+			for i, rr := range response.Answers {
+				a, ok := rr.RData.(*message.A)
+				if !ok || rr.Name != query.Questions[i].Name || a.Addr.String() != "8.8.8.8" {
+					t.Errorf("answer %d = %v, want %s A 8.8.8.8", i, rr, query.Questions[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleResolverSplitsQuestions(t *testing.T) {
+	query := message.Message{
+		Header: message.Header{ID: 7, RD: 1},
+		Questions: []message.Question{
+			{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET},
+			{Name: "missing.example.com", Type: message.TypeA, Class: message.ClassINET},
+		},
+	}
+	tests := []struct {
+		name    string
+		fail    string // the question name the resolver fails on
+		rcode   int
+		answers int
+	}{
+		{"merged", "", message.RCodeSuccess, 2},
+		{"lookup fails", "missing.example.com", message.RCodeServerFailure, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstream []message.Message
+			resolver := handlerFunc(func(ctx context.Context, data []byte) (message.Message, error) {
+				q, err := message.ParseMessage(data)
+				if err != nil {
+					return message.Message{}, err
+				}
+				upstream = append(upstream, q)
+				if q.Questions[0].Name == tt.fail {
+					return message.Message{}, context.DeadlineExceeded
+				}
+				return answerA(ctx, data)
+			})
+			h := NewDefaultMessageHandler(testLogger(), WithResolver(resolver))
+			response, err := h.Handle(context.Background(), query.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, q := range upstream {
+				if len(q.Questions) != 1 || q.Questions[0] != query.Questions[i] || q.Header.RD != 1 {
+					t.Errorf("upstream query %d = %+v, want question %d alone with RD", i, q, i)
+				}
+			}
+			if response.Header.ID != 7 || response.Header.QR != 1 || response.Header.RA != 1 {
+				t.Errorf("header = %+v, want the query's ID with QR and RA", response.Header)
+			}
+			if len(response.Questions) != 2 {
+				t.Errorf("%d questions echoed, want 2", len(response.Questions))
+			}
+			if response.RCode() != tt.rcode {
+				t.Errorf("got %s, want %s", message.RCodeString(response.RCode()), message.RCodeString(tt.rcode))
+			}
+			if len(response.Answers) != tt.answers {
+				t.Errorf("%d answers, want %d", len(response.Answers), tt.answers)
+			}
+		})
+	}
+}
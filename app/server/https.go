@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// dohPath is the conventional URI path for DNS-over-HTTPS queries.
+	dohPath = "/dns-query"
+
+	// dohContentType is the media type of wire-format DNS messages (RFC 8484 §6).
+	dohContentType = "application/dns-message"
+
+	// httpHeaderTimeout and httpReadTimeout bound how long a client may
+	// take to send a request's headers and the whole request, so one
+	// trickling a POST body cannot hold a connection open.
+	httpHeaderTimeout = 10 * time.Second
+	httpReadTimeout   = 15 * time.Second
+
+	// httpWriteTimeout bounds the time from reading a request's headers to
+	// finishing its response, resolution included.
+	httpWriteTimeout = 30 * time.Second
+)
+
+// HTTPServer represents a DNS-over-HTTPS server (RFC 8484). Queries arrive
+// as wire-format messages, base64url-encoded in the dns parameter of a GET
+// or as the body of a POST. The same server offers a JSON API on /resolve.
+// Without a certificate it serves plain HTTP, which is useful behind a
+// TLS-terminating proxy.
+type HTTPServer struct {
+	config
+	addr  string
+	certs *certReloader
+	log   *gotracer.Logger
+	mux   *http.ServeMux
+
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+// NewHTTP creates a new DNS-over-HTTP server instance without TLS.
+// Unless WithMessageHandler is given, queries are answered by a DefaultMessageHandler.
+func NewHTTP(addr string, log *gotracer.Logger, opts ...Option) *HTTPServer {
+	s := &HTTPServer{
+		config: newConfig(opts),
+		addr:   addr,
+		log:    log,
+		mux:    http.NewServeMux(),
+	}
+	if s.messageHandler == nil {
+		s.messageHandler = NewDefaultMessageHandler(log)
+	}
+	s.mux.HandleFunc(dohPath, s.handleDNSQuery)
+	s.mux.HandleFunc(jsonPath, s.handleJSONResolve)
+	return s
+}
+
+// NewHTTPS creates a new DNS-over-HTTPS server instance using the
+// certificate and key at certFile and keyFile.
+func NewHTTPS(addr, certFile, keyFile string, log *gotracer.Logger, opts ...Option) (*HTTPServer, error) {
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := certs.reload(); err != nil {
+		return nil, err
+	}
+
+	s := NewHTTP(addr, log, opts...)
+	s.certs = certs
+	return s, nil
+}
+
+// Start listens on the server address and serves HTTP requests until ctx
+// is cancelled, Shutdown is called or the listener fails.
+func (s *HTTPServer) Start(ctx context.Context) error {
+	s.log.Info.Println("Starting HTTP server setup...")
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.certs != nil {
+		listener = tls.NewListener(listener, &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"h2", "http/1.1"},
+			GetCertificate: s.certs.getCertificate,
+		})
+	}
+
+	// Request contexts keep ctx's values but outlive its cancellation, so
+	// in-flight requests are drained by Shutdown rather than aborted
+	baseCtx := context.WithoutCancel(ctx)
+	srv := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: httpHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       s.tcpIdleTimeout,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-stopped:
+		}
+	}()
+
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for in-flight requests to
+// complete. If ctx expires first, remaining connections are closed and
+// ctx's error is returned.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+
+	s.log.Info.Println("Shutting down HTTP server...")
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
+}
+
+// Reload reads the certificate and key from disk again. It does nothing
+// for servers without TLS.
+func (s *HTTPServer) Reload() error {
+	if s.certs == nil {
+		return nil
+	}
+	if err := s.certs.reload(); err != nil {
+		return err
+	}
+	s.log.Info.Println("Reloaded HTTPS certificate")
+	return nil
+}
+
+// handleDNSQuery answers a single wire-format DNS query.
+func (s *HTTPServer) handleDNSQuery(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	switch r.Method {
+	case http.MethodGet:
+		// RFC 8484 §4.1 uses unpadded base64url, but tolerate padding
+		encoded := strings.TrimRight(r.URL.Query().Get("dns"), "=")
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil || len(decoded) == 0 {
+			http.Error(w, "missing or invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+		data = decoded
+
+	case http.MethodPost:
+		// Media type parameters such as charset are allowed but ignored
+		ct := r.Header.Get("Content-Type")
+		if mediaType, _, err := mime.ParseMediaType(ct); err != nil || mediaType != dohContentType {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxTCPMessageSize+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) == 0 || len(body) > maxTCPMessageSize {
+			http.Error(w, "invalid DNS message size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		data = body
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.log.Debugf("Processing DNS request", map[string]interface{}{
+		"client":    r.RemoteAddr,
+		"data_size": len(data),
+		"transport": "https",
+		"method":    r.Method,
+	})
+
+	response, _, err := exchange(r.Context(), s.messageHandler, s.log, data, httpRequestInfo(r))
+	if err != nil {
+		s.log.Errorf("Failed to handle request", map[string]interface{}{
+			"error":  err.Error(),
+			"client": r.RemoteAddr,
+		})
+		http.Error(w, "malformed DNS query", http.StatusBadRequest)
+		return
+	}
+
+	response.Truncate(maxTCPMessageSize)
+	encoded := response.Encode()
+
+	w.Header().Set("Content-Type", dohContentType)
+	if ttl, ok := cacheLifetime(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(len(encoded)))
+	if _, err := w.Write(encoded); err != nil {
+		s.log.Errorf("Failed to send response", map[string]interface{}{
+			"error":  err.Error(),
+			"client": r.RemoteAddr,
+		})
+	}
+}
+
+// httpRequestInfo describes the client of an HTTP request.
+func httpRequestInfo(r *http.Request) RequestInfo {
+	client, _ := netip.ParseAddrPort(r.RemoteAddr)
+	return RequestInfo{
+		Client:    netip.AddrPortFrom(client.Addr().Unmap(), client.Port()),
+		Transport: TransportHTTPS,
+	}
+}
+
+// cacheLifetime returns the HTTP freshness lifetime of a response: the
+// minimum TTL in the answer section (RFC 8484 §5.1). Negative answers fall
+// back to the authority section, where the SOA bounds negative caching.
+// It reports false if the response has no records to derive a lifetime from.
+func cacheLifetime(response message.Message) (uint32, bool) {
+	for _, section := range [][]message.Answer{response.Answers, response.Authority} {
+		var ttl uint32
+		found := false
+		for _, a := range section {
+			if !found || a.TTL < ttl {
+				ttl = a.TTL
+				found = true
+			}
+		}
+		if found {
+			return ttl, true
+		}
+	}
+	return 0, false
+}
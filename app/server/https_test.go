@@ -0,0 +1,39 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func TestDoHPostContentType(t *testing.T) {
+	s := NewHTTP("127.0.0.1:0", testLogger(), WithMessageHandler(answerA))
+	query := message.Message{
+		Header:    message.Header{ID: 1, RD: 1},
+		Questions: []message.Question{{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}},
+	}
+	tests := []struct {
+		contentType string
+		status      int
+	}{
+		{"application/dns-message", http.StatusOK},
+		{"application/dns-message; charset=utf-8", http.StatusOK},
+		{"Application/DNS-Message", http.StatusOK},
+		{"application/json", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, dohPath, bytes.NewReader(query.Encode()))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("Content-Type %q: status %d, want %d", tt.contentType, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// jsonPath is the URI path of the JSON resolve API.
+const jsonPath = "/resolve"
+
+// jsonResponse is the JSON form of a DNS response, following the format
+// used by the Google and Cloudflare JSON APIs.
+type jsonResponse struct {
+	Status    int            `json:"Status"`
+	TC        bool           `json:"TC"`
+	RD        bool           `json:"RD"`
+	RA        bool           `json:"RA"`
+	AD        bool           `json:"AD"`
+	CD        bool           `json:"CD"`
+	Question  []jsonQuestion `json:"Question"`
+	Answer    []jsonRecord   `json:"Answer,omitempty"`
+	Authority []jsonRecord   `json:"Authority,omitempty"`
+}
+
+type jsonQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type jsonRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// handleJSONResolve answers GET /resolve?name=example.com&type=AAAA with a
+// JSON document. The query is built as a wire-format message and sent
+// through the same handler pipeline as every other transport. The optional
+// cd and do parameters set the CD bit and the EDNS DO bit.
+func (s *HTTPServer) handleJSONResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	name := params.Get("name")
+	if !validName(name) {
+		http.Error(w, "missing or invalid name parameter", http.StatusBadRequest)
+		return
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	qtype := message.TypeA
+	if t := params.Get("type"); t != "" {
+		var err error
+		if qtype, err = message.ParseType(t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := message.Message{
+		Header:    message.Header{ID: message.RandomID(), RD: 1},
+		Questions: []message.Question{{Name: name, Type: qtype, Class: message.ClassINET}},
+	}
+	if jsonFlag(params.Get("cd")) {
+		query.Header.Z |= message.ZCheckingDisabled
+	}
+	if jsonFlag(params.Get("do")) {
+		query.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: true})
+	}
+
+	s.log.Debugf("Processing JSON request", map[string]interface{}{
+		"client": r.RemoteAddr,
+		"name":   name,
+		"type":   message.TypeString(qtype),
+	})
+
+	response, _, err := exchange(r.Context(), s.messageHandler, s.log, query.Encode(), httpRequestInfo(r))
+	if err != nil {
+		s.log.Errorf("Failed to handle request", map[string]interface{}{
+			"error":  err.Error(),
+			"client": r.RemoteAddr,
+		})
+		http.Error(w, "failed to resolve query", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(newJSONResponse(response))
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if ttl, ok := cacheLifetime(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+	}
+	if _, err := w.Write(body); err != nil {
+		s.log.Errorf("Failed to send response", map[string]interface{}{
+			"error":  err.Error(),
+			"client": r.RemoteAddr,
+		})
+	}
+}
+
+// newJSONResponse converts a DNS response to its JSON form. The OPT
+// pseudo-record is not a real record and is left out.
+func newJSONResponse(response message.Message) jsonResponse {
+	h := response.Header
+	out := jsonResponse{
+		Status:   response.RCode(),
+		TC:       h.TC == 1,
+		RD:       h.RD == 1,
+		RA:       h.RA == 1,
+		AD:       h.Z&message.ZAuthenticData != 0,
+		CD:       h.Z&message.ZCheckingDisabled != 0,
+		Question: make([]jsonQuestion, 0, len(response.Questions)),
+	}
+	for _, q := range response.Questions {
+		out.Question = append(out.Question, jsonQuestion{Name: message.Fqdn(q.Name), Type: q.Type})
+	}
+	out.Answer = jsonRecords(response.Answers)
+	out.Authority = jsonRecords(response.Authority)
+	return out
+}
+
+func jsonRecords(records []message.Answer) []jsonRecord {
+	var out []jsonRecord
+	for _, a := range records {
+		if a.Type == message.TypeOPT {
+			continue
+		}
+		var data string
+		if a.RData != nil {
+			data = a.RData.String()
+		}
+		out = append(out, jsonRecord{Name: message.Fqdn(a.Name), Type: a.Type, TTL: a.TTL, Data: data})
+	}
+	return out
+}
+
+// jsonFlag interprets a boolean query parameter the way the public JSON
+// APIs do: "1" and "true" are true, anything else is false.
+func jsonFlag(v string) bool {
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// validName reports whether name, with or without the trailing dot, can
+// be sent in a query: it is "." for the root, or its labels are 1 to 63
+// bytes long and its wire form, with a length byte per label and the root
+// label, fits in 255 bytes (RFC 1035 §2.3.4).
+func validName(name string) bool {
+	if name == "." {
+		return true
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name)+2 > 255 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
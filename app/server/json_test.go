@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestJSONResolveNames(t *testing.T) {
+	s := NewHTTP("127.0.0.1:0", testLogger(), WithMessageHandler(answerA))
+	label63 := strings.Repeat("a", 63)
+	// Four 63-byte labels and the root take 4*64+1 = 257 bytes on the wire
+	long := strings.Join([]string{label63, label63, label63, label63}, ".")
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"www.example.com", http.StatusOK},
+		{"www.example.com.", http.StatusOK},
+		{label63 + ".example.com", http.StatusOK},
+		{long[:253], http.StatusOK},
+		{".", http.StatusOK},
+		{"", http.StatusBadRequest},
+		{"..", http.StatusBadRequest},
+		{label63 + "a.example.com", http.StatusBadRequest},
+		{long, http.StatusBadRequest},
+		{long[:254], http.StatusBadRequest},
+		{"www..example.com", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, jsonPath+"?name="+url.QueryEscape(tt.name), nil)
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("name %q (%d bytes): status %d, want %d", tt.name, len(tt.name), w.Code, tt.status)
+		}
+	}
+}
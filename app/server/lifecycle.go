@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// lifecycle tracks a server's in-flight work and coordinates its shutdown.
+// Every transport embeds one: the serve loop registers work with track,
+// and Shutdown stops intake, drains the work and then releases sockets.
+type lifecycle struct {
+	mu sync.Mutex
+
+	// baseCtx is the parent of every request context. It keeps the values
+	// of the context passed to Start but is only cancelled when a shutdown
+	// deadline expires, so in-flight requests are not aborted early.
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+
+	stopping chan struct{}
+	stopOnce sync.Once
+	inFlight sync.WaitGroup
+
+	// stopIntake makes the serve loop stop accepting new work
+	stopIntake func()
+	// release frees the server's sockets once in-flight work has finished
+	release     func()
+	releaseOnce sync.Once
+}
+
+// begin prepares the lifecycle for a server started with ctx. When ctx is
+// cancelled the server shuts down, waiting for in-flight work to finish.
+func (l *lifecycle) begin(ctx context.Context, stopIntake, release func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.baseCtx, l.cancelBase = context.WithCancel(context.WithoutCancel(ctx))
+	l.stopping = make(chan struct{})
+	l.stopIntake = stopIntake
+	l.release = release
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.shutdown(context.Background())
+		case <-l.stopping:
+		}
+	}()
+}
+
+// isStopping reports whether shutdown has begun.
+func (l *lifecycle) isStopping() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// track registers a unit of in-flight work. The returned function must be
+// called when the work is done. Once shutdown has begun it reports false
+// and the work must be dropped instead: registering it under the same
+// lock that shutdown takes to begin ensures no work is added while
+// shutdown waits for the rest.
+func (l *lifecycle) track() (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.stopping:
+		return nil, false
+	default:
+	}
+	l.inFlight.Add(1)
+	return l.inFlight.Done, true
+}
+
+// shutdown stops intake and waits for in-flight work until ctx is done.
+// If ctx expires first, request contexts are cancelled so handlers can
+// abandon their work. Sockets are released either way. It is safe to call
+// shutdown more than once and from several goroutines.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	started := l.stopping != nil
+	l.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	l.stopOnce.Do(func() {
+		l.mu.Lock()
+		close(l.stopping)
+		l.mu.Unlock()
+		l.stopIntake()
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		l.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		l.cancelBase()
+	}
+
+	l.releaseOnce.Do(func() {
+		l.cancelBase()
+		l.release()
+	})
+	return err
+}
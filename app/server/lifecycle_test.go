@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestLifecycleRefusesWorkAfterShutdown(t *testing.T) {
+	var l lifecycle
+	l.begin(context.Background(), func() {}, func() {})
+
+	done, ok := l.track()
+	if !ok {
+		t.Fatal("track refused work before shutdown")
+	}
+	shutdown := make(chan error)
+	go func() { shutdown <- l.shutdown(context.Background()) }()
+
+	// Work registered concurrently with shutdown is either waited for or
+	// refused, never added while shutdown waits
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if done, ok := l.track(); ok {
+				done()
+			}
+		}()
+	}
+	wg.Wait()
+	done()
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.track(); ok {
+		t.Error("track accepted work after shutdown")
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+)
+
+// WithSecondaries lets the handler pass NOTIFY messages for the zones of
+// secondaries on to them.
+func WithSecondaries(secondaries ...*zone.Secondary) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.secondaries = append(h.secondaries, secondaries...)
+	}
+}
+
+// notify answers a NOTIFY message (RFC 1996), which a primary sends when
+// a zone changes. If the zone is one we pull from that primary, the
+// secondary checks for the change straight away rather than waiting for
+// its refresh timer. NOTIFYs from any other address are refused, as are
+// those not signed with the zone's transfer key when it has one.
+func (h *DefaultMessageHandler) notify(ctx context.Context, header message.Header, questions []message.Question, signer *message.TSIGSigner) message.Message {
+	info, _ := RequestInfoFromContext(ctx)
+	if len(questions) != 1 || questions[0].Type != message.TypeSOA {
+		return rcodeResponse(header, questions, message.RCodeFormatError)
+	}
+	q := questions[0]
+
+	secondary, ok := h.secondary(q.Name)
+	if !ok {
+		return rcodeResponse(header, questions, message.RCodeNotAuth)
+	}
+	if info.Client.Addr() != secondary.Primary().Addr() {
+		h.log.Info.Printf("Refused NOTIFY for %s from %s, which is not its primary", message.Fqdn(q.Name), info.Client.Addr())
+		return rcodeResponse(header, questions, message.RCodeRefused)
+	}
+	if key, ok := secondary.Key(); ok && !signedWith(signer, []string{key.Name}) {
+		h.log.Info.Printf("Refused NOTIFY for %s from %s, not signed with key %s", message.Fqdn(q.Name), info.Client.Addr(), message.Fqdn(key.Name))
+		return rcodeResponse(header, questions, message.RCodeRefused)
+	}
+
+	h.log.Info.Printf("Received NOTIFY for %s from %s", message.Fqdn(q.Name), info.Client.Addr())
+	secondary.Notify()
+
+	response := rcodeResponse(header, questions, message.RCodeSuccess)
+	response.Header.AA = header.AA
+	return response
+}
+
+// secondary returns the secondary for the zone at origin, if there is one.
+func (h *DefaultMessageHandler) secondary(origin string) (*zone.Secondary, bool) {
+	for _, s := range h.secondaries {
+		if message.EqualNames(s.Origin(), origin) {
+			return s, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const (
+	// defaultTCPIdleTimeout is how long an idle TCP connection is kept open (RFC 7766 §6.2.3).
+	defaultTCPIdleTimeout = 10 * time.Second
+
+	// defaultTCPMaxConnections caps concurrently open TCP connections.
+	defaultTCPMaxConnections = 256
+
+	// defaultTCPMaxInFlight caps pipelined queries being processed per TCP connection.
+	defaultTCPMaxInFlight = 32
+
+	// defaultUDPQueueSize is how many UDP queries may wait for a worker.
+	defaultUDPQueueSize = 1024
+)
+
+// OverloadPolicy decides what happens to a UDP query that arrives while
+// every worker is busy and the queue is full.
+type OverloadPolicy int
+
+const (
+	// OverloadServFail answers the query with SERVFAIL
+	OverloadServFail OverloadPolicy = iota
+	// OverloadRefused answers the query with REFUSED
+	OverloadRefused
+	// OverloadDrop silently discards the query
+	OverloadDrop
+)
+
+func (p OverloadPolicy) String() string {
+	switch p {
+	case OverloadServFail:
+		return "servfail"
+	case OverloadRefused:
+		return "refused"
+	case OverloadDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("OverloadPolicy(%d)", int(p))
+}
+
+// ParseOverloadPolicy converts "servfail", "refused" or "drop" to an OverloadPolicy.
+func ParseOverloadPolicy(s string) (OverloadPolicy, error) {
+	for _, p := range []OverloadPolicy{OverloadServFail, OverloadRefused, OverloadDrop} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overload policy %q", s)
+}
+
+// config holds the settings shared by every transport. Settings that do not
+// apply to a transport are ignored by it.
+type config struct {
+	messageHandler MessageHandler
+
+	tcpIdleTimeout    time.Duration
+	tcpMaxConnections int
+	tcpMaxInFlight    int
+
+	udpWorkers     int
+	udpQueueSize   int
+	overloadPolicy OverloadPolicy
+}
+
+// Option configures a server.
+type Option func(*config)
+
+// WithMessageHandler sets the handler that answers queries, so several
+// transports can share one handler.
+func WithMessageHandler(h MessageHandler) Option {
+	return func(c *config) {
+		c.messageHandler = h
+	}
+}
+
+// WithTCPIdleTimeout sets how long a TCP connection may sit idle between queries.
+func WithTCPIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.tcpIdleTimeout = d
+	}
+}
+
+// WithTCPMaxConnections caps the number of concurrently open TCP connections.
+// Connections beyond the cap are closed as soon as they are accepted.
+func WithTCPMaxConnections(n int) Option {
+	return func(c *config) {
+		c.tcpMaxConnections = n
+	}
+}
+
+// WithTCPMaxInFlight caps how many pipelined queries from one TCP connection
+// are processed concurrently.
+func WithTCPMaxInFlight(n int) Option {
+	return func(c *config) {
+		c.tcpMaxInFlight = n
+	}
+}
+
+// WithUDPWorkers sets how many goroutines process UDP queries concurrently,
+// at least one.
+func WithUDPWorkers(n int) Option {
+	return func(c *config) {
+		c.udpWorkers = n
+	}
+}
+
+// WithUDPQueueSize sets how many UDP queries may wait for a free worker
+// before the overload policy applies. With zero, queries are shed whenever
+// every worker is busy.
+func WithUDPQueueSize(n int) Option {
+	return func(c *config) {
+		c.udpQueueSize = n
+	}
+}
+
+// WithOverloadPolicy sets how UDP queries are shed when the queue is full.
+func WithOverloadPolicy(p OverloadPolicy) Option {
+	return func(c *config) {
+		c.overloadPolicy = p
+	}
+}
+
+// newConfig applies opts on top of the defaults.
+func newConfig(opts []Option) config {
+	c := config{
+		tcpIdleTimeout:    defaultTCPIdleTimeout,
+		tcpMaxConnections: defaultTCPMaxConnections,
+		tcpMaxInFlight:    defaultTCPMaxInFlight,
+		udpWorkers:        4 * runtime.NumCPU(),
+		udpQueueSize:      defaultUDPQueueSize,
+		overloadPolicy:    OverloadServFail,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	// Sizes that would stall or panic the server are clamped to the
+	// nearest that work
+	c.udpWorkers = max(c.udpWorkers, 1)
+	c.udpQueueSize = max(c.udpQueueSize, 0)
+	c.tcpMaxConnections = max(c.tcpMaxConnections, 1)
+	c.tcpMaxInFlight = max(c.tcpMaxInFlight, 1)
+	return c
+}
@@ -0,0 +1,21 @@
+package server
+
+import "testing"
+
+func TestNewConfigClampsSizes(t *testing.T) {
+	c := newConfig([]Option{
+		WithUDPWorkers(0),
+		WithUDPQueueSize(-1),
+		WithTCPMaxConnections(-5),
+		WithTCPMaxInFlight(0),
+	})
+	if c.udpWorkers != 1 || c.udpQueueSize != 0 || c.tcpMaxConnections != 1 || c.tcpMaxInFlight != 1 {
+		t.Errorf("got %d workers, queue %d, %d connections, %d in flight; want 1, 0, 1, 1",
+			c.udpWorkers, c.udpQueueSize, c.tcpMaxConnections, c.tcpMaxInFlight)
+	}
+
+	// A queue of zero is valid: queries are shed unless a worker is free
+	if c := newConfig([]Option{WithUDPWorkers(2), WithUDPQueueSize(0)}); c.udpWorkers != 2 || c.udpQueueSize != 0 {
+		t.Errorf("got %d workers, queue %d; want 2, 0", c.udpWorkers, c.udpQueueSize)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// Transport names reported in RequestInfo.
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+)
+
+// RequestInfo describes where a query came from. Transports attach it to
+// the context passed to MessageHandler.Handle.
+type RequestInfo struct {
+	// Client is the address of the client that sent the query
+	Client netip.AddrPort
+	// Transport is one of the Transport constants
+	Transport string
+	// Send writes a response message ahead of the one Handle returns, for
+	// answers such as zone transfers that span several messages. It is nil
+	// on transports that carry a single message per query.
+	Send func(message.Message) error
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx carrying info.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the RequestInfo attached to ctx, if any.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+// clientAddr converts a connection's remote address to an AddrPort, with
+// IPv4-mapped IPv6 addresses unmapped so they match IPv4 prefixes.
+func clientAddr(addr net.Addr) netip.AddrPort {
+	var ap netip.AddrPort
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	default:
+		ap, _ = netip.ParseAddrPort(addr.String())
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
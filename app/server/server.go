@@ -1,33 +1,63 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
 	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
 )
 
 // UDPServer represents a DNS server that listens for DNS queries over UDP.
 type UDPServer struct {
+	config
+	lifecycle
 	addr string
 	conn *net.UDPConn
 	log  *gotracer.Logger
-	// Add handlers/processors
-	messageHandler MessageHandler
+
+	// buffers recycles read buffers so each queued query owns its own
+	buffers sync.Pool
+	// jobs queues received queries for the worker pool
+	jobs chan udpJob
+}
+
+// udpJob is a received query waiting for a worker. buf came from the
+// buffer pool and is returned to it once the query has been handled.
+type udpJob struct {
+	buf    *[]byte
+	size   int
+	source *net.UDPAddr
+	done   func()
 }
 
-// New creates a new DNS server instance with default handlers
-func New(addr string, log *gotracer.Logger) *UDPServer {
-	return &UDPServer{
-		addr:           addr,
-		log:            log,
-		messageHandler: NewDefaultMessageHandler(log),
+// New creates a new DNS server instance.
+// Unless WithMessageHandler is given, queries are answered by a DefaultMessageHandler.
+func New(addr string, log *gotracer.Logger, opts ...Option) *UDPServer {
+	s := &UDPServer{
+		config: newConfig(opts),
+		addr:   addr,
+		log:    log,
 	}
+	if s.messageHandler == nil {
+		s.messageHandler = NewDefaultMessageHandler(log)
+	}
+	s.jobs = make(chan udpJob, s.udpQueueSize)
+	s.buffers.New = func() any {
+		buf := make([]byte, maxUDPRequestSize)
+		return &buf
+	}
+	return s
 }
 
 // Start initializes the UDP server and starts listening for DNS queries.
-// It resolves the server address, listens for incoming connections, and handles requests.
+// It resolves the server address, listens for incoming connections, and handles requests
+// until ctx is cancelled or Shutdown is called.
 // Returns an error if the server setup or listening process fails.
-func (s *UDPServer) Start() error {
+func (s *UDPServer) Start(ctx context.Context) error {
 	s.log.Info.Println("Starting UDP server setup...")
 
 	udpAddr, err := net.ResolveUDPAddr("udp", s.addr)
@@ -41,40 +71,159 @@ func (s *UDPServer) Start() error {
 	}
 	s.conn = conn
 
+	// Intake stops by unblocking the pending read; the socket stays open
+	// so in-flight requests can still send their responses.
+	s.begin(ctx,
+		func() { conn.SetReadDeadline(time.Now()) },
+		func() { conn.Close() },
+	)
+
 	return s.serve()
 }
 
+// Shutdown stops reading new packets and waits for in-flight requests to be
+// answered before closing the socket. If ctx expires first, in-flight
+// requests are cancelled, the socket is closed and ctx's error is returned.
+func (s *UDPServer) Shutdown(ctx context.Context) error {
+	s.log.Info.Println("Shutting down UDP server...")
+	return s.shutdown(ctx)
+}
+
+// QueueDepth returns the number of received queries waiting for a worker.
+func (s *UDPServer) QueueDepth() int {
+	return len(s.jobs)
+}
+
 // serve is the main loop for the UDP server.
-// It listens for incoming DNS queries, processes them, and sends responses.
-// Returns an error if there's an issue with reading from the UDP connection.
+// It reads DNS queries into pooled buffers and queues them for a fixed pool
+// of workers. When the queue is full the overload policy decides how the
+// query is shed. Transient read errors are logged and skipped; it returns
+// nil once shutdown begins, or an error if the socket fails.
 func (s *UDPServer) serve() error {
-	defer s.conn.Close()
-	buf := make([]byte, 512)
+	defer close(s.jobs)
+
+	for i := 0; i < s.udpWorkers; i++ {
+		go s.worker()
+	}
 
 	for {
-		size, source, err := s.conn.ReadFromUDP(buf)
+		buf := s.buffers.Get().(*[]byte)
+		size, source, err := s.conn.ReadFromUDP(*buf)
 		if err != nil {
-			s.log.Error.Printf("Error receiving data: %v", err)
-			return err
+			s.buffers.Put(buf)
+			if s.isStopping() {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.log.Error.Printf("Error receiving data: %v", err)
+				return err
+			}
+			s.log.Warnf("Error receiving data", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
 		}
 
 		s.log.Info.Printf("Received request from %s", source.String())
 
-		// Handle each request in a goroutine
-		go s.handleRequest(buf[:size], source)
+		done, ok := s.track()
+		if !ok {
+			s.buffers.Put(buf)
+			return nil
+		}
+		job := udpJob{buf: buf, size: size, source: source, done: done}
+		select {
+		case s.jobs <- job:
+		default:
+			s.shed(job)
+		}
+	}
+}
+
+// worker handles queued queries until the queue is closed.
+func (s *UDPServer) worker() {
+	for job := range s.jobs {
+		s.process(job)
 	}
 }
 
+// process handles one queued query and releases its buffer.
+func (s *UDPServer) process(job udpJob) {
+	defer job.done()
+	defer s.buffers.Put(job.buf)
+	defer recoverPanic(s.log, job.source.String())
+
+	s.handleRequest((*job.buf)[:job.size], job.source)
+}
+
+// shed applies the overload policy to a query that could not be queued.
+func (s *UDPServer) shed(job udpJob) {
+	defer job.done()
+	defer s.buffers.Put(job.buf)
+
+	s.log.Warnf("Shedding UDP query, worker queue is full", map[string]interface{}{
+		"client":      job.source.String(),
+		"queue_depth": s.QueueDepth(),
+		"policy":      s.overloadPolicy,
+	})
+
+	var rcode uint8
+	switch s.overloadPolicy {
+	case OverloadServFail:
+		rcode = message.RCodeServerFailure
+	case OverloadRefused:
+		rcode = message.RCodeRefused
+	default:
+		return
+	}
+
+	response, ok := errorResponse((*job.buf)[:job.size], rcode)
+	if !ok {
+		return
+	}
+	if _, err := s.conn.WriteToUDP(response, job.source); err != nil {
+		s.log.Errorf("Failed to send response", map[string]interface{}{
+			"error":  err.Error(),
+			"client": job.source.String(),
+		})
+	}
+}
+
+// errorResponse builds a minimal response with the given RCODE, echoing
+// the query's ID, flags and question. It is cheap enough to run on the read
+// loop. It reports false if data is not a query worth answering.
+func errorResponse(data []byte, rcode uint8) ([]byte, bool) {
+	header, err := message.ParseHeader(data)
+	if err != nil || header.QR == 1 {
+		return nil, false
+	}
+
+	response := message.Message{Header: header}
+	if header.QDCount == 1 {
+		if question, _, err := message.ParseQuestion(data, message.HeaderSize); err == nil {
+			response.Questions = []message.Question{question}
+		}
+	}
+	response.Header.QR = 1
+	response.Header.AA = 0
+	response.Header.TC = 0
+	response.Header.RA = 0
+	response.Header.RCode = rcode
+	return response.Encode(), true
+}
+
 // handleRequest processes a single DNS request.
 // It uses the messageHandler to process the request and send a response.
-// Logs any errors that occur during processing.
+// Logs any errors that occur during processing. data is only valid until
+// handleRequest returns, since its buffer goes back to the pool.
 func (s *UDPServer) handleRequest(data []byte, source *net.UDPAddr) {
 	s.log.Debugf("Processing DNS request", map[string]interface{}{
 		"client":    source.String(),
 		"data_size": len(data),
 	})
 
-	response, err := s.messageHandler.Handle(data)
+	info := RequestInfo{Client: clientAddr(source), Transport: TransportUDP}
+	response, edns, err := exchange(s.baseCtx, s.messageHandler, s.log, data, info)
 	if err != nil {
 		s.log.Errorf("Failed to handle request", map[string]interface{}{
 			"error":  err.Error(),
@@ -83,6 +232,14 @@ func (s *UDPServer) handleRequest(data []byte, source *net.UDPAddr) {
 		return
 	}
 
+	if response.Truncate(edns.udpLimit()) {
+		s.log.Debugf("Truncated DNS response", map[string]interface{}{
+			"client":    source.String(),
+			"udp_limit": edns.udpLimit(),
+			"tc":        response.Header.TC,
+		})
+	}
+
 	encoded := response.Encode()
 	s.log.Debugf("Sending DNS response", map[string]interface{}{
 		"client":        source.String(),
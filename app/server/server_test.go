@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// answerMany answers with count A records, each its own RRset.
+func answerMany(count int) MessageHandler {
+	return handlerFunc(func(ctx context.Context, data []byte) (message.Message, error) {
+		query, err := message.ParseMessage(data)
+		if err != nil {
+			return message.Message{}, err
+		}
+		response := message.Message{Header: message.Header{ID: query.Header.ID, QR: 1}, Questions: query.Questions}
+		for i := 0; i < count; i++ {
+			response.Answers = append(response.Answers, message.Answer{
+				Name: fmt.Sprintf("host%d.example.com", i), Type: message.TypeA, Class: message.ClassINET, TTL: 300,
+				RData: &message.A{Addr: netip.AddrFrom4([4]byte{192, 0, 2, byte(i)})},
+			})
+		}
+		return response, nil
+	})
+}
+
+func TestUDPTruncation(t *testing.T) {
+	tests := []struct {
+		name    string
+		records int
+		edns    *message.EDNS
+		limit   int
+		tc      bool
+	}{
+		{"fits in 512", 10, nil, message.MinUDPSize, false},
+		{"over 512", 40, nil, message.MinUDPSize, true},
+		{"fits the EDNS size", 40, &message.EDNS{UDPSize: 4096}, ednsUDPSize, false},
+		{"client EDNS size", 40, &message.EDNS{UDPSize: 600}, 600, true},
+		{"over our EDNS size", 100, &message.EDNS{UDPSize: 4096}, ednsUDPSize, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("127.0.0.1:0", testLogger(), WithMessageHandler(answerMany(tt.records)))
+			s.baseCtx = context.Background()
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			s.conn = conn
+			client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			query := message.Message{
+				Header:    message.Header{ID: 1, RD: 1},
+				Questions: []message.Question{{Name: "example.com", Type: message.TypeA, Class: message.ClassINET}},
+			}
+			if tt.edns != nil {
+				query.SetEDNS(*tt.edns)
+			}
+			s.handleRequest(query.Encode(), client.LocalAddr().(*net.UDPAddr))
+
+			buf := make([]byte, 65535)
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n > tt.limit {
+				t.Errorf("response is %d bytes, over %d", n, tt.limit)
+			}
+			response, err := message.ParseMessage(buf[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (response.Header.TC == 1) != tt.tc {
+				t.Errorf("TC = %d, want %v", response.Header.TC, tt.tc)
+			}
+			if !tt.tc && len(response.Answers) != tt.records {
+				t.Errorf("%d answers, want %d", len(response.Answers), tt.records)
+			}
+			if tt.tc && len(response.Answers) == 0 {
+				t.Error("every answer dropped, though some would fit")
+			}
+			if _, ok := response.EDNS(); ok != (tt.edns != nil) {
+				t.Errorf("has OPT = %v, want %v", ok, tt.edns != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// maxTCPMessageSize is the largest message the two-byte length prefix can frame.
+	maxTCPMessageSize = 65535
+
+	// minAcceptBackoff and maxAcceptBackoff bound the pause before
+	// accepting again after a failed accept.
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// TCPServer represents a DNS server that listens for DNS queries over TCP.
+// Messages are framed with a two-byte length prefix (RFC 1035 §4.2.2) and
+// connections are handled as described in RFC 7766: several queries may be
+// sent per connection, and pipelined queries are answered as they complete,
+// possibly out of order.
+type TCPServer struct {
+	config
+	lifecycle
+	addr     string
+	listener net.Listener
+	log      *gotracer.Logger
+
+	// open tracks live connections so shutdown can stop reading from them
+	openMu sync.Mutex
+	open   map[net.Conn]struct{}
+
+	// conns is a semaphore bounding concurrently open connections
+	conns chan struct{}
+
+	// paddingBlockSize, when non-zero, pads responses to EDNS clients to a
+	// multiple of this many bytes. It is only set for encrypted transports.
+	paddingBlockSize int
+
+	// transport is reported to the handler in RequestInfo
+	transport string
+}
+
+// NewTCP creates a new TCP DNS server instance.
+// Unless WithMessageHandler is given, queries are answered by a DefaultMessageHandler.
+func NewTCP(addr string, log *gotracer.Logger, opts ...Option) *TCPServer {
+	s := &TCPServer{
+		config:    newConfig(opts),
+		addr:      addr,
+		log:       log,
+		open:      make(map[net.Conn]struct{}),
+		transport: TransportTCP,
+	}
+	if s.messageHandler == nil {
+		s.messageHandler = NewDefaultMessageHandler(log)
+	}
+	s.conns = make(chan struct{}, s.tcpMaxConnections)
+	return s
+}
+
+// Start listens on the server address and serves connections until ctx
+// is cancelled, Shutdown is called or the listener fails.
+func (s *TCPServer) Start(ctx context.Context) error {
+	s.log.Info.Println("Starting TCP server setup...")
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.serve(ctx, listener)
+}
+
+// Shutdown stops accepting connections and reading new queries, then waits
+// for in-flight queries to be answered before closing every connection. If
+// ctx expires first, connections are closed immediately and ctx's error is
+// returned.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.log.Info.Println("Shutting down TCP server...")
+	return s.shutdown(ctx)
+}
+
+// serve accepts connections from listener and handles each in its own
+// goroutine. Connections beyond the configured cap are closed immediately.
+// Failed accepts are retried with backoff; it returns nil once shutdown
+// begins, or an error if the listener is closed by anything else.
+func (s *TCPServer) serve(ctx context.Context, listener net.Listener) error {
+	s.listener = listener
+	s.begin(ctx, s.stopIntake, s.closeAll)
+
+	var backoff time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isStopping() {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				s.log.Error.Printf("Error accepting connection: %v", err)
+				return err
+			}
+
+			// Errors such as running out of file descriptors pass once
+			// connections close, so back off and try again
+			if backoff = max(2*backoff, minAcceptBackoff); backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			s.log.Warnf("Error accepting connection, retrying", map[string]interface{}{
+				"error":   err.Error(),
+				"backoff": backoff.String(),
+			})
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-s.stopping:
+				timer.Stop()
+			}
+			continue
+		}
+		backoff = 0
+
+		select {
+		case s.conns <- struct{}{}:
+		default:
+			s.log.Warnf("Rejecting connection, too many open connections", map[string]interface{}{
+				"client":          conn.RemoteAddr().String(),
+				"max_connections": s.tcpMaxConnections,
+			})
+			conn.Close()
+			continue
+		}
+
+		done, ok := s.track()
+		if !ok {
+			<-s.conns
+			conn.Close()
+			return nil
+		}
+		s.setOpen(conn, true)
+		go func() {
+			defer done()
+			defer func() { <-s.conns }()
+			defer s.setOpen(conn, false)
+			defer recoverPanic(s.log, conn.RemoteAddr().String())
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// stopIntake closes the listener and unblocks every connection's pending
+// read, so connections close once their in-flight replies are written.
+func (s *TCPServer) stopIntake() {
+	s.listener.Close()
+
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+	for conn := range s.open {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// closeAll closes every connection that is still open.
+func (s *TCPServer) closeAll() {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+	for conn := range s.open {
+		conn.Close()
+	}
+}
+
+func (s *TCPServer) setOpen(conn net.Conn, open bool) {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+	if open {
+		s.open[conn] = struct{}{}
+	} else {
+		delete(s.open, conn)
+	}
+}
+
+// handleConn reads queries from conn until the client closes it, it sits
+// idle for longer than the idle timeout, or a framing error occurs. Each
+// query is processed in its own goroutine; writes are serialised so replies
+// never interleave. The connection is closed only after every in-flight
+// reply has been written.
+func (s *TCPServer) handleConn(conn net.Conn) {
+	client := conn.RemoteAddr().String()
+	s.log.Info.Printf("Accepted connection from %s", client)
+
+	var (
+		writeMu  sync.Mutex
+		inFlight sync.WaitGroup
+		slots    = make(chan struct{}, s.tcpMaxInFlight)
+	)
+	defer conn.Close()
+	defer inFlight.Wait()
+
+	for {
+		if s.isStopping() {
+			return
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(s.tcpIdleTimeout)); err != nil {
+			return
+		}
+
+		data, err := readFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.log.Debugf("Closing connection", map[string]interface{}{
+					"client": client,
+					"reason": err.Error(),
+				})
+			}
+			return
+		}
+
+		slots <- struct{}{}
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			defer func() { <-slots }()
+			defer recoverPanic(s.log, client)
+
+			write := func(encoded []byte) error {
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(s.tcpIdleTimeout))
+				return writeFrame(conn, encoded)
+			}
+			info := RequestInfo{
+				Client:    clientAddr(conn.RemoteAddr()),
+				Transport: s.transport,
+				Send:      func(m message.Message) error { return write(m.Encode()) },
+			}
+
+			encoded, ok := s.handleRequest(data, info)
+			if !ok {
+				return
+			}
+			if err := write(encoded); err != nil {
+				s.log.Errorf("Failed to send response", map[string]interface{}{
+					"error":  err.Error(),
+					"client": client,
+				})
+			}
+		}()
+	}
+}
+
+// handleRequest processes a single DNS request and returns the encoded
+// response. It reports false if there is nothing to send.
+func (s *TCPServer) handleRequest(data []byte, info RequestInfo) ([]byte, bool) {
+	client := info.Client.String()
+	s.log.Debugf("Processing DNS request", map[string]interface{}{
+		"client":    client,
+		"data_size": len(data),
+		"transport": s.transport,
+	})
+
+	response, edns, err := exchange(s.baseCtx, s.messageHandler, s.log, data, info)
+	if err != nil {
+		s.log.Errorf("Failed to handle request", map[string]interface{}{
+			"error":  err.Error(),
+			"client": client,
+		})
+		return nil, false
+	}
+
+	response.Truncate(maxTCPMessageSize)
+	if s.paddingBlockSize > 0 && edns.present {
+		response.Pad(s.paddingBlockSize)
+	}
+	encoded := response.Encode()
+	s.log.Debugf("Sending DNS response", map[string]interface{}{
+		"client":        client,
+		"response_size": len(encoded),
+	})
+	return encoded, true
+}
+
+// readFrame reads one length-prefixed DNS message.
+func readFrame(r io.Reader) ([]byte, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint16(prefix[:])
+	if length == 0 {
+		return nil, fmt.Errorf("zero-length message")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read %d byte message: %w", length, err)
+	}
+	return data, nil
+}
+
+// writeFrame writes one length-prefixed DNS message in a single write.
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > maxTCPMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds TCP frame limit", len(data))
+	}
+	frame := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// failingListener fails accepts with EMFILE until failures runs out.
+type failingListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return l.Listener.Accept()
+}
+
+func TestTCPServerRetriesFailedAccepts(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &failingListener{Listener: inner}
+	listener.failures.Store(3)
+
+	s := NewTCP("127.0.0.1:0", testLogger(), WithMessageHandler(answerA))
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.serve(ctx, listener) }()
+
+	conn, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	query := message.Message{
+		Header:    message.Header{ID: 1, RD: 1},
+		Questions: []message.Question{{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}},
+	}
+	if err := writeFrame(conn, query.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := readFrame(conn); err != nil {
+		t.Fatalf("no response after failed accepts: %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve returned %v after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestTCPServerStopsWhenListenerCloses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTCP("127.0.0.1:0", testLogger(), WithMessageHandler(answerA))
+	served := make(chan error, 1)
+	go func() { served <- s.serve(context.Background(), listener) }()
+
+	listener.Close()
+	select {
+	case err := <-served:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("serve returned %v, want net.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve kept running after its listener closed")
+	}
+}
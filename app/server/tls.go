@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+// tlsPaddingBlockSize is the response padding block size recommended by RFC 8467 §4.1.
+const tlsPaddingBlockSize = 468
+
+// TLSServer represents a DNS-over-TLS server (RFC 7858). It wraps the TCP
+// transport's framing and connection handling in TLS, and pads responses
+// to EDNS clients so their length leaks less about their content.
+type TLSServer struct {
+	tcp   *TCPServer
+	certs *certReloader
+	log   *gotracer.Logger
+}
+
+// NewTLS creates a new DNS-over-TLS server instance using the certificate
+// and key at certFile and keyFile. It returns an error if they cannot be loaded.
+func NewTLS(addr, certFile, keyFile string, log *gotracer.Logger, opts ...Option) (*TLSServer, error) {
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := certs.reload(); err != nil {
+		return nil, err
+	}
+
+	tcp := NewTCP(addr, log, opts...)
+	tcp.paddingBlockSize = tlsPaddingBlockSize
+	tcp.transport = TransportTLS
+
+	return &TLSServer{
+		tcp:   tcp,
+		certs: certs,
+		log:   log,
+	}, nil
+}
+
+// Start listens on the server address and serves TLS connections until ctx
+// is cancelled, Shutdown is called or the listener fails.
+func (s *TLSServer) Start(ctx context.Context) error {
+	s.log.Info.Println("Starting TLS server setup...")
+
+	listener, err := net.Listen("tcp", s.tcp.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.serve(ctx, listener)
+}
+
+// serve wraps listener in TLS and serves DNS over the connections it
+// accepts, as TCPServer.serve does.
+func (s *TLSServer) serve(ctx context.Context, listener net.Listener) error {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"dot"},
+		GetCertificate: s.certs.getCertificate,
+	}
+	return s.tcp.serve(ctx, tls.NewListener(listener, config))
+}
+
+// Shutdown stops accepting connections and waits for in-flight queries to
+// be answered, as TCPServer.Shutdown does.
+func (s *TLSServer) Shutdown(ctx context.Context) error {
+	s.log.Info.Println("Shutting down TLS server...")
+	return s.tcp.shutdown(ctx)
+}
+
+// Reload reads the certificate and key from disk again. New handshakes use
+// the new certificate; established connections are left untouched. On
+// error the previous certificate stays in use.
+func (s *TLSServer) Reload() error {
+	if err := s.certs.reload(); err != nil {
+		return err
+	}
+	s.log.Info.Println("Reloaded TLS certificate")
+	return nil
+}
+
+// certReloader serves the most recently loaded certificate to TLS handshakes.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
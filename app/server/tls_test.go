@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// handlerFunc adapts a function to the MessageHandler interface.
+type handlerFunc func(ctx context.Context, data []byte) (message.Message, error)
+
+func (f handlerFunc) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	return f(ctx, data)
+}
+
+// answerA answers every query with an A record for its first question.
+var answerA = handlerFunc(func(ctx context.Context, data []byte) (message.Message, error) {
+	query, err := message.ParseMessage(data)
+	if err != nil {
+		return message.Message{}, err
+	}
+	q := query.Questions[0]
+	return message.Message{
+		Header:    message.Header{ID: query.Header.ID, QR: 1, RD: query.Header.RD},
+		Questions: query.Questions,
+		Answers: []message.Answer{
+			{Name: q.Name, Type: message.TypeA, Class: message.ClassINET, TTL: 300, RData: &message.A{Addr: netip.MustParseAddr("192.0.2.1")}},
+		},
+	}, nil
+})
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to dir, and returns their paths and a pool trusting it.
+func writeTestCertificate(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestTLSServer(t *testing.T) {
+	certFile, keyFile, pool := writeTestCertificate(t, t.TempDir())
+	s, err := NewTLS("127.0.0.1:0", certFile, keyFile, testLogger(), WithMessageHandler(answerA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.serve(ctx, listener) }()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: pool, NextProtos: []string{"dot"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "dot" {
+		t.Errorf("negotiated protocol %q, want dot", proto)
+	}
+
+	// Pipeline an EDNS query and a plain one in a single write
+	padded := message.Message{
+		Header:    message.Header{ID: 1, RD: 1},
+		Questions: []message.Question{{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}},
+	}
+	padded.SetEDNS(message.EDNS{UDPSize: 1232})
+	plain := message.Message{
+		Header:    message.Header{ID: 2, RD: 1},
+		Questions: []message.Question{{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}},
+	}
+	var frames bytes.Buffer
+	for _, query := range []message.Message{padded, plain} {
+		if err := writeFrame(&frames, query.Encode()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := conn.Write(frames.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		data, err := readFrame(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := message.ParseMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Answers) != 1 {
+			t.Errorf("response %d has %d answers, want 1", response.Header.ID, len(response.Answers))
+		}
+		edns, hasEDNS := response.EDNS()
+		switch response.Header.ID {
+		case 1:
+			if len(data)%tlsPaddingBlockSize != 0 {
+				t.Errorf("EDNS response is %d bytes, not a multiple of %d", len(data), tlsPaddingBlockSize)
+			}
+			hasPadding := false
+			for _, o := range edns.Options {
+				hasPadding = hasPadding || o.Code == message.EDNSOptionPadding
+			}
+			if !hasPadding {
+				t.Error("EDNS response has no padding option")
+			}
+		case 2:
+			if hasEDNS {
+				t.Error("response to a query without EDNS has an OPT record")
+			}
+		default:
+			t.Errorf("unexpected response ID %d", response.Header.ID)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("server did not shut down")
+	}
+}
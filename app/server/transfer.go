@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+)
+
+// transferMessageSize is roughly how many bytes of records go in each
+// message of a zone transfer. It leaves plenty of room under the 64 KiB
+// TCP message limit even before name compression.
+const transferMessageSize = 16 * 1024
+
+// WithTransferACL allows zone transfers to clients whose address is in
+// one of prefixes. Without it every transfer is refused.
+func WithTransferACL(prefixes ...netip.Prefix) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.transferACL = append(h.transferACL, prefixes...)
+	}
+}
+
+// transfer answers an AXFR (RFC 5936) or IXFR (RFC 1995) query. The
+// records are streamed as a series of messages through the transport's
+// Send function, beginning and ending with the current SOA record; the
+// final message is returned so it goes through the same path as any other
+// response. Transfers are only served to clients the transfer ACL allows
+// or that sign the request with a transfer key, and AXFR only over stream
+// transports. Every message of a signed transfer is signed in turn.
+func (h *DefaultMessageHandler) transfer(ctx context.Context, data []byte, header message.Header, q message.Question, signer *message.TSIGSigner) (message.Message, error) {
+	info, _ := RequestInfoFromContext(ctx)
+	questions := []message.Question{q}
+
+	z, ok := h.findZone(q)
+	if !ok || !message.EqualNames(z.Origin(), q.Name) {
+		return rcodeResponse(header, questions, message.RCodeNotAuth), nil
+	}
+	if info.Send == nil && q.Type == message.TypeAXFR {
+		h.log.Debugf("Refusing zone transfer over datagram transport", map[string]interface{}{
+			"client":    info.Client.String(),
+			"transport": info.Transport,
+			"zone":      message.Fqdn(z.Origin()),
+		})
+		return rcodeResponse(header, questions, message.RCodeRefused), nil
+	}
+	if !prefixesContain(h.transferACL, info.Client.Addr()) && !signedWith(signer, h.transferKeys) {
+		h.log.Info.Printf("Refused zone transfer of %s to %s", message.Fqdn(z.Origin()), info.Client.Addr())
+		return rcodeResponse(header, questions, message.RCodeRefused), nil
+	}
+
+	records := axfrRecords(z)
+	if q.Type == message.TypeIXFR {
+		request, err := message.ParseMessage(data)
+		if err != nil {
+			return message.Message{}, fmt.Errorf("failed to parse IXFR request: %w", err)
+		}
+		serial, ok := ixfrSerial(request)
+		if !ok {
+			return rcodeResponse(header, questions, message.RCodeFormatError), nil
+		}
+		records = ixfrRecords(z, serial)
+	}
+
+	messages := transferMessages(header, q, records)
+	if info.Send == nil && !fitsDatagram(data, messages) {
+		// An IXFR too large for one datagram is answered with the current
+		// SOA alone, telling the client to retry over TCP (RFC 1995 §2)
+		messages = transferMessages(header, q, records[:1])
+	}
+	for _, m := range messages[:len(messages)-1] {
+		m.SetTSIG(signer)
+		if err := info.Send(m); err != nil {
+			return message.Message{}, fmt.Errorf("zone transfer of %s aborted: %w", message.Fqdn(z.Origin()), err)
+		}
+	}
+
+	h.log.Info.Printf("Transferred zone %s to %s by %s in %d messages", message.Fqdn(z.Origin()), info.Client.Addr(), message.TypeString(q.Type), len(messages))
+	return messages[len(messages)-1], nil
+}
+
+// fitsDatagram reports whether messages is a single message that fits in
+// a UDP response to the query in data, once its OPT record is set.
+func fitsDatagram(data []byte, messages []message.Message) bool {
+	if len(messages) > 1 {
+		return false
+	}
+	_, edns := parseEDNSRequest(data)
+	response := messages[0]
+	applyEDNS(edns, &response)
+	return len(response.Encode()) <= edns.udpLimit()
+}
+
+// prefixesContain reports whether addr is in one of prefixes.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// axfrRecords returns the records of a full transfer of z: the SOA, every
+// other record, then the SOA again.
+func axfrRecords(z *zone.Zone) []message.Answer {
+	records := z.Records()
+	return append(records, records[0])
+}
+
+// ixfrSerial returns the client's serial from the SOA record in the
+// authority section of an IXFR request.
+func ixfrSerial(request message.Message) (uint32, bool) {
+	for _, rr := range request.Authority {
+		if soa, ok := rr.RData.(*message.SOA); ok && rr.Type == message.TypeSOA {
+			return soa.Serial, true
+		}
+	}
+	return 0, false
+}
+
+// ixfrRecords returns the records of an incremental transfer of z to a
+// client at serial (RFC 1995 §4). A client that is up to date gets the
+// current SOA alone. When the journal does not reach back to serial, the
+// full zone is sent in AXFR form instead.
+func ixfrRecords(z *zone.Zone, serial uint32) []message.Answer {
+	current := z.SOA()
+	if !zone.SerialLess(serial, zone.Serial(current)) {
+		return []message.Answer{current}
+	}
+	deltas, ok := z.Journal(serial)
+	if !ok {
+		return axfrRecords(z)
+	}
+
+	// The journal may have moved on since the SOA was read
+	current = deltas[len(deltas)-1].NewSOA
+	records := []message.Answer{current}
+	for _, d := range deltas {
+		records = append(records, d.Records()...)
+	}
+	return append(records, current)
+}
+
+// transferMessages splits records into response messages of about
+// transferMessageSize bytes. Only the first message repeats the question.
+func transferMessages(header message.Header, q message.Question, records []message.Answer) []message.Message {
+	responseHeader := header
+	responseHeader.QR = 1
+	responseHeader.AA = 1
+	responseHeader.TC = 0
+	responseHeader.RA = 0
+	responseHeader.Z = 0
+	responseHeader.RCode = message.RCodeSuccess
+
+	var messages []message.Message
+	current := message.Message{Header: responseHeader, Questions: []message.Question{q}}
+	size := 0
+	for _, rr := range records {
+		rrSize := len(rr.Encode())
+		if size+rrSize > transferMessageSize && len(current.Answers) > 0 {
+			messages = append(messages, current)
+			current = message.Message{Header: responseHeader}
+			size = 0
+		}
+		current.Answers = append(current.Answers, rr)
+		size += rrSize
+	}
+	return append(messages, current)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+)
+
+// testZone builds example.com at serial with hosts extra A records.
+func testZone(t *testing.T, serial uint32, hosts int) *zone.Zone {
+	t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN example.com.\n$TTL 300\n@ SOA ns1 hostmaster %d 3600 900 604800 300\n@ NS ns1\nns1 A 192.0.2.1\n", serial)
+	for i := 0; i < hosts; i++ {
+		fmt.Fprintf(&b, "host%d A 192.0.2.%d\n", i, i%250+2)
+	}
+	records, err := zone.Parse(strings.NewReader(b.String()), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := zone.New(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// runTransfer sends the query in data to h from client and returns every
+// message of the response, those sent ahead of it first. Without stream
+// the query arrives over UDP.
+func runTransfer(t *testing.T, h *DefaultMessageHandler, data []byte, client string, stream bool) []message.Message {
+	t.Helper()
+	var messages []message.Message
+	info := RequestInfo{Client: netip.MustParseAddrPort(client), Transport: TransportUDP}
+	if stream {
+		info.Transport = TransportTCP
+		info.Send = func(m message.Message) error {
+			messages = append(messages, m)
+			return nil
+		}
+	}
+	response, err := h.Handle(WithRequestInfo(context.Background(), info), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(messages, response)
+}
+
+func transferQuery(name string, qtype uint16) []byte {
+	query := message.Message{
+		Header:    message.Header{ID: 5},
+		Questions: []message.Question{{Name: name, Type: qtype, Class: message.ClassINET}},
+	}
+	return query.Encode()
+}
+
+func TestAXFR(t *testing.T) {
+	store := zone.NewStore()
+	store.Add(testZone(t, 1, 0))
+	large := zone.NewStore()
+	large.Add(testZone(t, 1, 2000))
+	allowed := WithTransferACL(netip.MustParsePrefix("192.0.2.0/24"))
+
+	tests := []struct {
+		name     string
+		store    *zone.Store
+		qname    string
+		client   string
+		stream   bool
+		rcode    int
+		records  int // records in the whole transfer
+		messages int
+	}{
+		{"allowed", store, "example.com", "192.0.2.9:5300", true, message.RCodeSuccess, 4, 1},
+		{"split across messages", large, "example.com", "192.0.2.9:5300", true, message.RCodeSuccess, 2004, 5},
+		{"not in the ACL", store, "example.com", "198.51.100.9:5300", true, message.RCodeRefused, 0, 1},
+		{"over UDP", store, "example.com", "192.0.2.9:5300", false, message.RCodeRefused, 0, 1},
+		{"not a zone apex", store, "www.example.com", "192.0.2.9:5300", true, message.RCodeNotAuth, 0, 1},
+		{"no such zone", store, "example.net", "192.0.2.9:5300", true, message.RCodeNotAuth, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewDefaultMessageHandler(testLogger(), WithZones(tt.store), allowed)
+			messages := runTransfer(t, h, transferQuery(tt.qname, message.TypeAXFR), tt.client, tt.stream)
+			if len(messages) != tt.messages {
+				t.Fatalf("%d messages, want %d", len(messages), tt.messages)
+			}
+			var records []message.Answer
+			for i, m := range messages {
+				if m.RCode() != tt.rcode {
+					t.Errorf("message %d: got %s, want %s", i, message.RCodeString(m.RCode()), message.RCodeString(tt.rcode))
+				}
+				if m.Header.ID != 5 || m.Header.QR != 1 {
+					t.Errorf("message %d header = %+v", i, m.Header)
+				}
+				if (len(m.Questions) == 1) != (i == 0) {
+					t.Errorf("message %d has %d questions; only the first repeats the question", i, len(m.Questions))
+				}
+				if n := len(m.Encode()); n > maxTCPMessageSize {
+					t.Errorf("message %d is %d bytes", i, n)
+				}
+				records = append(records, m.Answers...)
+			}
+			if len(records) != tt.records {
+				t.Fatalf("%d records, want %d", len(records), tt.records)
+			}
+			if tt.records > 0 && (records[0].Type != message.TypeSOA || records[len(records)-1].Type != message.TypeSOA) {
+				t.Error("transfer does not begin and end with the SOA")
+			}
+		})
+	}
+}
+
+func TestIXFR(t *testing.T) {
+	z := testZone(t, 1, 0)
+	for _, version := range []*zone.Zone{testZone(t, 2, 1), testZone(t, 3, 2)} {
+		if err := z.Replace(version.Records()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	large := testZone(t, 1, 0)
+	if err := large.Replace(testZone(t, 2, 100).Records()); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(serial uint32, withSOA bool) []byte {
+		msg := message.Message{
+			Header:    message.Header{ID: 5},
+			Questions: []message.Question{{Name: "example.com", Type: message.TypeIXFR, Class: message.ClassINET}},
+		}
+		if withSOA {
+			soa := z.SOA()
+			soa.RData = &message.SOA{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: serial}
+			msg.Authority = []message.Answer{soa}
+		}
+		return msg.Encode()
+	}
+	tests := []struct {
+		name    string
+		zone    *zone.Zone
+		query   []byte
+		stream  bool
+		rcode   int
+		serials []uint32 // of the SOA records in the transfer, in order
+		records int
+	}{
+		{"up to date", z, query(3, true), true, message.RCodeSuccess, []uint32{3}, 1},
+		{"one version behind", z, query(2, true), true, message.RCodeSuccess, []uint32{3, 2, 3, 3}, 5},
+		{"two versions behind", z, query(1, true), true, message.RCodeSuccess, []uint32{3, 1, 2, 2, 3, 3}, 8},
+		{"past the journal", z, query(0, true), true, message.RCodeSuccess, []uint32{3, 3}, 6},
+		{"without a serial", z, query(0, false), true, message.RCodeFormatError, nil, 0},
+		{"small over UDP", z, query(2, true), false, message.RCodeSuccess, []uint32{3, 2, 3, 3}, 5},
+		// Too large for a datagram: the current SOA tells the client to
+		// use TCP
+		{"large over UDP", large, query(1, true), false, message.RCodeSuccess, []uint32{2}, 1},
+		{"large over TCP", large, query(1, true), true, message.RCodeSuccess, []uint32{2, 1, 2, 2}, 104},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := zone.NewStore()
+			store.Add(tt.zone)
+			h := NewDefaultMessageHandler(testLogger(), WithZones(store), WithTransferACL(netip.MustParsePrefix("192.0.2.0/24")))
+			messages := runTransfer(t, h, tt.query, "192.0.2.9:5300", tt.stream)
+
+			var records []message.Answer
+			var serials []uint32
+			for _, m := range messages {
+				if m.RCode() != tt.rcode {
+					t.Fatalf("got %s, want %s", message.RCodeString(m.RCode()), message.RCodeString(tt.rcode))
+				}
+				records = append(records, m.Answers...)
+			}
+			for _, rr := range records {
+				if rr.Type == message.TypeSOA {
+					serials = append(serials, zone.Serial(rr))
+				}
+			}
+			if len(records) != tt.records || fmt.Sprint(serials) != fmt.Sprint(tt.serials) {
+				t.Errorf("%d records with SOA serials %v, want %d with %v", len(records), serials, tt.records, tt.serials)
+			}
+		})
+	}
+}
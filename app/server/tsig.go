@@ -0,0 +1,57 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// WithTSIGKeys gives the handler keys to verify signed requests with
+// (RFC 8945). A signed request is answered with a response signed by the
+// same key; one that fails verification gets NOTAUTH with the TSIG error.
+func WithTSIGKeys(keys ...message.TSIGKey) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		if h.tsigKeys == nil {
+			h.tsigKeys = make(map[string]message.TSIGKey)
+		}
+		for _, key := range keys {
+			h.tsigKeys[strings.ToLower(strings.TrimSuffix(key.Name, "."))] = key
+		}
+	}
+}
+
+// WithTransferKeys allows zone transfers to clients that sign their
+// request with one of the named keys, wherever they connect from.
+func WithTransferKeys(names ...string) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.transferKeys = append(h.transferKeys, names...)
+	}
+}
+
+// WithUpdateKeys allows dynamic updates signed with one of the named
+// keys, wherever they come from.
+func WithUpdateKeys(names ...string) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.updateKeys = append(h.updateKeys, names...)
+	}
+}
+
+// tsigKey looks up a key by name for message.VerifyTSIG.
+func (h *DefaultMessageHandler) tsigKey(name string) (message.TSIGKey, bool) {
+	key, ok := h.tsigKeys[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return key, ok
+}
+
+// signedWith reports whether the request was verified with one of the
+// named keys. signer is nil for unsigned requests.
+func signedWith(signer *message.TSIGSigner, names []string) bool {
+	if signer == nil {
+		return false
+	}
+	for _, name := range names {
+		if message.EqualNames(name, signer.KeyName()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+)
+
+var (
+	transferKey = message.TSIGKey{Name: "xfr.example", Algorithm: message.HmacSHA256, Secret: []byte("0123456789abcdef")}
+	otherKey    = message.TSIGKey{Name: "other.example", Algorithm: message.HmacSHA512, Secret: []byte("fedcba9876543210")}
+)
+
+// signedQuery returns the query for name and qtype, signed with key unless
+// key has no name, and the signer to verify the response with.
+func signedQuery(name string, qtype uint16, key message.TSIGKey) ([]byte, *message.TSIGSigner) {
+	query := message.Message{
+		Header:    message.Header{ID: 5},
+		Questions: []message.Question{{Name: name, Type: qtype, Class: message.ClassINET}},
+	}
+	if key.Name == "" {
+		return query.Encode(), nil
+	}
+	signer := message.NewTSIGSigner(key)
+	query.SetTSIG(signer)
+	return query.Encode(), signer
+}
+
+func TestHandleTSIG(t *testing.T) {
+	store := zone.NewStore()
+	store.Add(testZone(t, 1, 0))
+	h := NewDefaultMessageHandler(testLogger(), WithZones(store), WithTSIGKeys(transferKey, otherKey))
+
+	tests := []struct {
+		name      string
+		key       message.TSIGKey
+		rcode     int
+		tsigError int // the TSIG error the client sees, if any
+	}{
+		{"unsigned", message.TSIGKey{}, message.RCodeSuccess, 0},
+		{"signed", transferKey, message.RCodeSuccess, 0},
+		{"other key", otherKey, message.RCodeSuccess, 0},
+		{"unknown key", message.TSIGKey{Name: "unknown.example", Algorithm: message.HmacSHA256, Secret: transferKey.Secret},
+			message.RCodeNotAuth, message.RCodeBadKey},
+		{"wrong algorithm", message.TSIGKey{Name: transferKey.Name, Algorithm: message.HmacSHA384, Secret: transferKey.Secret},
+			message.RCodeNotAuth, message.RCodeBadKey},
+		{"wrong secret", message.TSIGKey{Name: transferKey.Name, Algorithm: message.HmacSHA256, Secret: []byte("guess")},
+			message.RCodeNotAuth, message.RCodeBadSig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, client := signedQuery("example.com", message.TypeSOA, tt.key)
+			messages := runTransfer(t, h, data, "198.51.100.9:5300", false)
+			response := messages[0]
+			if response.RCode() != tt.rcode {
+				t.Errorf("got %s, want %s", message.RCodeString(response.RCode()), message.RCodeString(tt.rcode))
+			}
+			if client == nil {
+				if response.TSIG() != nil {
+					t.Error("response to an unsigned query is signed")
+				}
+				return
+			}
+
+			err := client.Verify(response.Encode())
+			var tsigErr *message.TSIGError
+			switch {
+			case tt.tsigError == 0 && err != nil:
+				t.Errorf("response does not verify: %v", err)
+			case tt.tsigError != 0 && (!errors.As(err, &tsigErr) || tsigErr.Code != tt.tsigError):
+				t.Errorf("Verify = %v, want TSIG error %s", err, message.RCodeString(tt.tsigError))
+			}
+		})
+	}
+}
+
+func TestSignedAXFR(t *testing.T) {
+	store := zone.NewStore()
+	store.Add(testZone(t, 1, 2000))
+	h := NewDefaultMessageHandler(testLogger(), WithZones(store),
+		WithTSIGKeys(transferKey, otherKey), WithTransferKeys(transferKey.Name))
+
+	tests := []struct {
+		name     string
+		key      message.TSIGKey
+		rcode    int
+		messages int
+	}{
+		{"transfer key", transferKey, message.RCodeSuccess, 5},
+		{"key not allowed transfers", otherKey, message.RCodeRefused, 1},
+		{"unsigned", message.TSIGKey{}, message.RCodeRefused, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, client := signedQuery("example.com", message.TypeAXFR, tt.key)
+			messages := runTransfer(t, h, data, "198.51.100.9:5300", true)
+			if len(messages) != tt.messages {
+				t.Fatalf("%d messages, want %d", len(messages), tt.messages)
+			}
+			for i, m := range messages {
+				if m.RCode() != tt.rcode {
+					t.Errorf("message %d: got %s, want %s", i, message.RCodeString(m.RCode()), message.RCodeString(tt.rcode))
+				}
+				if client == nil {
+					continue
+				}
+				// Each message is verified in order, covering the MAC of
+				// the one before it
+				encoded := m.Encode()
+				if n := len(encoded); n > maxTCPMessageSize {
+					t.Errorf("signed message %d is %d bytes", i, n)
+				}
+				if err := client.Verify(encoded); err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
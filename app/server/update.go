@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/zone"
+)
+
+// WithUpdateACL allows dynamic updates from clients whose address is in
+// one of prefixes. Without it every update is refused.
+func WithUpdateACL(prefixes ...netip.Prefix) HandlerOption {
+	return func(h *DefaultMessageHandler) {
+		h.updateACL = append(h.updateACL, prefixes...)
+	}
+}
+
+// update applies a dynamic update (RFC 2136) to the zone named in its
+// zone section. Updates are only accepted from clients the update ACL
+// allows or signed with an update key, and only for zones we are the
+// primary for; updates to secondary zones belong on the primary, and are
+// refused rather than forwarded.
+func (h *DefaultMessageHandler) update(ctx context.Context, data []byte, header message.Header, questions []message.Question, signer *message.TSIGSigner) message.Message {
+	info, _ := RequestInfoFromContext(ctx)
+	request, err := message.ParseMessage(data)
+	if err != nil || len(questions) != 1 || questions[0].Type != message.TypeSOA {
+		return rcodeResponse(header, questions, message.RCodeFormatError)
+	}
+	q := questions[0]
+
+	z, ok := h.findZone(q)
+	if !ok || !message.EqualNames(z.Origin(), q.Name) {
+		return rcodeResponse(header, questions, message.RCodeNotAuth)
+	}
+	if _, ok := h.secondary(q.Name); ok {
+		h.log.Info.Printf("Refused update of secondary zone %s from %s", message.Fqdn(z.Origin()), info.Client.Addr())
+		return rcodeResponse(header, questions, message.RCodeRefused)
+	}
+	if !prefixesContain(h.updateACL, info.Client.Addr()) && !signedWith(signer, h.updateKeys) {
+		h.log.Info.Printf("Refused update of %s from %s", message.Fqdn(z.Origin()), info.Client.Addr())
+		return rcodeResponse(header, questions, message.RCodeRefused)
+	}
+
+	rcode := z.Update(request.Answers, request.Authority)
+	h.log.Info.Printf("Update of %s from %s: %s, zone at serial %d", message.Fqdn(z.Origin()), info.Client.Addr(), message.RCodeString(rcode), zone.Serial(z.SOA()))
+	return rcodeResponse(header, questions, rcode)
+}
@@ -2,10 +2,13 @@ package message
 
 import (
 	"encoding/binary"
+	"fmt"
+	"net/netip"
 	"strings"
 )
 
-// Answer represents a DNS answer
+// Answer represents a DNS resource record.
+// The same type is used for the answer, authority and additional sections.
 type Answer struct {
 	// Name is the domain name of the answer, encoded as a sequence of labels
 	Name string
@@ -15,64 +18,97 @@ type Answer struct {
 	Class uint16
 	// TTL, 4 bytes, the duration in seconds a record can be cached before requerying
 	TTL uint32
-	// Length, 2 bytes, length of the RDATA field
-	Length uint16
-	// RDATA, variable length, data specific to the record type
-	RData []byte
+	// RDATA, variable length, data specific to the record type.
+	// RDLENGTH is computed on encode; a nil RData encodes as RDLENGTH 0.
+	RData RData
 }
 
 func NewAnswer(domain string) *Answer {
 	return &Answer{
-		Name:   domain,
-		Type:   1, // A record
-		Class:  1, // IN class
-		TTL:    60,
-		Length: 4, // IPv4 address length
-		RData:  []byte{8, 8, 8, 8},
+		Name:  domain,
+		Type:  TypeA,
+		Class: ClassINET,
+		TTL:   60,
+		RData: &A{Addr: netip.AddrFrom4([4]byte{8, 8, 8, 8})},
 	}
 }
 
-func (a Answer) Encode() []byte {
-	result := make([]byte, 0)
+// ParseAnswer parses a resource record from a byte slice starting at the given offset.
+// It returns the record and the number of bytes it occupies on the wire.
+func ParseAnswer(data []byte, offset int) (Answer, int, error) {
+	name, bytesRead, err := parseDomainName(data, offset)
+	if err != nil {
+		return Answer{}, 0, fmt.Errorf("failed to parse domain name at offset %d: %w", offset, err)
+	}
 
-	// Encode domain name
-	parts := strings.Split(a.Name, ".")
-	for _, part := range parts {
-		result = append(result, byte(len(part)))
-		result = append(result, []byte(part)...)
+	pos := offset + bytesRead
+	if remainingBytes := len(data) - pos; remainingBytes < 10 {
+		return Answer{}, 0, fmt.Errorf("insufficient bytes for record fixed fields: need 10, got %d", remainingBytes)
+	}
+
+	a := Answer{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(data[pos : pos+2]),
+		Class: binary.BigEndian.Uint16(data[pos+2 : pos+4]),
+		TTL:   binary.BigEndian.Uint32(data[pos+4 : pos+8]),
+	}
+	length := int(binary.BigEndian.Uint16(data[pos+8 : pos+10]))
+	pos += 10
+
+	if remainingBytes := len(data) - pos; remainingBytes < length {
+		return Answer{}, 0, fmt.Errorf("insufficient bytes for RDATA: need %d, got %d", length, remainingBytes)
 	}
-	result = append(result, 0) // null terminator
 
-	// Type (2 bytes)
-	typeBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(typeBytes, a.Type)
-	result = append(result, typeBytes...)
+	// Empty RDATA is legal in some contexts (e.g. RFC 2136 deletes) and is kept as nil
+	if length > 0 {
+		a.RData = newRData(a.Type)
+		if err := a.RData.unpack(data, pos, pos+length); err != nil {
+			return Answer{}, 0, fmt.Errorf("failed to parse %s RDATA: %w", TypeString(a.Type), err)
+		}
+	}
 
-	// Class (2 bytes)
-	classBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(classBytes, a.Class)
-	result = append(result, classBytes...)
+	return a, bytesRead + 10 + length, nil
+}
 
-	// TTL (4 bytes)
-	ttlBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(ttlBytes, a.TTL)
-	result = append(result, ttlBytes...)
+func (a Answer) Encode() []byte {
+	var p packer
+	a.pack(&p)
+	return p.buf
+}
 
-	// Length (2 bytes)
-	lengthBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(lengthBytes, a.Length)
-	result = append(result, lengthBytes...)
+// pack appends the record to p, back-filling RDLENGTH once the RDATA is written.
+func (a Answer) pack(p *packer) {
+	p.name(a.Name, true)
+	p.uint16(a.Type)
+	p.uint16(a.Class)
+	p.uint32(a.TTL)
 
-	// RDATA
-	result = append(result, a.RData...)
+	lengthOffset := len(p.buf)
+	p.uint16(0)
+	if a.RData != nil {
+		a.RData.pack(p)
+	}
+	binary.BigEndian.PutUint16(p.buf[lengthOffset:], uint16(len(p.buf)-lengthOffset-2))
+}
 
-	return result
+// String returns the record in zone file presentation format.
+func (a Answer) String() string {
+	var rdata string
+	if a.RData != nil {
+		rdata = a.RData.String()
+	}
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", Fqdn(a.Name), a.TTL, ClassString(a.Class), TypeString(a.Type), rdata)
 }
 
+// encodeDomainName converts a dotted domain name to its uncompressed wire format.
+// Empty labels are skipped, so both "" and "." encode the root name.
 func encodeDomainName(domain string) []byte {
 	var result []byte
 	parts := strings.Split(domain, ".")
 	for _, part := range parts {
+		if part == "" {
+			continue
+		}
 		result = append(result, byte(len(part)))
 		result = append(result, []byte(part)...)
 	}
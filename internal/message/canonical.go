@@ -0,0 +1,172 @@
+package message
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// lowercaseRDataNames lists the types whose RDATA names are lowercased in
+// canonical form: those of RFC 4034 §6.2 this package knows, less NSEC
+// and RRSIG, which RFC 6840 §5.1 took off the list.
+var lowercaseRDataNames = map[uint16]bool{
+	TypeNS:    true,
+	TypeCNAME: true,
+	TypeSOA:   true,
+	TypePTR:   true,
+	TypeMX:    true,
+	TypeSRV:   true,
+}
+
+// CanonicalName returns name in canonical form (RFC 4034 §6.2):
+// lowercase, here without the trailing dot.
+func CanonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// CompareNames orders two names canonically (RFC 4034 §6.1), returning
+// -1, 0 or +1. Names are compared label by label from the root, each
+// label as a lowercase byte string, and a name sorts before the names
+// below it.
+func CompareNames(a, b string) int {
+	la, lb := nameLabels(CanonicalName(a)), nameLabels(CanonicalName(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(la) < len(lb):
+		return -1
+	case len(la) > len(lb):
+		return 1
+	}
+	return 0
+}
+
+// CountLabels returns the number of labels in name, not counting the root
+// or a leading wildcard label, as the RRSIG Labels field does (RFC 4034
+// §3.1.3).
+func CountLabels(name string) int {
+	labels := nameLabels(strings.TrimSuffix(name, "."))
+	if len(labels) > 0 && labels[0] == "*" {
+		return len(labels) - 1
+	}
+	return len(labels)
+}
+
+// Ancestors returns the names above name, nearest first, ending with the
+// root.
+func Ancestors(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	var names []string
+	for name != "" {
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		} else {
+			name = ""
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// NextCloser returns the name one label longer than encloser on the way
+// down to name (RFC 5155 §1.3), or name itself if encloser is not above
+// it.
+func NextCloser(name, encloser string) string {
+	name = strings.TrimSuffix(name, ".")
+	for next := name; next != ""; {
+		parent := ""
+		if i := strings.IndexByte(next, '.'); i >= 0 {
+			parent = next[i+1:]
+		}
+		if EqualNames(parent, encloser) {
+			return next
+		}
+		next = parent
+	}
+	return name
+}
+
+// WildcardName returns the wildcard name directly below encloser.
+func WildcardName(encloser string) string {
+	encloser = strings.TrimSuffix(encloser, ".")
+	if encloser == "" {
+		return "*"
+	}
+	return "*." + encloser
+}
+
+func nameLabels(name string) []string {
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
+// CanonicalRData returns the canonical wire form of RDATA of type rtype:
+// uncompressed, with embedded names lowercased for the types that call
+// for it.
+func CanonicalRData(rtype uint16, r RData) []byte {
+	p := packer{lower: lowercaseRDataNames[rtype]}
+	if r != nil {
+		r.pack(&p)
+	}
+	return p.buf
+}
+
+// CanonicalRRset returns the canonical wire form of an RRset (RFC 4034
+// §6.2, §6.3): each record with its owner name lowercased, its TTL set to
+// ttl and its RDATA in canonical form, sorted by RDATA and without
+// duplicates.
+func CanonicalRRset(rrset []Answer, ttl uint32) []byte {
+	rdatas := make([][]byte, 0, len(rrset))
+	for _, rr := range rrset {
+		rdatas = append(rdatas, CanonicalRData(rr.Type, rr.RData))
+	}
+	sort.Slice(rdatas, func(i, j int) bool { return bytes.Compare(rdatas[i], rdatas[j]) < 0 })
+
+	var p packer
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+		rr := rrset[0]
+		p.name(CanonicalName(rr.Name), false)
+		p.uint16(rr.Type)
+		p.uint16(rr.Class)
+		p.uint32(ttl)
+		p.uint16(uint16(len(rdata)))
+		p.bytes(rdata)
+	}
+	return p.buf
+}
+
+// SignedData returns the data the signature of r covers for rrset (RFC
+// 4034 §3.1.8.1): the RRSIG RDATA without the signature, with the signer's
+// name in canonical form, then the RRset in canonical form with the
+// original TTL. Records synthesized from a wildcard are signed under the
+// wildcard's name, which Labels tells apart from theirs.
+func (r *RRSIG) SignedData(rrset []Answer) []byte {
+	header := *r
+	header.SignerName = CanonicalName(r.SignerName)
+	var p packer
+	header.packHeader(&p)
+
+	if len(rrset) > 0 {
+		if labels := nameLabels(CanonicalName(rrset[0].Name)); len(labels) > int(r.Labels) {
+			owner := "*." + strings.Join(labels[len(labels)-int(r.Labels):], ".")
+			if r.Labels == 0 {
+				owner = "*"
+			}
+			renamed := make([]Answer, len(rrset))
+			for i, rr := range rrset {
+				rr.Name = owner
+				renamed[i] = rr
+			}
+			rrset = renamed
+		}
+	}
+	return append(p.buf, CanonicalRRset(rrset, r.OriginalTTL)...)
+}
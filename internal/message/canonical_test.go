@@ -0,0 +1,155 @@
+package message
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestAncestors(t *testing.T) {
+	tests := map[string]string{
+		"a.b.example.com":  "b.example.com example.com com ",
+		"a.b.example.com.": "b.example.com example.com com ",
+		"com":              "",
+		"":                 "",
+	}
+	for name, want := range tests {
+		if got := strings.Join(Ancestors(name), " "); got != want {
+			t.Errorf("Ancestors(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNextCloser(t *testing.T) {
+	tests := []struct{ name, encloser, want string }{
+		{"a.b.example.com", "example.com", "b.example.com"},
+		{"a.b.example.com", "Example.COM.", "b.example.com"},
+		{"a.b.example.com", "", "com"},
+		{"b.example.com", "example.com", "b.example.com"},
+		{"a.example.com", "example.net", "a.example.com"},
+	}
+	for _, tt := range tests {
+		if got := NextCloser(tt.name, tt.encloser); got != tt.want {
+			t.Errorf("NextCloser(%q, %q) = %q, want %q", tt.name, tt.encloser, got, tt.want)
+		}
+	}
+}
+
+func TestWildcardName(t *testing.T) {
+	for encloser, want := range map[string]string{"example.com": "*.example.com", "example.com.": "*.example.com", "": "*"} {
+		if got := WildcardName(encloser); got != want {
+			t.Errorf("WildcardName(%q) = %q, want %q", encloser, got, want)
+		}
+	}
+}
+
+func TestCompareNames(t *testing.T) {
+	// The canonical order example of RFC 4034 §6.1, less the escaped labels
+	ordered := []string{
+		"example",
+		"a.example",
+		"yljkjljk.a.example",
+		"Z.a.example",
+		"zABC.a.EXAMPLE",
+		"z.example",
+		"*.z.example",
+	}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got := CompareNames(a, b); got != want {
+				t.Errorf("CompareNames(%q, %q) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+	if got := CompareNames("Example.COM.", "example.com"); got != 0 {
+		t.Errorf("CompareNames ignores case and the trailing dot: got %d", got)
+	}
+	if got := CompareNames("", "com"); got != -1 {
+		t.Errorf("the root sorts first: got %d", got)
+	}
+}
+
+func TestCountLabels(t *testing.T) {
+	for name, want := range map[string]int{"": 0, "com": 1, "www.example.com.": 3, "*.example.com": 2, "*": 0} {
+		if got := CountLabels(name); got != want {
+			t.Errorf("CountLabels(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestCanonicalRRset(t *testing.T) {
+	ns := func(name, host string, ttl uint32) Answer {
+		return Answer{Name: name, Type: TypeNS, Class: ClassINET, TTL: ttl, RData: &NS{Host: host}}
+	}
+	record := func(host string) []byte {
+		var p packer
+		p.name("example.com", false)
+		p.uint16(TypeNS)
+		p.uint16(ClassINET)
+		p.uint32(3600)
+		rdata := encodeDomainName(host)
+		p.uint16(uint16(len(rdata)))
+		p.bytes(rdata)
+		return p.buf
+	}
+
+	// Owner and RDATA names are lowercased, the TTL replaced, duplicates
+	// dropped and the records sorted by RDATA
+	got := CanonicalRRset([]Answer{
+		ns("Example.COM", "NS2.example.com", 60),
+		ns("example.com", "ns1.example.com", 300),
+		ns("EXAMPLE.com", "ns2.EXAMPLE.com", 60),
+	}, 3600)
+	want := append(record("ns1.example.com"), record("ns2.example.com")...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("CanonicalRRset = % x\nwant % x", got, want)
+	}
+
+	// NSEC names keep their case (RFC 6840 §5.1)
+	nsec := CanonicalRData(TypeNSEC, &NSEC{NextDomain: "Host.Example.com", Types: []uint16{TypeA}})
+	if !bytes.HasPrefix(nsec, encodeDomainName("Host.Example.com")) {
+		t.Errorf("NSEC next name lowercased: % x", nsec)
+	}
+}
+
+func TestSignedData(t *testing.T) {
+	a := func(name string) Answer {
+		return Answer{Name: name, Type: TypeA, Class: ClassINET, TTL: 60, RData: &A{Addr: netip.MustParseAddr("192.0.2.1")}}
+	}
+	tests := []struct {
+		name     string
+		labels   uint8
+		owner    string
+		signedAs string
+	}{
+		{"owner", 3, "WWW.example.com", "www.example.com"},
+		{"synthesized from a wildcard", 2, "a.b.example.com", "*.example.com"},
+		{"synthesized from the root wildcard", 0, "example.com", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := &RRSIG{TypeCovered: TypeA, Algorithm: AlgED25519, Labels: tt.labels, OriginalTTL: 300,
+				Expiration: 2, Inception: 1, KeyTag: 1, SignerName: "Example.COM", Signature: []byte{1, 2, 3}}
+
+			// The RDATA without the signature and with the signer's name
+			// lowercased, then the RRset under the name it was signed as
+			var p packer
+			header := *sig
+			header.SignerName = "example.com"
+			header.packHeader(&p)
+			want := append(p.buf, CanonicalRRset([]Answer{a(tt.signedAs)}, 300)...)
+
+			if got := sig.SignedData([]Answer{a(tt.owner)}); !bytes.Equal(got, want) {
+				t.Errorf("SignedData = % x\nwant % x", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package message
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNSSEC algorithm numbers (RFC 8624 §3.1).
+const (
+	AlgRSASHA256       uint8 = 8
+	AlgRSASHA512       uint8 = 10
+	AlgECDSAP256SHA256 uint8 = 13
+	AlgECDSAP384SHA384 uint8 = 14
+	AlgED25519         uint8 = 15
+)
+
+// DS digest types (RFC 8624 §3.3).
+const (
+	DigestSHA1   uint8 = 1
+	DigestSHA256 uint8 = 2
+	DigestSHA384 uint8 = 4
+)
+
+// DNSKEY flags (RFC 4034 §2.1.1).
+const (
+	DNSKEYFlagZone uint16 = 0x0100
+	DNSKEYFlagSEP  uint16 = 0x0001
+)
+
+// NSEC3HashSHA1 is the only NSEC3 hash algorithm (RFC 5155 §11), and
+// NSEC3FlagOptOut the only flag.
+const (
+	NSEC3HashSHA1   uint8 = 1
+	NSEC3FlagOptOut uint8 = 0x01
+)
+
+// nsec3Encoding is the "base32hex" encoding NSEC3 uses for hashed owner
+// names (RFC 5155 §3.3), without padding.
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// DNSKEY is a zone's public key (RFC 4034 §2).
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+func (r *DNSKEY) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Flags, r.Protocol, r.Algorithm, base64.StdEncoding.EncodeToString(r.PublicKey))
+}
+
+func (r *DNSKEY) pack(p *packer) {
+	p.uint16(r.Flags)
+	p.uint8(r.Protocol)
+	p.uint8(r.Algorithm)
+	p.bytes(r.PublicKey)
+}
+
+func (r *DNSKEY) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Flags = u.uint16()
+	r.Protocol = u.uint8()
+	r.Algorithm = u.uint8()
+	r.PublicKey = u.rest()
+	return u.done()
+}
+
+// KeyTag returns the key tag that RRSIG and DS records use to refer to
+// the key (RFC 4034 Appendix B).
+func (r *DNSKEY) KeyTag() uint16 {
+	var ac uint32
+	for i, b := range PackRData(r) {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return uint16(ac)
+}
+
+// ToDS returns the DS record that refers to the key r owned by owner,
+// with a digest of type digestType (RFC 4034 §5.1.4).
+func (r *DNSKEY) ToDS(owner string, digestType uint8) (*DS, error) {
+	data := encodeDomainName(CanonicalName(owner))
+	data = append(data, PackRData(r)...)
+
+	var digest []byte
+	switch digestType {
+	case DigestSHA1:
+		sum := sha1.Sum(data)
+		digest = sum[:]
+	case DigestSHA256:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case DigestSHA384:
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	default:
+		return nil, fmt.Errorf("unsupported DS digest type %d", digestType)
+	}
+	return &DS{KeyTag: r.KeyTag(), Algorithm: r.Algorithm, DigestType: digestType, Digest: digest}, nil
+}
+
+// RRSIG is a signature over an RRset (RFC 4034 §3). Expiration and
+// Inception are in seconds since the Unix epoch, modulo 2^32.
+type RRSIG struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+func (r *RRSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s %s", TypeString(r.TypeCovered), r.Algorithm, r.Labels,
+		r.OriginalTTL, FormatSigTime(r.Expiration), FormatSigTime(r.Inception), r.KeyTag,
+		Fqdn(r.SignerName), base64.StdEncoding.EncodeToString(r.Signature))
+}
+
+func (r *RRSIG) pack(p *packer) {
+	r.packHeader(p)
+	p.bytes(r.Signature)
+}
+
+// packHeader writes every field but the signature, which is what a
+// signature covers along with the RRset (RFC 4034 §3.1.8.1).
+func (r *RRSIG) packHeader(p *packer) {
+	p.uint16(r.TypeCovered)
+	p.uint8(r.Algorithm)
+	p.uint8(r.Labels)
+	p.uint32(r.OriginalTTL)
+	p.uint32(r.Expiration)
+	p.uint32(r.Inception)
+	p.uint16(r.KeyTag)
+	p.name(r.SignerName, false)
+}
+
+func (r *RRSIG) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.TypeCovered = u.uint16()
+	r.Algorithm = u.uint8()
+	r.Labels = u.uint8()
+	r.OriginalTTL = u.uint32()
+	r.Expiration = u.uint32()
+	r.Inception = u.uint32()
+	r.KeyTag = u.uint16()
+	r.SignerName = u.name()
+	r.Signature = u.rest()
+	return u.done()
+}
+
+// Errors RRSIG.ValidAt reports for signatures outside their validity
+// period.
+var (
+	ErrSignatureExpired     = errors.New("signature expired")
+	ErrSignatureNotYetValid = errors.New("signature not yet valid")
+)
+
+// ValidAt checks that t lies within the validity period of r. Times are
+// compared in serial number arithmetic, so they wrap around in 2106 (RFC
+// 4034 §3.1.5).
+func (r *RRSIG) ValidAt(t time.Time) error {
+	now := uint32(t.Unix())
+	switch {
+	case int32(r.Expiration-now) < 0:
+		return ErrSignatureExpired
+	case int32(now-r.Inception) < 0:
+		return ErrSignatureNotYetValid
+	}
+	return nil
+}
+
+// SupportedAlgorithm reports whether RRSIG.Verify can check signatures
+// made with algorithm.
+func SupportedAlgorithm(algorithm uint8) bool {
+	switch algorithm {
+	case AlgRSASHA256, AlgRSASHA512, AlgECDSAP256SHA256, AlgECDSAP384SHA384, AlgED25519:
+		return true
+	}
+	return false
+}
+
+// Verify checks that r is key's signature over rrset. It does not check
+// the validity period; see ValidAt.
+func (r *RRSIG) Verify(key *DNSKEY, rrset []Answer) error {
+	if key.Algorithm != r.Algorithm || key.KeyTag() != r.KeyTag {
+		return errors.New("signature was not made with this key")
+	}
+	if key.Protocol != 3 || key.Flags&DNSKEYFlagZone == 0 {
+		return errors.New("not a zone key")
+	}
+	data := r.SignedData(rrset)
+
+	switch r.Algorithm {
+	case AlgRSASHA256, AlgRSASHA512:
+		public, err := rsaPublicKey(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		hash := crypto.SHA256
+		if r.Algorithm == AlgRSASHA512 {
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(data)
+		return rsa.VerifyPKCS1v15(public, hash, h.Sum(nil), r.Signature)
+
+	case AlgECDSAP256SHA256, AlgECDSAP384SHA384:
+		curve, hash := elliptic.P256(), crypto.SHA256
+		if r.Algorithm == AlgECDSAP384SHA384 {
+			curve, hash = elliptic.P384(), crypto.SHA384
+		}
+		size := curve.Params().BitSize / 8
+		if len(key.PublicKey) != 2*size || len(r.Signature) != 2*size {
+			return errors.New("malformed ECDSA key or signature")
+		}
+		public := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key.PublicKey[:size]),
+			Y:     new(big.Int).SetBytes(key.PublicKey[size:]),
+		}
+		h := hash.New()
+		h.Write(data)
+		if !ecdsa.Verify(public, h.Sum(nil), new(big.Int).SetBytes(r.Signature[:size]), new(big.Int).SetBytes(r.Signature[size:])) {
+			return errors.New("ECDSA verification failed")
+		}
+		return nil
+
+	case AlgED25519:
+		if len(key.PublicKey) != ed25519.PublicKeySize {
+			return errors.New("malformed Ed25519 key")
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key.PublicKey), data, r.Signature) {
+			return errors.New("Ed25519 verification failed")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported DNSSEC algorithm %d", r.Algorithm)
+}
+
+// rsaPublicKey decodes an RSA public key in DNSKEY form: the exponent's
+// length in one byte, or in two after a zero byte, the exponent, then the
+// modulus (RFC 3110 §2).
+func rsaPublicKey(data []byte) (*rsa.PublicKey, error) {
+	if len(data) < 3 {
+		return nil, errors.New("malformed RSA key")
+	}
+	length, data := int(data[0]), data[1:]
+	if length == 0 {
+		length, data = int(data[0])<<8|int(data[1]), data[2:]
+	}
+	if length == 0 || length > 4 || len(data) <= length {
+		return nil, errors.New("malformed RSA key")
+	}
+	exponent := 0
+	for _, b := range data[:length] {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(data[length:]), E: exponent}, nil
+}
+
+// FormatSigTime renders an RRSIG timestamp as YYYYMMDDHHmmSS in UTC
+// (RFC 4034 §3.2).
+func FormatSigTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format("20060102150405")
+}
+
+// DS refers to a DNSKEY of a delegated child zone by its digest (RFC 4034
+// §5).
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+func (r *DS) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.KeyTag, r.Algorithm, r.DigestType, strings.ToUpper(hex.EncodeToString(r.Digest)))
+}
+
+func (r *DS) pack(p *packer) {
+	p.uint16(r.KeyTag)
+	p.uint8(r.Algorithm)
+	p.uint8(r.DigestType)
+	p.bytes(r.Digest)
+}
+
+func (r *DS) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.KeyTag = u.uint16()
+	r.Algorithm = u.uint8()
+	r.DigestType = u.uint8()
+	r.Digest = u.rest()
+	return u.done()
+}
+
+// NSEC names the next owner name in a zone's canonical order and the
+// types present at its own name, proving that nothing lies between them
+// (RFC 4034 §4).
+type NSEC struct {
+	NextDomain string
+	Types      []uint16
+}
+
+func (r *NSEC) String() string {
+	return strings.TrimSuffix(Fqdn(r.NextDomain)+" "+typeListString(r.Types), " ")
+}
+
+func (r *NSEC) pack(p *packer) {
+	p.name(r.NextDomain, false)
+	packTypeBitmap(p, r.Types)
+}
+
+func (r *NSEC) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.NextDomain = u.name()
+	r.Types = unpackTypeBitmap(&u)
+	return u.done()
+}
+
+// NSEC3 is the hashed form of NSEC: it names the next hashed owner name
+// in the zone and the types present at the name it stands for (RFC 5155
+// §3).
+type NSEC3 struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+	NextHashed    []byte
+	Types         []uint16
+}
+
+func (r *NSEC3) String() string {
+	s := fmt.Sprintf("%d %d %d %s %s %s", r.HashAlgorithm, r.Flags, r.Iterations, saltString(r.Salt),
+		nsec3Encoding.EncodeToString(r.NextHashed), typeListString(r.Types))
+	return strings.TrimSuffix(s, " ")
+}
+
+func (r *NSEC3) pack(p *packer) {
+	p.uint8(r.HashAlgorithm)
+	p.uint8(r.Flags)
+	p.uint16(r.Iterations)
+	p.uint8(uint8(len(r.Salt)))
+	p.bytes(r.Salt)
+	p.uint8(uint8(len(r.NextHashed)))
+	p.bytes(r.NextHashed)
+	packTypeBitmap(p, r.Types)
+}
+
+func (r *NSEC3) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.HashAlgorithm = u.uint8()
+	r.Flags = u.uint8()
+	r.Iterations = u.uint16()
+	r.Salt = u.bytes(int(u.uint8()))
+	r.NextHashed = u.bytes(int(u.uint8()))
+	r.Types = unpackTypeBitmap(&u)
+	return u.done()
+}
+
+// NSEC3PARAM gives the parameters a zone's NSEC3 chain was built with
+// (RFC 5155 §4).
+type NSEC3PARAM struct {
+	HashAlgorithm uint8
+	Flags         uint8
+	Iterations    uint16
+	Salt          []byte
+}
+
+func (r *NSEC3PARAM) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.HashAlgorithm, r.Flags, r.Iterations, saltString(r.Salt))
+}
+
+func (r *NSEC3PARAM) pack(p *packer) {
+	p.uint8(r.HashAlgorithm)
+	p.uint8(r.Flags)
+	p.uint16(r.Iterations)
+	p.uint8(uint8(len(r.Salt)))
+	p.bytes(r.Salt)
+}
+
+func (r *NSEC3PARAM) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.HashAlgorithm = u.uint8()
+	r.Flags = u.uint8()
+	r.Iterations = u.uint16()
+	r.Salt = u.bytes(int(u.uint8()))
+	return u.done()
+}
+
+// HashName returns the NSEC3 hash of name (RFC 5155 §5): SHA-1 over its
+// canonical wire form and the salt, applied iterations more times over
+// the previous hash and the salt.
+func HashName(name string, iterations uint16, salt []byte) []byte {
+	h := sha1.New()
+	h.Write(encodeDomainName(CanonicalName(name)))
+	h.Write(salt)
+	hash := h.Sum(nil)
+	for i := uint16(0); i < iterations; i++ {
+		h.Reset()
+		h.Write(hash)
+		h.Write(salt)
+		hash = h.Sum(hash[:0])
+	}
+	return hash
+}
+
+// EncodeNSEC3Hash renders a hashed owner name as the label NSEC3 records
+// are owned by.
+func EncodeNSEC3Hash(hash []byte) string {
+	return strings.ToLower(nsec3Encoding.EncodeToString(hash))
+}
+
+// DecodeNSEC3Hash parses a hashed owner name label.
+func DecodeNSEC3Hash(s string) ([]byte, error) {
+	return nsec3Encoding.DecodeString(strings.ToUpper(s))
+}
+
+// saltString renders an NSEC3 salt in hex, or "-" if it is empty.
+func saltString(salt []byte) string {
+	if len(salt) == 0 {
+		return "-"
+	}
+	return strings.ToUpper(hex.EncodeToString(salt))
+}
+
+func typeListString(types []uint16) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = TypeString(t)
+	}
+	return strings.Join(names, " ")
+}
+
+// packTypeBitmap writes the type bitmap of NSEC and NSEC3 records: for
+// each 256-type window in use, its number, its length and a bit per type
+// (RFC 4034 §4.1.2).
+func packTypeBitmap(p *packer, types []uint16) {
+	sorted := append([]uint16(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap [32]byte
+		length := 0
+		for ; i < len(sorted) && sorted[i]>>8 == window; i++ {
+			low := sorted[i] & 0xFF
+			bitmap[low/8] |= 0x80 >> (low % 8)
+			length = int(low/8) + 1
+		}
+		p.uint8(uint8(window))
+		p.uint8(uint8(length))
+		p.bytes(bitmap[:length])
+	}
+}
+
+func unpackTypeBitmap(u *unpacker) []uint16 {
+	var types []uint16
+	for u.err == nil && u.off < u.end {
+		window := uint16(u.uint8())
+		length := int(u.uint8())
+		if u.err == nil && (length == 0 || length > 32) {
+			u.err = fmt.Errorf("invalid type bitmap window length %d", length)
+			break
+		}
+		for i, b := range u.bytes(length) {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, window<<8|uint16(i*8+bit))
+				}
+			}
+		}
+	}
+	return types
+}
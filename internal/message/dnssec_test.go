@@ -0,0 +1,179 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func mustBase64(s string) []byte {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestDNSSECRDataRoundTrip(t *testing.T) {
+	tests := []struct {
+		rtype uint16
+		rdata RData
+		text  string
+	}{
+		{TypeDNSKEY, &DNSKEY{Flags: 257, Protocol: 3, Algorithm: AlgED25519, PublicKey: mustBase64("l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=")},
+			"257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4="},
+		{TypeRRSIG, &RRSIG{TypeCovered: TypeMX, Algorithm: AlgED25519, Labels: 2, OriginalTTL: 3600, Expiration: 1440021600,
+			Inception: 1438207200, KeyTag: 3613, SignerName: "example.com", Signature: []byte{1, 2, 3}},
+			"MX 15 2 3600 20150819220000 20150729220000 3613 example.com. AQID"},
+		{TypeDS, &DS{KeyTag: 3613, Algorithm: AlgED25519, DigestType: DigestSHA256, Digest: []byte{0x3a, 0xa5, 0xab}},
+			"3613 15 2 3AA5AB"},
+		{TypeNSEC, &NSEC{NextDomain: "host.example.com", Types: []uint16{TypeA, TypeMX, TypeRRSIG, TypeNSEC, 1234}},
+			"host.example.com. A MX RRSIG NSEC TYPE1234"},
+		{TypeNSEC, &NSEC{NextDomain: "example.com"}, "example.com."},
+		{TypeNSEC3, &NSEC3{HashAlgorithm: NSEC3HashSHA1, Flags: NSEC3FlagOptOut, Iterations: 12, Salt: []byte{0xaa, 0xbb, 0xcc, 0xdd},
+			NextHashed: []byte{1, 2, 3, 4, 5}, Types: []uint16{TypeNS, TypeDS, TypeRRSIG}},
+			"1 1 12 AABBCCDD 04106105 NS DS RRSIG"},
+		{TypeNSEC3, &NSEC3{HashAlgorithm: NSEC3HashSHA1, NextHashed: []byte{1, 2, 3, 4, 5}}, "1 0 0 - 04106105"},
+		{TypeNSEC3PARAM, &NSEC3PARAM{HashAlgorithm: NSEC3HashSHA1, Iterations: 12, Salt: []byte{0xaa, 0xbb, 0xcc, 0xdd}}, "1 0 12 AABBCCDD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := DecodeRData(tt.rtype, PackRData(tt.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.rdata) {
+				t.Errorf("DecodeRData = %#v, want %#v", got, tt.rdata)
+			}
+			if s := got.String(); s != tt.text {
+				t.Errorf("String = %q, want %q", s, tt.text)
+			}
+		})
+	}
+}
+
+func TestTypeBitmap(t *testing.T) {
+	// The NSEC example of RFC 4034 §4.3: A, MX, RRSIG and NSEC in window
+	// 0 and TYPE1234 in window 4, listed out of order
+	rdata := PackRData(&NSEC{NextDomain: "", Types: []uint16{1234, TypeNSEC, TypeA, TypeRRSIG, TypeMX}})
+	want := []byte{0, 0x00, 0x06, 0x40, 0x01, 0x00, 0x00, 0x00, 0x03, 0x04, 0x1b}
+	want = append(want, make([]byte, 26)...)
+	want = append(want, 0x20)
+	if !bytes.Equal(rdata, want) {
+		t.Errorf("type bitmap = % x\nwant % x", rdata, want)
+	}
+}
+
+func TestDecodeDNSSECRDataMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		rtype uint16
+		data  []byte
+	}{
+		{"DNSKEY without algorithm", TypeDNSKEY, []byte{1, 1, 3}},
+		{"DS without digest type", TypeDS, []byte{0, 1, 15}},
+		{"RRSIG without signer", TypeRRSIG, make([]byte, 18)},
+		{"NSEC empty window", TypeNSEC, []byte{0, 0, 0}},
+		{"NSEC window too long", TypeNSEC, append([]byte{0, 0, 33}, make([]byte, 33)...)},
+		{"NSEC window past the end", TypeNSEC, []byte{0, 0, 2, 0x40}},
+		{"NSEC3 salt past the end", TypeNSEC3, []byte{1, 0, 0, 0, 4, 0xaa}},
+		{"NSEC3PARAM with trailing data", TypeNSEC3PARAM, []byte{1, 0, 0, 0, 0, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeRData(tt.rtype, tt.data); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestKeyTag(t *testing.T) {
+	tests := []struct {
+		name string
+		key  DNSKEY
+		want uint16
+	}{
+		// RFC 4034 §5.4
+		{"RSASHA1", DNSKEY{Flags: 256, Protocol: 3, Algorithm: 5, PublicKey: mustBase64(
+			"AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvx" +
+				"egXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==")}, 60485},
+		// RFC 8080 §6
+		{"Ed25519", DNSKEY{Flags: 257, Protocol: 3, Algorithm: AlgED25519, PublicKey: mustBase64("l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=")}, 3613},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.KeyTag(); got != tt.want {
+				t.Errorf("KeyTag = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNSEC3HashLabel(t *testing.T) {
+	hash := []byte{0xde, 0xad, 0xbe, 0xef, 0x42}
+	if label := EncodeNSEC3Hash(hash); label != "rqmrtrq2" {
+		t.Errorf("EncodeNSEC3Hash = %q, want lowercase base32hex without padding", label)
+	}
+	for _, s := range []string{"rqmrtrq2", "RQMRTRQ2"} {
+		if got, err := DecodeNSEC3Hash(s); err != nil || !bytes.Equal(got, hash) {
+			t.Errorf("DecodeNSEC3Hash(%q) = %x, %v", s, got, err)
+		}
+	}
+	if _, err := DecodeNSEC3Hash("www"); err == nil {
+		t.Error("DecodeNSEC3Hash accepted a label that is not base32hex")
+	}
+}
+
+func TestHashName(t *testing.T) {
+	// RFC 5155 Appendix A, hashed with 12 iterations and salt aabbccdd
+	tests := map[string]string{
+		"example":       "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example":     "35mthgpgcu1qg68fab165klnsnk3dpvl",
+		"ns1.example":   "2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+		"*.w.example":   "r53bq7cc2uvmubfu5ocmm6pers9tk9en",
+		"x.w.example":   "b4um86eghhds6nea196smvmlo4ors995",
+		"X.W.Example.":  "b4um86eghhds6nea196smvmlo4ors995",
+		"x.y.w.example": "2vptu5timamqttgl4luu9kg21e0aor3s",
+	}
+	for name, want := range tests {
+		if got := EncodeNSEC3Hash(HashName(name, 12, []byte{0xaa, 0xbb, 0xcc, 0xdd})); got != want {
+			t.Errorf("HashName(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestToDS(t *testing.T) {
+	rsa := DNSKEY{Flags: 256, Protocol: 3, Algorithm: 5, PublicKey: mustBase64(
+		"AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvx" +
+			"egXd/M5+X7OrzKBaMbCVdFLUUh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw==")}
+	ed25519 := DNSKEY{Flags: 257, Protocol: 3, Algorithm: AlgED25519, PublicKey: mustBase64("l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=")}
+
+	tests := []struct {
+		name       string
+		key        DNSKEY
+		owner      string
+		digestType uint8
+		want       string
+	}{
+		// RFC 4034 §5.4
+		{"SHA-1", rsa, "dskey.example.com", DigestSHA1, "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118"},
+		// RFC 8080 §6, with the owner name in another case
+		{"SHA-256", ed25519, "Example.COM.", DigestSHA256, "3613 15 2 3AA5AB37EFCE57F737FC1627013FEE07BDF241BD10F3B1964AB55C78E79A304B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds, err := tt.key.ToDS(tt.owner, tt.digestType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ds.String(); got != tt.want {
+				t.Errorf("ToDS = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := ed25519.ToDS("example.com", 3); err == nil {
+		t.Error("ToDS accepted an unsupported digest type")
+	}
+}
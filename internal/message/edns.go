@@ -0,0 +1,235 @@
+package message
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// EDNSVersion is the highest EDNS version this package implements.
+	EDNSVersion = 0
+
+	// MinUDPSize is the payload size every DNS client must accept (RFC 1035 §4.2.1).
+	MinUDPSize = 512
+
+	// ednsDOBit is the DNSSEC OK flag inside the OPT record's TTL field.
+	ednsDOBit = 1 << 15
+)
+
+// EDNS option codes
+const (
+	EDNSOptionCookie        uint16 = 10
+	EDNSOptionPadding       uint16 = 12
+	EDNSOptionExtendedError uint16 = 15
+)
+
+// Extended DNS Error info codes (RFC 8914 §4)
+const (
+	EDEOther                      uint16 = 0
+	EDEUnsupportedDNSKEYAlgorithm uint16 = 1
+	EDEStaleAnswer                uint16 = 3
+	EDEDNSSECBogus                uint16 = 6
+	EDESignatureExpired           uint16 = 7
+	EDESignatureNotYetValid       uint16 = 8
+	EDEDNSKEYMissing              uint16 = 9
+	EDERRSIGsMissing              uint16 = 10
+	EDENSECMissing                uint16 = 12
+)
+
+// EDNSOption is a single {attribute, value} pair carried in an OPT record.
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// OPT is the RDATA of the EDNS(0) OPT pseudo-record (RFC 6891 §6.1.2).
+type OPT struct {
+	Options []EDNSOption
+}
+
+func (r *OPT) String() string {
+	parts := make([]string, len(r.Options))
+	for i, o := range r.Options {
+		parts[i] = fmt.Sprintf("%d:%s", o.Code, hex.EncodeToString(o.Data))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (r *OPT) pack(p *packer) {
+	for _, o := range r.Options {
+		p.uint16(o.Code)
+		p.uint16(uint16(len(o.Data)))
+		p.bytes(o.Data)
+	}
+}
+
+func (r *OPT) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Options = nil
+	for u.err == nil && u.off < u.end {
+		code := u.uint16()
+		length := int(u.uint16())
+		r.Options = append(r.Options, EDNSOption{Code: code, Data: u.bytes(length)})
+	}
+	return u.done()
+}
+
+// EDNS is a typed view of the fields packed into an OPT pseudo-record's
+// CLASS and TTL (RFC 6891 §6.1.3).
+type EDNS struct {
+	// UDPSize is the largest UDP payload the sender can reassemble
+	UDPSize uint16
+	// ExtendedRCode holds the upper 8 bits of the 12-bit response code
+	ExtendedRCode uint8
+	// Version is the EDNS version the sender implements
+	Version uint8
+	// DO is the DNSSEC OK bit (RFC 3225)
+	DO bool
+	// Options are the variable-length options carried in the RDATA
+	Options []EDNSOption
+}
+
+// Record returns the OPT pseudo-record that carries e.
+func (e EDNS) Record() Answer {
+	ttl := uint32(e.ExtendedRCode)<<24 | uint32(e.Version)<<16
+	if e.DO {
+		ttl |= ednsDOBit
+	}
+	return Answer{
+		Name:  "",
+		Type:  TypeOPT,
+		Class: e.UDPSize,
+		TTL:   ttl,
+		RData: &OPT{Options: e.Options},
+	}
+}
+
+// Option returns the first option with the given code.
+func (e EDNS) Option(code uint16) (EDNSOption, bool) {
+	for _, o := range e.Options {
+		if o.Code == code {
+			return o, true
+		}
+	}
+	return EDNSOption{}, false
+}
+
+// ednsFromRecord decodes the EDNS fields of an OPT pseudo-record.
+func ednsFromRecord(a Answer) EDNS {
+	e := EDNS{
+		UDPSize:       a.Class,
+		ExtendedRCode: uint8(a.TTL >> 24),
+		Version:       uint8(a.TTL >> 16),
+		DO:            a.TTL&ednsDOBit != 0,
+	}
+	if opt, ok := a.RData.(*OPT); ok {
+		e.Options = opt.Options
+	}
+	return e
+}
+
+// EDNS returns the EDNS parameters from the message's OPT record, if it has one.
+func (m *Message) EDNS() (EDNS, bool) {
+	for _, a := range m.Additional {
+		if a.Type == TypeOPT {
+			return ednsFromRecord(a), true
+		}
+	}
+	return EDNS{}, false
+}
+
+// SetEDNS replaces any OPT record in the additional section with one carrying e.
+func (m *Message) SetEDNS(e EDNS) {
+	m.RemoveEDNS()
+	m.Additional = append(m.Additional, e.Record())
+}
+
+// RemoveEDNS drops the OPT record from the additional section.
+func (m *Message) RemoveEDNS() {
+	additional := m.Additional[:0:0]
+	for _, a := range m.Additional {
+		if a.Type != TypeOPT {
+			additional = append(additional, a)
+		}
+	}
+	m.Additional = additional
+}
+
+// RCode returns the full 12-bit response code, combining the header bits
+// with the extended bits from the OPT record.
+func (m *Message) RCode() int {
+	rcode := int(m.Header.RCode)
+	if e, ok := m.EDNS(); ok {
+		rcode |= int(e.ExtendedRCode) << 4
+	}
+	return rcode
+}
+
+// SetRCode stores a 12-bit response code. Codes above 15 need an OPT
+// record for their upper bits; one is added if the message has none.
+func (m *Message) SetRCode(rcode int) {
+	m.Header.RCode = uint8(rcode & 0xF)
+
+	e, ok := m.EDNS()
+	if !ok {
+		if rcode <= 0xF {
+			return
+		}
+		e = EDNS{UDPSize: MinUDPSize}
+	}
+	e.ExtendedRCode = uint8(rcode >> 4)
+	m.SetEDNS(e)
+}
+
+// AddExtendedError attaches an Extended DNS Error option (RFC 8914) with
+// the given info code and optional explanatory text. An OPT record is added
+// if the message has none.
+func (m *Message) AddExtendedError(code uint16, text string) {
+	e, ok := m.EDNS()
+	if !ok {
+		e = EDNS{UDPSize: MinUDPSize}
+	}
+	data := make([]byte, 2, 2+len(text))
+	data[0], data[1] = byte(code>>8), byte(code)
+	e.Options = append(e.Options[:len(e.Options):len(e.Options)], EDNSOption{Code: EDNSOptionExtendedError, Data: append(data, text...)})
+	m.SetEDNS(e)
+}
+
+// ExtendedErrors returns the Extended DNS Error options the message carries.
+func (m *Message) ExtendedErrors() []EDNSOption {
+	e, _ := m.EDNS()
+	var errors []EDNSOption
+	for _, o := range e.Options {
+		if o.Code == EDNSOptionExtendedError {
+			errors = append(errors, o)
+		}
+	}
+	return errors
+}
+
+// Pad adds an EDNS padding option (RFC 7830) sized so the encoded message
+// is a multiple of blockSize bytes. Any existing padding is replaced. The
+// message must already carry an OPT record; Pad does nothing otherwise.
+func (m *Message) Pad(blockSize int) {
+	e, ok := m.EDNS()
+	if !ok || blockSize <= 0 {
+		return
+	}
+
+	options := make([]EDNSOption, 0, len(e.Options)+1)
+	for _, o := range e.Options {
+		if o.Code != EDNSOptionPadding {
+			options = append(options, o)
+		}
+	}
+	e.Options = options
+	m.SetEDNS(e)
+
+	// The padding option costs four bytes of code and length before its data
+	unpadded := m.size(nil) + 4
+	padding := (blockSize - unpadded%blockSize) % blockSize
+
+	e.Options = append(e.Options, EDNSOption{Code: EDNSOptionPadding, Data: make([]byte, padding)})
+	m.SetEDNS(e)
+}
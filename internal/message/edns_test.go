@@ -0,0 +1,117 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEDNSRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		edns EDNS
+	}{
+		{"plain", EDNS{UDPSize: 1232}},
+		{"DO", EDNS{UDPSize: 4096, DO: true}},
+		{"version and extended rcode", EDNS{UDPSize: 512, Version: 1, ExtendedRCode: 1}},
+		{"options", EDNS{UDPSize: 1232, Options: []EDNSOption{
+			{Code: EDNSOptionCookie, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			{Code: EDNSOptionPadding, Data: []byte{0, 0}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := Message{Header: Header{ID: 1}, Questions: []Question{{Name: "example.com", Type: TypeA, Class: ClassINET}}}
+			msg.SetEDNS(tt.edns)
+			parsed, err := ParseMessage(msg.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := parsed.EDNS()
+			if !ok {
+				t.Fatal("no OPT record")
+			}
+			if !reflect.DeepEqual(got, tt.edns) {
+				t.Errorf("EDNS = %+v, want %+v", got, tt.edns)
+			}
+		})
+	}
+}
+
+func TestSetRCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		rcode    int
+		withEDNS bool
+		header   uint8
+		opt      bool
+	}{
+		{"NXDOMAIN", RCodeNameError, false, 3, false},
+		{"NXDOMAIN keeps OPT", RCodeNameError, true, 3, true},
+		{"BADVERS adds OPT", RCodeBadVersion, false, 0, true},
+		{"BADVERS", RCodeBadVersion, true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			if tt.withEDNS {
+				msg.SetEDNS(EDNS{UDPSize: 1232, DO: true})
+			}
+			msg.SetRCode(tt.rcode)
+			if msg.Header.RCode != tt.header {
+				t.Errorf("header RCODE = %d, want %d", msg.Header.RCode, tt.header)
+			}
+			if _, ok := msg.EDNS(); ok != tt.opt {
+				t.Errorf("has OPT = %v, want %v", ok, tt.opt)
+			}
+			if got := msg.RCode(); got != tt.rcode {
+				t.Errorf("RCode = %d, want %d", got, tt.rcode)
+			}
+			if e, _ := msg.EDNS(); tt.withEDNS && !e.DO {
+				t.Error("DO bit lost")
+			}
+		})
+	}
+}
+
+func TestExtendedErrors(t *testing.T) {
+	var msg Message
+	msg.AddExtendedError(EDEStaleAnswer, "")
+	msg.AddExtendedError(EDEDNSSECBogus, "bad signature")
+	got := msg.ExtendedErrors()
+	want := []EDNSOption{
+		{Code: EDNSOptionExtendedError, Data: []byte{0, 3}},
+		{Code: EDNSOptionExtendedError, Data: append([]byte{0, 6}, "bad signature"...)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtendedErrors = %v, want %v", got, want)
+	}
+	if e, _ := msg.EDNS(); e.UDPSize != MinUDPSize {
+		t.Errorf("added OPT record advertises %d bytes, want %d", e.UDPSize, MinUDPSize)
+	}
+
+	msg.RemoveEDNS()
+	if len(msg.ExtendedErrors()) != 0 || len(msg.Additional) != 0 {
+		t.Error("RemoveEDNS left the OPT record")
+	}
+}
+
+func TestPad(t *testing.T) {
+	for _, block := range []int{128, 468} {
+		msg := Message{Header: Header{ID: 1}, Questions: []Question{{Name: "www.example.com", Type: TypeA, Class: ClassINET}}}
+		msg.SetEDNS(EDNS{UDPSize: 1232, Options: []EDNSOption{{Code: EDNSOptionPadding, Data: make([]byte, 1000)}}})
+		msg.Pad(block)
+		if n := len(msg.Encode()); n%block != 0 || n > block {
+			t.Errorf("padded to %d bytes, want %d", n, block)
+		}
+		e, _ := msg.EDNS()
+		if len(e.Options) != 1 {
+			t.Errorf("%d options after padding, want the old padding replaced", len(e.Options))
+		}
+	}
+
+	var plain Message
+	plain.Pad(128)
+	if len(plain.Additional) != 0 {
+		t.Error("Pad added an OPT record")
+	}
+}
@@ -1,10 +1,29 @@
 package message
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"fmt"
 )
 
+// Bits of Header.Z defined by DNSSEC (RFC 4035 §3.2)
+const (
+	// ZAuthenticData is the AD bit: all answer and authority data was validated
+	ZAuthenticData = 0x2
+	// ZCheckingDisabled is the CD bit: the client does not want the resolver to validate
+	ZCheckingDisabled = 0x1
+)
+
+// RandomID returns a random message ID. Unpredictable IDs make it harder
+// to spoof responses to the queries a server sends (RFC 5452 §4.3).
+func RandomID() uint16 {
+	var b [2]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b[:])
+}
+
 // Header is the first 12 bytes of a DNS message
 // Integers are stored in network byte order (big-endian)
 type Header struct {
@@ -20,7 +39,7 @@ type Header struct {
 	// Authoritative Answer, 1-bit, 0 for no authoritative answer, 1 for authoritative answer
 	AA uint8
 
-	// Truncation, 1-bit, 1 if the message is larger than 512 bytes. Always 0 in UDP responses.
+	// Truncation, 1-bit, 1 if records were dropped so the message fits the transport's size limit
 	TC uint8
 
 	// Recursion Desired, 1-bit, Sender sets this to 1 if the server should recursively resolve this query, 0 otherwise
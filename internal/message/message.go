@@ -1,8 +1,12 @@
 package message
 
+import "fmt"
+
 const (
 	HeaderSize    = 12
 	StandardQuery = 0
+	Notify        = 4 // RFC 1996
+	Update        = 5 // RFC 2136
 )
 
 // Message represents a DNS message
@@ -10,18 +14,111 @@ type Message struct {
 	Header     Header
 	Questions  []Question
 	Answers    []Answer
-	Authority  []byte
-	Additional []byte
+	Authority  []Answer
+	Additional []Answer
+
+	// tsig, if set, signs the message as it is encoded
+	tsig *TSIGSigner
+}
+
+// ParseMessage decodes a complete DNS message from a byte slice.
+// The header counts drive how many questions and resource records are read
+// from each section; the returned header keeps the counts found on the wire.
+func ParseMessage(data []byte) (Message, error) {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to parse header: %w", err)
+	}
+
+	msg := Message{Header: header}
+	offset := HeaderSize
+
+	for i := uint16(0); i < header.QDCount; i++ {
+		question, bytesRead, err := ParseQuestion(data, offset)
+		if err != nil {
+			return Message{}, fmt.Errorf("failed to parse question %d: %w", i, err)
+		}
+		msg.Questions = append(msg.Questions, question)
+		offset += bytesRead
+	}
+
+	if msg.Answers, offset, err = parseSection(data, offset, header.ANCount, "answer"); err != nil {
+		return Message{}, err
+	}
+	if msg.Authority, offset, err = parseSection(data, offset, header.NSCount, "authority"); err != nil {
+		return Message{}, err
+	}
+	if msg.Additional, _, err = parseSection(data, offset, header.ARCount, "additional"); err != nil {
+		return Message{}, err
+	}
+
+	return msg, nil
 }
 
-// Encode converts the Message to a byte slice
+// parseSection reads count resource records starting at offset and returns
+// them together with the offset of the first byte after the section.
+func parseSection(data []byte, offset int, count uint16, section string) ([]Answer, int, error) {
+	var records []Answer
+	for i := uint16(0); i < count; i++ {
+		record, bytesRead, err := ParseAnswer(data, offset)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to parse %s record %d: %w", section, i, err)
+		}
+		records = append(records, record)
+		offset += bytesRead
+	}
+	return records, offset, nil
+}
+
+// Encode converts the Message to a byte slice.
+// The section counts in the header are derived from the section slices, and
+// owner names and compressible RDATA names are compressed (RFC 1035 §4.1.4).
+// A message given a signer with SetTSIG is signed, with the TSIG record
+// appended to the additional section.
 func (m *Message) Encode() []byte {
-	result := m.Header.Encode()
+	if m.tsig != nil {
+		return m.tsig.sign(m.encode())
+	}
+	return m.encode()
+}
+
+// size returns the length of the encoded message, without signing it. If
+// records is not nil, it is set to the encoded length of each record in
+// the answer, authority and additional sections, in that order.
+func (m *Message) size(records *[3][]int) int {
+	n := len(m.pack(records))
+	if m.tsig != nil {
+		n += m.tsig.size()
+	}
+	return n
+}
+
+func (m *Message) encode() []byte {
+	return m.pack(nil)
+}
+
+func (m *Message) pack(records *[3][]int) []byte {
+	header := m.Header
+	header.QDCount = uint16(len(m.Questions))
+	header.ANCount = uint16(len(m.Answers))
+	header.NSCount = uint16(len(m.Authority))
+	header.ARCount = uint16(len(m.Additional))
+
+	p := packer{buf: header.Encode(), names: make(map[string]int)}
 	for _, q := range m.Questions {
-		result = append(result, q.Encode()...)
+		q.pack(&p)
 	}
-	for _, a := range m.Answers {
-		result = append(result, a.Encode()...)
+	for i, section := range [][]Answer{m.Answers, m.Authority, m.Additional} {
+		if records != nil {
+			records[i] = make([]int, len(section))
+		}
+		for j, a := range section {
+			start := len(p.buf)
+			a.pack(&p)
+			if records != nil {
+				records[i][j] = len(p.buf) - start
+			}
+		}
 	}
-	return append(result, append(m.Authority, m.Additional...)...)
+	return p.buf
 }
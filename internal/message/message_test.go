@@ -0,0 +1,99 @@
+package message
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestParseMessageRoundTrip(t *testing.T) {
+	question := Question{Name: "www.example.com", Type: TypeA, Class: ClassINET}
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"query", Message{
+			Header:    Header{ID: 0xBEEF, RD: 1},
+			Questions: []Question{question},
+		}},
+		{"every section", Message{
+			Header:    Header{ID: 2, QR: 1, AA: 1, RD: 1, RA: 1, RCode: 3},
+			Questions: []Question{question},
+			Answers: []Answer{
+				{Name: "www.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 300, RData: &CNAME{Target: "web.example.com"}},
+				{Name: "web.example.com", Type: TypeA, Class: ClassINET, TTL: 300, RData: &A{Addr: netip.MustParseAddr("192.0.2.1")}},
+			},
+			Authority: []Answer{
+				{Name: "example.com", Type: TypeNS, Class: ClassINET, TTL: 3600, RData: &NS{Host: "ns1.example.com"}},
+			},
+			Additional: []Answer{
+				{Name: "ns1.example.com", Type: TypeAAAA, Class: ClassINET, TTL: 3600, RData: &AAAA{Addr: netip.MustParseAddr("2001:db8::53")}},
+			},
+		}},
+		{"several questions", Message{
+			Header:    Header{ID: 3, Opcode: Notify},
+			Questions: []Question{question, {Name: "example.com", Type: TypeSOA, Class: ClassINET}},
+		}},
+		{"root and empty RDATA", Message{
+			Header:    Header{ID: 4, QR: 1},
+			Questions: []Question{{Name: "", Type: TypeNS, Class: ClassINET}},
+			Answers:   []Answer{{Name: "", Type: TypeNS, Class: ClassINET, TTL: 60, RData: &NS{Host: ""}}},
+			Authority: []Answer{{Name: "example.com", Type: TypeA, Class: ClassANY}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMessage(tt.msg.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.msg
+			want.Header.QDCount = uint16(len(want.Questions))
+			want.Header.ANCount = uint16(len(want.Answers))
+			want.Header.NSCount = uint16(len(want.Authority))
+			want.Header.ARCount = uint16(len(want.Additional))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ParseMessage =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseMessageMalformed(t *testing.T) {
+	response := Message{
+		Header:    Header{ID: 1, QR: 1},
+		Questions: []Question{{Name: "example.com", Type: TypeA, Class: ClassINET}},
+		Answers:   []Answer{{Name: "example.com", Type: TypeA, Class: ClassINET, TTL: 60, RData: &A{Addr: netip.MustParseAddr("192.0.2.1")}}},
+	}
+	data := response.Encode()
+	withCounts := func(qd, an uint16) []byte {
+		h := Header{ID: 1, QR: 1, QDCount: qd, ANCount: an}
+		return append(h.Encode(), data[HeaderSize:]...)
+	}
+	// The answer's RDLENGTH claims one byte more than follows it
+	longRData := append([]byte(nil), data...)
+	longRData[len(longRData)-5]++
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", data[:HeaderSize-1]},
+		{"missing question", withCounts(2, 1)},
+		{"missing answer", withCounts(1, 2)},
+		{"truncated fixed fields", data[:len(data)-10]},
+		{"truncated RDATA", data[:len(data)-1]},
+		{"RDLENGTH past the end", longRData},
+		{"RDATA too short for its type", func() []byte {
+			b := append([]byte(nil), data[:len(data)-6]...)
+			return append(b, 0, 2, 192, 0)
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseMessage(tt.data); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
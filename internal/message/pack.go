@@ -0,0 +1,199 @@
+package message
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// maxPointerOffset is the largest offset a 14-bit compression pointer can address.
+const maxPointerOffset = 0x3FFF
+
+// packer accumulates the wire format of a message or record.
+type packer struct {
+	buf []byte
+
+	// names maps lowercased name suffixes to the offset where they were
+	// written. A nil map disables compression.
+	names map[string]int
+
+	// lower writes every name in lowercase, for canonical form
+	lower bool
+}
+
+func (p *packer) uint8(v uint8) {
+	p.buf = append(p.buf, v)
+}
+
+func (p *packer) uint16(v uint16) {
+	p.buf = binary.BigEndian.AppendUint16(p.buf, v)
+}
+
+func (p *packer) uint32(v uint32) {
+	p.buf = binary.BigEndian.AppendUint32(p.buf, v)
+}
+
+func (p *packer) bytes(b []byte) {
+	p.buf = append(p.buf, b...)
+}
+
+// characterString writes a length-prefixed <character-string>.
+// Callers are responsible for keeping s within 255 bytes.
+func (p *packer) characterString(s string) {
+	p.buf = append(p.buf, byte(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+// name writes a domain name. compress reports whether RFC 1035 allows the
+// name to be replaced by a compression pointer at this position; names that
+// may not be compressed are still recorded as pointer targets.
+func (p *packer) name(n string, compress bool) {
+	if p.lower {
+		n = strings.ToLower(n)
+	}
+	if p.names == nil {
+		p.buf = append(p.buf, encodeDomainName(n)...)
+		return
+	}
+
+	labels := strings.FieldsFunc(n, func(r rune) bool { return r == '.' })
+	for i := range labels {
+		suffix := strings.ToLower(strings.Join(labels[i:], "."))
+		if offset, ok := p.names[suffix]; ok && compress {
+			p.uint16(0xC000 | uint16(offset))
+			return
+		}
+		if _, ok := p.names[suffix]; !ok && len(p.buf) <= maxPointerOffset {
+			p.names[suffix] = len(p.buf)
+		}
+		p.buf = append(p.buf, byte(len(labels[i])))
+		p.buf = append(p.buf, labels[i]...)
+	}
+	p.buf = append(p.buf, 0)
+}
+
+// unpacker reads fields from data[off:end]. The first error is sticky, so
+// callers can read a whole RDATA and check err once at the end.
+type unpacker struct {
+	data []byte
+	off  int
+	end  int
+	err  error
+}
+
+func (u *unpacker) need(n int) bool {
+	if u.err != nil {
+		return false
+	}
+	if u.off+n > u.end {
+		u.err = fmt.Errorf("RDATA too short: need %d bytes at offset %d, have %d", n, u.off, u.end-u.off)
+		return false
+	}
+	return true
+}
+
+func (u *unpacker) uint8() uint8 {
+	if !u.need(1) {
+		return 0
+	}
+	v := u.data[u.off]
+	u.off++
+	return v
+}
+
+func (u *unpacker) uint16() uint16 {
+	if !u.need(2) {
+		return 0
+	}
+	v := binary.BigEndian.Uint16(u.data[u.off:])
+	u.off += 2
+	return v
+}
+
+func (u *unpacker) uint32() uint32 {
+	if !u.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(u.data[u.off:])
+	u.off += 4
+	return v
+}
+
+func (u *unpacker) bytes(n int) []byte {
+	if !u.need(n) {
+		return nil
+	}
+	b := append([]byte(nil), u.data[u.off:u.off+n]...)
+	u.off += n
+	return b
+}
+
+// rest returns every remaining byte of the RDATA.
+func (u *unpacker) rest() []byte {
+	if u.err != nil {
+		return nil
+	}
+	return u.bytes(u.end - u.off)
+}
+
+func (u *unpacker) characterString() string {
+	n := int(u.uint8())
+	return string(u.bytes(n))
+}
+
+// name reads a possibly compressed domain name. Pointers may refer anywhere
+// in the message, but the name itself must not run past the RDATA.
+func (u *unpacker) name() string {
+	if u.err != nil {
+		return ""
+	}
+	if u.off >= u.end {
+		u.err = fmt.Errorf("RDATA too short: missing domain name at offset %d", u.off)
+		return ""
+	}
+	name, bytesRead, err := parseDomainName(u.data[:u.end], u.off)
+	if err != nil {
+		u.err = err
+		return ""
+	}
+	u.off += bytesRead
+	return name
+}
+
+// done reports the sticky error, or an error if bytes were left unread.
+func (u *unpacker) done() error {
+	if u.err != nil {
+		return u.err
+	}
+	if u.off != u.end {
+		return fmt.Errorf("%d trailing bytes in RDATA", u.end-u.off)
+	}
+	return nil
+}
+
+// Fqdn returns name in fully qualified presentation form, with a trailing dot.
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// EqualNames reports whether two domain names are the same, comparing
+// case-insensitively and ignoring a trailing dot.
+func EqualNames(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// IsSubdomain reports whether name is zone or lies below it. Every name is
+// a subdomain of the root, written "".
+func IsSubdomain(name, zone string) bool {
+	name, zone = strings.TrimSuffix(name, "."), strings.TrimSuffix(zone, ".")
+	if zone == "" {
+		return true
+	}
+	if len(name) < len(zone) || !strings.EqualFold(name[len(name)-len(zone):], zone) {
+		return false
+	}
+	return len(name) == len(zone) || name[len(name)-len(zone)-1] == '.'
+}
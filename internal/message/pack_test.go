@@ -0,0 +1,102 @@
+package message
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestEncodeCompression(t *testing.T) {
+	a := func(name string) Answer {
+		return Answer{Name: name, Type: TypeA, Class: ClassINET, TTL: 60, RData: &A{Addr: netip.MustParseAddr("192.0.2.1")}}
+	}
+	question := func(name string, qtype uint16) []Question {
+		return []Question{{Name: name, Type: qtype, Class: ClassINET}}
+	}
+	// The question name www.example.com takes 17 bytes and ends at 29,
+	// and the question at 33. A record's fixed fields take 10 bytes.
+	tests := []struct {
+		name string
+		msg  Message
+		size int
+	}{
+		{"owner repeats the question", Message{
+			Questions: question("www.example.com", TypeA),
+			Answers:   []Answer{a("www.example.com")},
+		}, 33 + 2 + 10 + 4},
+		{"owner shares a suffix", Message{
+			Questions: question("www.example.com", TypeA),
+			Answers:   []Answer{a("mail.example.com")},
+		}, 33 + 5 + 2 + 10 + 4},
+		{"names compare case-insensitively", Message{
+			Questions: question("www.example.com", TypeA),
+			Answers:   []Answer{a("WWW.Example.COM")},
+		}, 33 + 2 + 10 + 4},
+		{"CNAME target", Message{
+			Questions: question("www.example.com", TypeCNAME),
+			Answers:   []Answer{{Name: "www.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 60, RData: &CNAME{Target: "web.example.com"}}},
+		}, 33 + 2 + 10 + 4 + 2},
+		{"MX exchange", Message{
+			Questions: question("example.com", TypeMX),
+			Answers:   []Answer{{Name: "example.com", Type: TypeMX, Class: ClassINET, TTL: 60, RData: &MX{Preference: 10, Exchange: "mail.example.com"}}},
+		}, 12 + 13 + 4 + 2 + 10 + 2 + 5 + 2},
+		// SRV targets are written in full but later names may point into them
+		{"SRV target", Message{
+			Questions:  question("_sip._tcp.example.com", TypeSRV),
+			Answers:    []Answer{{Name: "_sip._tcp.example.com", Type: TypeSRV, Class: ClassINET, TTL: 60, RData: &SRV{Port: 5060, Target: "sip.example.com"}}},
+			Additional: []Answer{a("sip.example.com")},
+		}, 12 + 23 + 4 + 2 + 10 + 6 + 17 + 2 + 10 + 4},
+		{"root", Message{
+			Questions: question("", TypeNS),
+			Answers:   []Answer{{Name: "", Type: TypeNS, Class: ClassINET, TTL: 60, RData: &NS{Host: "a.root-servers.net"}}},
+		}, 12 + 1 + 4 + 1 + 10 + 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.msg.Encode()
+			if len(data) != tt.size {
+				t.Errorf("encoded %d bytes, want %d", len(data), tt.size)
+			}
+			got, err := ParseMessage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := append(append(got.Answers, got.Authority...), got.Additional...)
+			want := append(append(tt.msg.Answers, tt.msg.Authority...), tt.msg.Additional...)
+			for i, rr := range records {
+				if !EqualNames(rr.Name, want[i].Name) || rr.RData.String() != want[i].RData.String() {
+					t.Errorf("record %d = %v, want %v", i, rr, want[i])
+				}
+			}
+		})
+	}
+}
+
+// Names past the reach of a 14-bit pointer are written in full and never
+// pointed at.
+func TestEncodeCompressionBeyondPointerRange(t *testing.T) {
+	msg := Message{Questions: []Question{{Name: "example.com", Type: TypeTXT, Class: ClassINET}}}
+	for i := 0; i < 400; i++ {
+		msg.Answers = append(msg.Answers, Answer{
+			Name: fmt.Sprintf("r%d.example.com", i), Type: TypeTXT, Class: ClassINET, TTL: 60,
+			RData: &TXT{Text: []string{strings.Repeat("x", 40)}},
+		})
+	}
+	msg.Answers = append(msg.Answers, Answer{Name: "late.example.org", Type: TypeTXT, Class: ClassINET, TTL: 60, RData: &TXT{}})
+	msg.Answers = append(msg.Answers, Answer{Name: "late.example.org", Type: TypeTXT, Class: ClassINET, TTL: 60, RData: &TXT{}})
+
+	data := msg.Encode()
+	if len(data) <= maxPointerOffset {
+		t.Fatalf("message is only %d bytes", len(data))
+	}
+	got, err := ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, rr := range got.Answers {
+		if rr.Name != msg.Answers[i].Name {
+			t.Fatalf("answer %d has name %q, want %q", i, rr.Name, msg.Answers[i].Name)
+		}
+	}
+}
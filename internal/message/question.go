@@ -6,6 +6,10 @@ import (
 	"strings"
 )
 
+// maxNameLength is the longest a domain name may be in wire form (RFC 1035
+// §2.3.4).
+const maxNameLength = 255
+
 // Question represents a DNS question section
 type Question struct {
 	Name  string
@@ -37,13 +41,28 @@ func ParseQuestion(data []byte, offset int) (Question, int, error) {
 
 // parseDomainName parses a DNS domain name from the given byte slice
 func parseDomainName(data []byte, startOffset int) (string, int, error) {
+	name, bytesRead, err := parseName(data, startOffset, startOffset)
+	if err != nil {
+		return "", 0, err
+	}
+	// The wire form has a length byte per label and the root label
+	if name != "" && len(name)+2 > maxNameLength {
+		return "", 0, fmt.Errorf("domain name at offset %d exceeds %d bytes", startOffset, maxNameLength)
+	}
+	return name, bytesRead, nil
+}
+
+// parseName parses the name at startOffset. limit is the lowest offset of
+// the name parts read so far, and compression pointers must point below
+// it: each pointer then moves strictly backwards, so following them
+// always ends, even when a pointer targets the name that contains it.
+func parseName(data []byte, startOffset, limit int) (string, int, error) {
 	if startOffset >= len(data) {
 		return "", 0, fmt.Errorf("start offset %d exceeds data length %d", startOffset, len(data))
 	}
 
 	var result []string
 	var totalBytes int
-	var seenPointers = make(map[int]bool)
 
 	currentPos := 0
 	for {
@@ -66,25 +85,30 @@ func parseDomainName(data []byte, startOffset int) (string, int, error) {
 				return "", 0, fmt.Errorf("compression pointer offset %d exceeds data length %d", pointerOffset, len(data))
 			}
 
-			if seenPointers[pointerOffset] {
+			if pointerOffset >= limit {
 				return "", 0, fmt.Errorf("pointer loop detected at offset %d", pointerOffset)
 			}
-			seenPointers[pointerOffset] = true
 
 			if totalBytes == 0 {
 				totalBytes = currentPos + 2 // 2 bytes for compression pointer
 			}
 
 			// Get the suffix from the compression pointer
-			suffix, _, err := parseDomainName(data, pointerOffset)
+			suffix, _, err := parseName(data, pointerOffset, pointerOffset)
 			if err != nil {
 				return "", 0, fmt.Errorf("failed to parse pointer target at offset %d: %w", pointerOffset, err)
 			}
 
-			result = append(result, strings.Split(suffix, ".")...)
+			if suffix != "" {
+				result = append(result, strings.Split(suffix, ".")...)
+			}
 			break
 		}
 
+		if length&0xC0 != 0 {
+			return "", 0, fmt.Errorf("unsupported label type 0x%02x at position %d", length&0xC0, absolutePos)
+		}
+
 		// End of domain name
 		if length == 0 {
 			if totalBytes == 0 {
@@ -114,25 +138,13 @@ func parseDomainName(data []byte, startOffset int) (string, int, error) {
 
 // Encode converts a Question to its wire format
 func (q Question) Encode() []byte {
-	result := make([]byte, 0)
-
-	// Encode domain name
-	parts := strings.Split(q.Name, ".")
-	for _, part := range parts {
-		result = append(result, byte(len(part)))
-		result = append(result, []byte(part)...)
-	}
-	result = append(result, 0)
-
-	// Type
-	typeBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(typeBytes, q.Type)
-	result = append(result, typeBytes...)
-
-	// Class
-	classBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(classBytes, q.Class)
-	result = append(result, classBytes...)
+	var p packer
+	q.pack(&p)
+	return p.buf
+}
 
-	return result
+func (q Question) pack(p *packer) {
+	p.name(q.Name, true)
+	p.uint16(q.Type)
+	p.uint16(q.Class)
 }
@@ -0,0 +1,72 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+// queryWithName returns a query header with one question followed by name,
+// which is the raw wire form of the question's name, and QTYPE A QCLASS IN.
+func queryWithName(name ...byte) []byte {
+	data := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	data = append(data, name...)
+	return append(data, 0, 1, 0, 1)
+}
+
+func TestParseDomainNameMalformed(t *testing.T) {
+	long := []byte{}
+	for i := 0; i < 5; i++ {
+		long = append(long, 63)
+		long = append(long, strings.Repeat("a", 63)...)
+	}
+	long = append(long, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// 1 'a' at offset 12, then a pointer back to offset 12
+		{"self-referencing pointer", queryWithName(1, 'a', 0xC0, 12)},
+		{"pointer to itself", queryWithName(0xC0, 12)},
+		// Offset 12 points at 14, which points back at 12
+		{"pointers pointing at each other", queryWithName(0xC0, 14, 0xC0, 12)},
+		{"forward pointer", queryWithName(0xC0, 14, 0)},
+		{"pointer past the end", queryWithName(0xC0, 0xFF)},
+		{"truncated pointer", []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0}},
+		{"truncated label", []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 5, 'a'}},
+		{"reserved label type", queryWithName(0x40, 0)},
+		{"name over 255 bytes", queryWithName(long...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseMessage(tt.data); err == nil {
+				t.Error("ParseMessage succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestParseDomainNameCompressed(t *testing.T) {
+	m := Message{
+		Header:    Header{ID: 1, QR: 1},
+		Questions: []Question{{Name: "www.example.com", Type: TypeA, Class: ClassINET}},
+		Answers: []Answer{
+			{Name: "www.example.com", Type: TypeCNAME, Class: ClassINET, TTL: 60, RData: &CNAME{Target: "web.example.com"}},
+			{Name: "web.example.com", Type: TypeNS, Class: ClassINET, TTL: 60, RData: &NS{Host: "example.com"}},
+		},
+	}
+	data := m.Encode()
+	got, err := ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Questions[0].Name != "www.example.com" || got.Answers[1].Name != "web.example.com" {
+		t.Errorf("names = %q, %q", got.Questions[0].Name, got.Answers[1].Name)
+	}
+	if target := got.Answers[0].RData.(*CNAME).Target; target != "web.example.com" {
+		t.Errorf("CNAME target = %q", target)
+	}
+	if host := got.Answers[1].RData.(*NS).Host; host != "example.com" {
+		t.Errorf("NS host = %q", host)
+	}
+}
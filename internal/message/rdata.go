@@ -0,0 +1,395 @@
+package message
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+)
+
+// RData is the type-specific payload of a resource record.
+// Each implementation encodes and decodes its own wire format; RDLENGTH is
+// computed when the record is encoded.
+type RData interface {
+	// String returns the RDATA in zone file presentation format.
+	String() string
+
+	// pack appends the wire format of the RDATA.
+	pack(p *packer)
+
+	// unpack decodes the RDATA in data[offset:end]. data is the whole
+	// message so that compression pointers can be followed.
+	unpack(data []byte, offset, end int) error
+}
+
+// rdataTypes maps record types to constructors for their RDATA.
+// Types not listed here decode as Unknown.
+var rdataTypes = map[uint16]func() RData{
+	TypeA:          func() RData { return new(A) },
+	TypeNS:         func() RData { return new(NS) },
+	TypeCNAME:      func() RData { return new(CNAME) },
+	TypeSOA:        func() RData { return new(SOA) },
+	TypePTR:        func() RData { return new(PTR) },
+	TypeMX:         func() RData { return new(MX) },
+	TypeTXT:        func() RData { return new(TXT) },
+	TypeAAAA:       func() RData { return new(AAAA) },
+	TypeSRV:        func() RData { return new(SRV) },
+	TypeOPT:        func() RData { return new(OPT) },
+	TypeDS:         func() RData { return new(DS) },
+	TypeRRSIG:      func() RData { return new(RRSIG) },
+	TypeNSEC:       func() RData { return new(NSEC) },
+	TypeDNSKEY:     func() RData { return new(DNSKEY) },
+	TypeNSEC3:      func() RData { return new(NSEC3) },
+	TypeNSEC3PARAM: func() RData { return new(NSEC3PARAM) },
+	TypeTSIG:       func() RData { return new(TSIG) },
+	TypeCAA:        func() RData { return new(CAA) },
+}
+
+// newRData returns an empty RDATA value for the given record type.
+func newRData(t uint16) RData {
+	if f, ok := rdataTypes[t]; ok {
+		return f()
+	}
+	return new(Unknown)
+}
+
+// DecodeRData decodes uncompressed wire-format RDATA of the given type,
+// such as the RFC 3597 generic form in a zone file.
+func DecodeRData(t uint16, data []byte) (RData, error) {
+	rdata := newRData(t)
+	if err := rdata.unpack(data, 0, len(data)); err != nil {
+		return nil, fmt.Errorf("invalid %s RDATA: %w", TypeString(t), err)
+	}
+	return rdata, nil
+}
+
+// CheckRData reports whether r is usable as the RDATA of a record of type
+// rtype: types this package decodes need RDATA of their own Go type, and
+// addresses must be of the record's family. Other types may carry
+// Unknown RDATA or none.
+func CheckRData(rtype uint16, r RData) error {
+	f, known := rdataTypes[rtype]
+	if !known {
+		if _, ok := r.(*Unknown); ok || r == nil {
+			return nil
+		}
+		return fmt.Errorf("%s record has %T RDATA", TypeString(rtype), r)
+	}
+	if r == nil {
+		return fmt.Errorf("%s record has no RDATA", TypeString(rtype))
+	}
+	if want := f(); reflect.TypeOf(r) != reflect.TypeOf(want) {
+		return fmt.Errorf("%s record has %T RDATA, want %T", TypeString(rtype), r, want)
+	}
+	switch r := r.(type) {
+	case *A:
+		if !r.Addr.Unmap().Is4() {
+			return fmt.Errorf("A record has address %q, not IPv4", r.Addr)
+		}
+	case *AAAA:
+		if !r.Addr.Is6() {
+			return fmt.Errorf("AAAA record has address %q, not IPv6", r.Addr)
+		}
+	}
+	return nil
+}
+
+// PackRData returns the uncompressed wire format of r.
+func PackRData(r RData) []byte {
+	var p packer
+	r.pack(&p)
+	return p.buf
+}
+
+// A is an IPv4 host address (RFC 1035 §3.4.1).
+type A struct {
+	Addr netip.Addr
+}
+
+func (r *A) String() string { return r.Addr.String() }
+
+// pack writes the address, unmapping an IPv4-mapped IPv6 one. An address
+// with no IPv4 form, such as the zero Addr, is written as 0.0.0.0 so the
+// RDATA keeps its fixed length; callers building records are expected to
+// check Is4 first.
+func (r *A) pack(p *packer) {
+	addr := r.Addr.Unmap()
+	if !addr.Is4() {
+		p.bytes(make([]byte, 4))
+		return
+	}
+	b := addr.As4()
+	p.bytes(b[:])
+}
+
+func (r *A) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	b := u.bytes(4)
+	if err := u.done(); err != nil {
+		return err
+	}
+	r.Addr = netip.AddrFrom4([4]byte(b))
+	return nil
+}
+
+// AAAA is an IPv6 host address (RFC 3596).
+type AAAA struct {
+	Addr netip.Addr
+}
+
+func (r *AAAA) String() string { return r.Addr.String() }
+
+// pack writes the address. IPv4 addresses are written in their mapped
+// form and the zero Addr as ::, so the RDATA keeps its fixed length.
+func (r *AAAA) pack(p *packer) {
+	if !r.Addr.IsValid() {
+		p.bytes(make([]byte, 16))
+		return
+	}
+	b := r.Addr.As16()
+	p.bytes(b[:])
+}
+
+func (r *AAAA) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	b := u.bytes(16)
+	if err := u.done(); err != nil {
+		return err
+	}
+	r.Addr = netip.AddrFrom16([16]byte(b))
+	return nil
+}
+
+// CNAME is the canonical name for an alias (RFC 1035 §3.3.1).
+type CNAME struct {
+	Target string
+}
+
+func (r *CNAME) String() string { return Fqdn(r.Target) }
+
+func (r *CNAME) pack(p *packer) { p.name(r.Target, true) }
+
+func (r *CNAME) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Target = u.name()
+	return u.done()
+}
+
+// NS is an authoritative name server for a zone (RFC 1035 §3.3.11).
+type NS struct {
+	Host string
+}
+
+func (r *NS) String() string { return Fqdn(r.Host) }
+
+func (r *NS) pack(p *packer) { p.name(r.Host, true) }
+
+func (r *NS) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Host = u.name()
+	return u.done()
+}
+
+// PTR points to another location in the domain name space (RFC 1035 §3.3.12).
+type PTR struct {
+	Target string
+}
+
+func (r *PTR) String() string { return Fqdn(r.Target) }
+
+func (r *PTR) pack(p *packer) { p.name(r.Target, true) }
+
+func (r *PTR) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Target = u.name()
+	return u.done()
+}
+
+// MX is a mail exchange for the owner name (RFC 1035 §3.3.9).
+type MX struct {
+	Preference uint16
+	Exchange   string
+}
+
+func (r *MX) String() string {
+	return fmt.Sprintf("%d %s", r.Preference, Fqdn(r.Exchange))
+}
+
+func (r *MX) pack(p *packer) {
+	p.uint16(r.Preference)
+	p.name(r.Exchange, true)
+}
+
+func (r *MX) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Preference = u.uint16()
+	r.Exchange = u.name()
+	return u.done()
+}
+
+// TXT holds one or more text strings (RFC 1035 §3.3.14).
+// Strings longer than 255 bytes are split across several character-strings.
+type TXT struct {
+	Text []string
+}
+
+func (r *TXT) String() string {
+	quoted := make([]string, len(r.Text))
+	for i, s := range r.Text {
+		quoted[i] = quoteString(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (r *TXT) pack(p *packer) {
+	for _, s := range r.Text {
+		for len(s) > 255 {
+			p.characterString(s[:255])
+			s = s[255:]
+		}
+		p.characterString(s)
+	}
+}
+
+func (r *TXT) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Text = nil
+	for u.err == nil && u.off < u.end {
+		r.Text = append(r.Text, u.characterString())
+	}
+	return u.done()
+}
+
+// SOA marks the start of a zone of authority (RFC 1035 §3.3.13).
+type SOA struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+func (r *SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", Fqdn(r.MName), Fqdn(r.RName),
+		r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum)
+}
+
+func (r *SOA) pack(p *packer) {
+	p.name(r.MName, true)
+	p.name(r.RName, true)
+	p.uint32(r.Serial)
+	p.uint32(r.Refresh)
+	p.uint32(r.Retry)
+	p.uint32(r.Expire)
+	p.uint32(r.Minimum)
+}
+
+func (r *SOA) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.MName = u.name()
+	r.RName = u.name()
+	r.Serial = u.uint32()
+	r.Refresh = u.uint32()
+	r.Retry = u.uint32()
+	r.Expire = u.uint32()
+	r.Minimum = u.uint32()
+	return u.done()
+}
+
+// SRV locates a service (RFC 2782). The target name is never compressed.
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+func (r *SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, Fqdn(r.Target))
+}
+
+func (r *SRV) pack(p *packer) {
+	p.uint16(r.Priority)
+	p.uint16(r.Weight)
+	p.uint16(r.Port)
+	p.name(r.Target, false)
+}
+
+func (r *SRV) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Priority = u.uint16()
+	r.Weight = u.uint16()
+	r.Port = u.uint16()
+	r.Target = u.name()
+	return u.done()
+}
+
+// CAA restricts which certificate authorities may issue for a name (RFC 8659).
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+func (r *CAA) String() string {
+	return fmt.Sprintf("%d %s %s", r.Flag, r.Tag, quoteString(r.Value))
+}
+
+func (r *CAA) pack(p *packer) {
+	p.uint8(r.Flag)
+	p.characterString(r.Tag)
+	p.bytes([]byte(r.Value))
+}
+
+func (r *CAA) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Flag = u.uint8()
+	r.Tag = u.characterString()
+	r.Value = string(u.rest())
+	return u.done()
+}
+
+// Unknown carries the RDATA of a type this package does not understand.
+// It is kept as opaque bytes so it round-trips untouched (RFC 3597).
+type Unknown struct {
+	Data []byte
+}
+
+func (r *Unknown) String() string {
+	if len(r.Data) == 0 {
+		return `\# 0`
+	}
+	return fmt.Sprintf(`\# %d %s`, len(r.Data), hex.EncodeToString(r.Data))
+}
+
+func (r *Unknown) pack(p *packer) { p.bytes(r.Data) }
+
+func (r *Unknown) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Data = u.rest()
+	return u.done()
+}
+
+// quoteString renders s as a quoted presentation-format string, escaping
+// quotes, backslashes and non-printable bytes.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
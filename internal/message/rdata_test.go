@@ -0,0 +1,135 @@
+package message
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackAddresses(t *testing.T) {
+	tests := []struct {
+		name  string
+		rdata RData
+		want  []byte
+	}{
+		{"A", &A{Addr: netip.MustParseAddr("192.0.2.1")}, []byte{192, 0, 2, 1}},
+		{"A mapped", &A{Addr: netip.MustParseAddr("::ffff:192.0.2.1")}, []byte{192, 0, 2, 1}},
+		{"A zero", &A{}, make([]byte, 4)},
+		{"A IPv6", &A{Addr: netip.MustParseAddr("2001:db8::1")}, make([]byte, 4)},
+		{"AAAA", &AAAA{Addr: netip.MustParseAddr("2001:db8::1")}, netip.MustParseAddr("2001:db8::1").AsSlice()},
+		{"AAAA IPv4", &AAAA{Addr: netip.MustParseAddr("192.0.2.1")}, netip.MustParseAddr("::ffff:192.0.2.1").AsSlice()},
+		{"AAAA zero", &AAAA{}, make([]byte, 16)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PackRData(tt.rdata); !bytes.Equal(got, tt.want) {
+				t.Errorf("PackRData = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRData(t *testing.T) {
+	tests := []struct {
+		name  string
+		rtype uint16
+		rdata RData
+		ok    bool
+	}{
+		{"A", TypeA, &A{Addr: netip.MustParseAddr("192.0.2.1")}, true},
+		{"A without RDATA", TypeA, nil, false},
+		{"A with IPv6", TypeA, &A{Addr: netip.MustParseAddr("2001:db8::1")}, false},
+		{"A zero", TypeA, &A{}, false},
+		{"AAAA", TypeAAAA, &AAAA{Addr: netip.MustParseAddr("2001:db8::1")}, true},
+		{"AAAA zero", TypeAAAA, &AAAA{}, false},
+		{"CNAME without RDATA", TypeCNAME, nil, false},
+		{"CNAME with NS RDATA", TypeCNAME, &NS{Host: "example.com"}, false},
+		{"SOA", TypeSOA, &SOA{MName: "ns1.example.com"}, true},
+		{"unknown type", 65280, &Unknown{Data: []byte{1}}, true},
+		{"unknown type without RDATA", 65280, nil, true},
+		{"unknown type with A RDATA", 65280, &A{Addr: netip.MustParseAddr("192.0.2.1")}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckRData(tt.rtype, tt.rdata); (err == nil) != tt.ok {
+			t.Errorf("%s: CheckRData = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRDataRoundTrip(t *testing.T) {
+	tests := []struct {
+		rtype uint16
+		rdata RData
+		text  string
+	}{
+		{TypeA, &A{Addr: netip.MustParseAddr("192.0.2.1")}, "192.0.2.1"},
+		{TypeAAAA, &AAAA{Addr: netip.MustParseAddr("2001:db8::1")}, "2001:db8::1"},
+		{TypeCNAME, &CNAME{Target: "www.example.com"}, "www.example.com."},
+		{TypeNS, &NS{Host: "ns1.example.com"}, "ns1.example.com."},
+		{TypePTR, &PTR{Target: "host.example.com"}, "host.example.com."},
+		{TypeMX, &MX{Preference: 10, Exchange: "mail.example.com"}, "10 mail.example.com."},
+		{TypeTXT, &TXT{Text: []string{"v=spf1 -all", `say "hi"`}}, `"v=spf1 -all" "say \"hi\""`},
+		{TypeSOA, &SOA{MName: "ns1.example.com", RName: "hostmaster.example.com", Serial: 2024010101, Refresh: 3600, Retry: 900, Expire: 604800, Minimum: 300},
+			"ns1.example.com. hostmaster.example.com. 2024010101 3600 900 604800 300"},
+		{TypeSRV, &SRV{Priority: 10, Weight: 5, Port: 5060, Target: "sip.example.com"}, "10 5 5060 sip.example.com."},
+		{TypeCAA, &CAA{Flag: 128, Tag: "issue", Value: "ca.example.net"}, `128 issue "ca.example.net"`},
+		{65280, &Unknown{Data: []byte{0xde, 0xad}}, `\# 2 dead`},
+		{65280, &Unknown{}, `\# 0`},
+	}
+	for _, tt := range tests {
+		t.Run(TypeString(tt.rtype), func(t *testing.T) {
+			got, err := DecodeRData(tt.rtype, PackRData(tt.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.rdata) {
+				t.Errorf("DecodeRData = %#v, want %#v", got, tt.rdata)
+			}
+			if s := got.String(); s != tt.text {
+				t.Errorf("String = %q, want %q", s, tt.text)
+			}
+		})
+	}
+}
+
+func TestRDataLongTXT(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	data := PackRData(&TXT{Text: []string{long}})
+	if len(data) != 302 || data[0] != 255 || data[256] != 45 {
+		t.Fatalf("300-byte string packed as %d bytes, want two character-strings of 255 and 45", len(data))
+	}
+	got, err := DecodeRData(TypeTXT, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt := got.(*TXT); strings.Join(txt.Text, "") != long {
+		t.Errorf("decoded %d strings that do not rejoin to the original", len(txt.Text))
+	}
+}
+
+func TestDecodeRDataMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		rtype uint16
+		data  []byte
+	}{
+		{"A too short", TypeA, []byte{192, 0, 2}},
+		{"A too long", TypeA, []byte{192, 0, 2, 1, 0}},
+		{"AAAA too short", TypeAAAA, make([]byte, 15)},
+		{"MX without exchange", TypeMX, []byte{0, 10}},
+		{"CNAME with trailing data", TypeCNAME, []byte{0, 1}},
+		{"TXT string past the end", TypeTXT, []byte{5, 'a', 'b'}},
+		{"SOA without timers", TypeSOA, []byte{0, 0, 0, 0, 0, 1}},
+		{"SRV without target", TypeSRV, []byte{0, 1, 0, 2, 0, 3}},
+		{"CAA tag past the end", TypeCAA, []byte{0, 5, 'i'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeRData(tt.rtype, tt.data); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
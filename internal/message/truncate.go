@@ -0,0 +1,99 @@
+package message
+
+import "strings"
+
+// Truncate drops whole RRsets from the end of the message until its encoded
+// form fits in size bytes. RRSIG records are dropped together with the
+// RRset they cover, so no RRset is left without its signatures.
+//
+// Additional-section RRsets are dropped first and, as RFC 2181 §9 allows,
+// without setting TC since the client can do without them. If the message
+// still does not fit, authority and then answer RRsets are dropped and TC is
+// set so the client knows to retry over TCP. The OPT record is never dropped.
+// Truncate reports whether any records were removed.
+func (m *Message) Truncate(size int) bool {
+	truncated := false
+	for {
+		var records [3][]int
+		excess := m.size(&records) - size
+		if excess <= 0 {
+			return truncated
+		}
+
+		// Names are only compressed against earlier records, so dropping
+		// trailing RRsets frees exactly their measured size. Only an RRset
+		// interleaved with records that are kept can free less, when they
+		// lose the name they were compressed against; the next pass
+		// measures again and drops more.
+		freed := dropRRsets(&m.Additional, records[2], excess, func(a Answer) bool { return a.Type == TypeOPT })
+		if freed < excess {
+			m.Header.TC = 1
+			freed += dropRRsets(&m.Authority, records[1], excess-freed, nil)
+		}
+		if freed < excess {
+			freed += dropRRsets(&m.Answers, records[0], excess-freed, nil)
+		}
+		if freed == 0 {
+			return truncated
+		}
+		truncated = true
+	}
+}
+
+// dropRRsets removes RRsets from the end of *section, whose records encode
+// to sizes bytes each, until at least excess bytes are freed or only
+// records matched by keep are left. It returns the number of bytes freed.
+func dropRRsets(section *[]Answer, sizes []int, excess int, keep func(Answer) bool) int {
+	sets := groupRRsets(*section)
+	drop := make([]bool, len(*section))
+	freed := 0
+	for i := len(sets) - 1; i >= 0 && freed < excess; i-- {
+		if keep != nil && keep((*section)[sets[i][0]]) {
+			continue
+		}
+		for _, idx := range sets[i] {
+			drop[idx] = true
+			freed += sizes[idx]
+		}
+	}
+	if freed == 0 {
+		return 0
+	}
+
+	remaining := make([]Answer, 0, len(*section))
+	for i, a := range *section {
+		if !drop[i] {
+			remaining = append(remaining, a)
+		}
+	}
+	*section = remaining
+	return freed
+}
+
+// groupRRsets groups the indexes of records sharing owner name, type and
+// class, ordered by the first appearance of each RRset. RRSIG records join
+// the RRset they cover.
+func groupRRsets(records []Answer) [][]int {
+	var sets [][]int
+	index := make(map[string]int)
+	for i, a := range records {
+		key := rrsetKey(a)
+		if pos, ok := index[key]; ok {
+			sets[pos] = append(sets[pos], i)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []int{i})
+	}
+	return sets
+}
+
+// rrsetKey identifies the RRset a record belongs to, or for an RRSIG the
+// RRset it covers. Owner names compare case-insensitively.
+func rrsetKey(a Answer) string {
+	rtype := a.Type
+	if sig, ok := a.RData.(*RRSIG); ok && a.Type == TypeRRSIG {
+		rtype = sig.TypeCovered
+	}
+	return strings.ToLower(a.Name) + "/" + TypeString(rtype) + "/" + ClassString(a.Class)
+}
@@ -0,0 +1,107 @@
+package message
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	a := func(name, addr string) Answer {
+		return Answer{Name: name, Type: TypeA, Class: ClassINET, TTL: 300, RData: &A{Addr: netip.MustParseAddr(addr)}}
+	}
+	aaaa := func(name, addr string) Answer {
+		return Answer{Name: name, Type: TypeAAAA, Class: ClassINET, TTL: 300, RData: &AAAA{Addr: netip.MustParseAddr(addr)}}
+	}
+	ns := func(name, host string) Answer {
+		return Answer{Name: name, Type: TypeNS, Class: ClassINET, TTL: 300, RData: &NS{Host: host}}
+	}
+	sig := func(name string, covered uint16) Answer {
+		return Answer{Name: name, Type: TypeRRSIG, Class: ClassINET, TTL: 300, RData: &RRSIG{
+			TypeCovered: covered, Algorithm: 13, Labels: 3, OriginalTTL: 300, SignerName: "example.com", Signature: make([]byte, 64),
+		}}
+	}
+	opt := func() Answer {
+		var m Message
+		m.SetEDNS(EDNS{UDPSize: 1232})
+		return m.Additional[0]
+	}()
+	message := func(answers, authority, additional []Answer) Message {
+		return Message{
+			Header:     Header{ID: 1, QR: 1},
+			Questions:  []Question{{Name: "www.example.com", Type: TypeA, Class: ClassINET}},
+			Answers:    answers,
+			Authority:  authority,
+			Additional: additional,
+		}
+	}
+	size := func(m Message) int { return len(m.Encode()) }
+
+	// The signature of the A RRset comes after the AAAA RRset
+	answers := []Answer{
+		a("www.example.com", "192.0.2.1"),
+		aaaa("www.example.com", "2001:db8::1"),
+		sig("www.example.com", TypeA),
+		sig("www.example.com", TypeAAAA),
+	}
+	authority := []Answer{ns("example.com", "ns1.example.com")}
+	additional := []Answer{a("ns1.example.com", "192.0.2.53"), aaaa("ns1.example.com", "2001:db8::53"), opt}
+	full := message(answers, authority, additional)
+
+	signedA := []Answer{answers[0], answers[2]}
+	withoutGlue := message(answers, authority, []Answer{opt})
+	onlyA := message(signedA, nil, []Answer{opt})
+
+	// An RRset split around another whose name the later half compresses
+	// against: dropping the one between makes the rest grow
+	split := []Answer{
+		ns("a.test", "ns.a.test"),
+		a("b.example.org", "192.0.2.2"),
+		ns("a.test", "ns.example.org"),
+	}
+	splitMessage := message(split, nil, nil)
+	grown := message([]Answer{split[0], split[2]}, nil, nil)
+
+	tests := []struct {
+		name      string
+		in        Message
+		size      int
+		want      Message
+		tc        bool
+		truncated bool
+	}{
+		{"fits", full, size(full), full, false, false},
+		{"glue dropped without TC", full, size(full) - 1, message(answers, authority, []Answer{additional[0], opt}), false, true},
+		{"OPT kept", full, size(withoutGlue), withoutGlue, false, true},
+		{"authority dropped", full, size(withoutGlue) - 1, message(answers, nil, []Answer{opt}), true, true},
+		{"RRSIG dropped with its RRset", full, size(onlyA), onlyA, true, true},
+		{"nothing fits", full, 12, message(nil, nil, []Answer{opt}), true, true},
+		{"split RRset measured again", splitMessage, size(grown) - 1, message(nil, nil, nil), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.in
+			if got := m.Truncate(tt.size); got != tt.truncated {
+				t.Errorf("Truncate = %v, want %v", got, tt.truncated)
+			}
+			if (m.Header.TC == 1) != tt.tc {
+				t.Errorf("TC = %d, want %v", m.Header.TC, tt.tc)
+			}
+			for _, section := range []struct {
+				name      string
+				got, want []Answer
+			}{
+				{"answer", m.Answers, tt.want.Answers},
+				{"authority", m.Authority, tt.want.Authority},
+				{"additional", m.Additional, tt.want.Additional},
+			} {
+				if len(section.got) != len(section.want) || (len(section.got) > 0 && !reflect.DeepEqual(section.got, section.want)) {
+					t.Errorf("%s section = %v, want %v", section.name, section.got, section.want)
+				}
+			}
+			if n := size(m); n > tt.size && len(m.Answers)+len(m.Authority) > 0 {
+				t.Errorf("truncated message is %d bytes, over %d", n, tt.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package message
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG algorithm names (RFC 8945 §6).
+const (
+	HmacSHA256 = "hmac-sha256"
+	HmacSHA384 = "hmac-sha384"
+	HmacSHA512 = "hmac-sha512"
+)
+
+// TSIGFudge is the clock skew, in seconds, allowed between the signer and
+// the verifier of a message.
+const TSIGFudge = 300
+
+// TSIG is a transaction signature (RFC 8945 §4.2). It is only ever the
+// last record of a message's additional section, never zone data.
+type TSIG struct {
+	Algorithm  string
+	TimeSigned uint64 // seconds since the Unix epoch, 48 bits on the wire
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	OtherData  []byte
+}
+
+func (r *TSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %d %s %d %s", Fqdn(r.Algorithm), r.TimeSigned, r.Fudge,
+		len(r.MAC), base64.StdEncoding.EncodeToString(r.MAC), r.OriginalID, tsigErrorString(int(r.Error)),
+		len(r.OtherData), base64.StdEncoding.EncodeToString(r.OtherData))
+}
+
+func (r *TSIG) pack(p *packer) {
+	p.name(r.Algorithm, false)
+	p.uint16(uint16(r.TimeSigned >> 32))
+	p.uint32(uint32(r.TimeSigned))
+	p.uint16(r.Fudge)
+	p.uint16(uint16(len(r.MAC)))
+	p.bytes(r.MAC)
+	p.uint16(r.OriginalID)
+	p.uint16(r.Error)
+	p.uint16(uint16(len(r.OtherData)))
+	p.bytes(r.OtherData)
+}
+
+func (r *TSIG) unpack(data []byte, offset, end int) error {
+	u := unpacker{data: data, off: offset, end: end}
+	r.Algorithm = u.name()
+	r.TimeSigned = uint64(u.uint16())<<32 | uint64(u.uint32())
+	r.Fudge = u.uint16()
+	r.MAC = u.bytes(int(u.uint16()))
+	r.OriginalID = u.uint16()
+	r.Error = u.uint16()
+	r.OtherData = u.bytes(int(u.uint16()))
+	return u.done()
+}
+
+// tsigErrorString is RCodeString for the TSIG error field, where 16 means
+// BADSIG rather than BADVERS.
+func tsigErrorString(code int) string {
+	if code == RCodeBadSig {
+		return "BADSIG"
+	}
+	return RCodeString(code)
+}
+
+// TSIGKey is a secret shared with another server for signing messages.
+type TSIGKey struct {
+	// Name identifies the key; both sides must use the same name
+	Name string
+	// Algorithm is one of HmacSHA256, HmacSHA384 and HmacSHA512
+	Algorithm string
+	Secret    []byte
+}
+
+// ParseTSIGKey parses a key given as [algorithm:]name:secret, the form
+// dig's -y option takes, with the secret in base64. The algorithm defaults
+// to HmacSHA256.
+func ParseTSIGKey(s string) (TSIGKey, error) {
+	parts := strings.Split(s, ":")
+	key := TSIGKey{Algorithm: HmacSHA256}
+	switch len(parts) {
+	case 2:
+		key.Name = parts[0]
+	case 3:
+		key.Algorithm, key.Name = strings.ToLower(parts[0]), parts[1]
+	default:
+		return TSIGKey{}, errors.New("want [algorithm:]name:secret")
+	}
+	key.Name = strings.TrimSuffix(key.Name, ".")
+	if key.Name == "" {
+		return TSIGKey{}, errors.New("empty key name")
+	}
+	if key.hash() == nil {
+		return TSIGKey{}, fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+	}
+	secret, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return TSIGKey{}, fmt.Errorf("invalid secret: %w", err)
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// hash returns the hash function of the key's algorithm, or nil if it is
+// not supported.
+func (k TSIGKey) hash() func() hash.Hash {
+	switch strings.ToLower(strings.TrimSuffix(k.Algorithm, ".")) {
+	case HmacSHA256:
+		return sha256.New
+	case HmacSHA384:
+		return sha512.New384
+	case HmacSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// TSIGError reports a message that failed TSIG verification. Code is the
+// TSIG error: RCodeBadSig, RCodeBadKey or RCodeBadTime, or
+// RCodeFormatError for a malformed TSIG record.
+type TSIGError struct {
+	Code   int
+	Reason string
+}
+
+func (e *TSIGError) Error() string {
+	return fmt.Sprintf("TSIG %s: %s", tsigErrorString(e.Code), e.Reason)
+}
+
+// TSIGSigner signs and verifies the messages of one transaction with a
+// key. Each message's MAC covers the MAC before it, so a signer must see
+// the messages of a transaction in order: a client signs its request and
+// then verifies each response message; a server verifies the request with
+// VerifyTSIG and signs each response message. Later messages of a
+// multi-message response, such as a zone transfer, are signed with only
+// the timers (RFC 8945 §5.3.1).
+type TSIGSigner struct {
+	key TSIGKey
+
+	// requests is set on client signers, whose every Sign starts a new
+	// transaction
+	requests bool
+
+	mac    []byte // MAC of the previous message, which the next one covers
+	signed int    // response messages signed or verified so far
+
+	// Error responses to bad requests (RFC 8945 §5.2)
+	tsigError  uint16
+	otherData  []byte
+	timeSigned uint64 // the request's time, echoed in BADTIME responses
+	unsigned   bool   // BADKEY and BADSIG responses carry an empty MAC
+}
+
+// NewTSIGSigner returns a signer for requests made with key.
+func NewTSIGSigner(key TSIGKey) *TSIGSigner {
+	return &TSIGSigner{key: key, requests: true}
+}
+
+// KeyName returns the name of the signer's key.
+func (s *TSIGSigner) KeyName() string { return s.key.Name }
+
+// SetTSIG makes Encode sign the message with s. Signing happens as the
+// message is encoded, after any truncation or padding, so each call to
+// Encode signs the message anew and advances s to the next message.
+func (m *Message) SetTSIG(s *TSIGSigner) {
+	m.tsig = s
+}
+
+// TSIG returns the signer set with SetTSIG, if any.
+func (m *Message) TSIG() *TSIGSigner {
+	return m.tsig
+}
+
+// VerifyTSIG checks the TSIG record at the end of the request in data,
+// using key to look keys up by name. It returns nil, nil if the request is
+// not signed. Otherwise it returns a signer for the response: one that
+// signs with the request's key if verification succeeded, or one that
+// produces the TSIG error response RFC 8945 §5.2 calls for, together with
+// a *TSIGError, if it failed.
+func VerifyTSIG(data []byte, key func(name string) (TSIGKey, bool)) (*TSIGSigner, error) {
+	offset, rr, err := findTSIG(data)
+	if err != nil {
+		return nil, &TSIGError{Code: RCodeFormatError, Reason: err.Error()}
+	}
+	if offset < 0 {
+		return nil, nil
+	}
+	t := rr.RData.(*TSIG)
+
+	k, ok := key(rr.Name)
+	if !ok || !EqualNames(k.Algorithm, t.Algorithm) || k.hash() == nil {
+		s := &TSIGSigner{key: TSIGKey{Name: rr.Name, Algorithm: t.Algorithm}, tsigError: RCodeBadKey, unsigned: true}
+		return s, &TSIGError{Code: RCodeBadKey, Reason: fmt.Sprintf("unknown key %s", Fqdn(rr.Name))}
+	}
+	s := &TSIGSigner{key: k}
+	if !hmac.Equal(s.digest(nil, unsigned(data, offset, t.OriginalID), t, true), t.MAC) {
+		s.tsigError, s.unsigned = RCodeBadSig, true
+		return s, &TSIGError{Code: RCodeBadSig, Reason: fmt.Sprintf("MAC does not match key %s", Fqdn(k.Name))}
+	}
+	s.mac = t.MAC
+	if now := uint64(time.Now().Unix()); !withinFudge(t, now) {
+		s.tsigError, s.timeSigned = RCodeBadTime, t.TimeSigned
+		s.otherData = []byte{byte(now >> 40), byte(now >> 32), byte(now >> 24), byte(now >> 16), byte(now >> 8), byte(now)}
+		return s, &TSIGError{Code: RCodeBadTime, Reason: fmt.Sprintf("signed at %d, now %d", t.TimeSigned, now)}
+	}
+	return s, nil
+}
+
+// Verify checks the TSIG record of the next response message in data.
+func (s *TSIGSigner) Verify(data []byte) error {
+	offset, rr, err := findTSIG(data)
+	if err != nil {
+		return &TSIGError{Code: RCodeFormatError, Reason: err.Error()}
+	}
+	if offset < 0 {
+		return &TSIGError{Code: RCodeBadSig, Reason: "response is not signed"}
+	}
+	t := rr.RData.(*TSIG)
+	if !EqualNames(rr.Name, s.key.Name) || !EqualNames(t.Algorithm, s.key.Algorithm) {
+		return &TSIGError{Code: RCodeBadKey, Reason: fmt.Sprintf("response signed with key %s", Fqdn(rr.Name))}
+	}
+	if t.Error != 0 {
+		return &TSIGError{Code: int(t.Error), Reason: "rejected by server"}
+	}
+	if !hmac.Equal(s.digest(s.mac, unsigned(data, offset, t.OriginalID), t, s.signed == 0), t.MAC) {
+		return &TSIGError{Code: RCodeBadSig, Reason: "MAC does not match"}
+	}
+	if now := uint64(time.Now().Unix()); !withinFudge(t, now) {
+		return &TSIGError{Code: RCodeBadTime, Reason: fmt.Sprintf("signed at %d, now %d", t.TimeSigned, now)}
+	}
+	s.mac = t.MAC
+	s.signed++
+	return nil
+}
+
+// sign appends a TSIG record to the encoded message in data.
+func (s *TSIGSigner) sign(data []byte) []byte {
+	t := &TSIG{
+		Algorithm:  s.key.Algorithm,
+		TimeSigned: uint64(time.Now().Unix()),
+		Fudge:      TSIGFudge,
+		OriginalID: binary.BigEndian.Uint16(data),
+		Error:      s.tsigError,
+		OtherData:  s.otherData,
+	}
+	if s.timeSigned != 0 {
+		t.TimeSigned = s.timeSigned
+	}
+	if !s.unsigned {
+		if s.requests {
+			t.MAC = s.digest(nil, data, t, true)
+			s.mac, s.signed = t.MAC, 0
+		} else {
+			t.MAC = s.digest(s.mac, data, t, s.signed == 0)
+			s.mac = t.MAC
+			s.signed++
+		}
+	}
+
+	signed := append(data[:len(data):len(data)], s.record(t)...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed
+}
+
+// size returns how many bytes sign adds to a message.
+func (s *TSIGSigner) size() int {
+	t := &TSIG{Algorithm: s.key.Algorithm, OtherData: s.otherData}
+	if !s.unsigned {
+		t.MAC = make([]byte, s.key.hash()().Size())
+	}
+	return len(s.record(t))
+}
+
+func (s *TSIGSigner) record(t *TSIG) []byte {
+	var p packer
+	Answer{Name: s.key.Name, Type: TypeTSIG, Class: ClassANY, RData: t}.pack(&p)
+	return p.buf
+}
+
+// digest computes the MAC of a message (RFC 8945 §4.3). prior is the MAC
+// of the request or previous message, if any. The full set of TSIG
+// variables is covered unless the message continues a multi-message
+// response, which covers only the timers.
+func (s *TSIGSigner) digest(prior, msg []byte, t *TSIG, full bool) []byte {
+	h := hmac.New(s.key.hash(), s.key.Secret)
+	if len(prior) > 0 {
+		h.Write([]byte{byte(len(prior) >> 8), byte(len(prior))})
+		h.Write(prior)
+	}
+	h.Write(msg)
+
+	var p packer
+	if full {
+		p.bytes(encodeDomainName(strings.ToLower(s.key.Name)))
+		p.uint16(ClassANY)
+		p.uint32(0)
+		p.bytes(encodeDomainName(strings.ToLower(t.Algorithm)))
+	}
+	p.uint16(uint16(t.TimeSigned >> 32))
+	p.uint32(uint32(t.TimeSigned))
+	p.uint16(t.Fudge)
+	if full {
+		p.uint16(t.Error)
+		p.uint16(uint16(len(t.OtherData)))
+		p.bytes(t.OtherData)
+	}
+	h.Write(p.buf)
+	return h.Sum(nil)
+}
+
+func withinFudge(t *TSIG, now uint64) bool {
+	return now+uint64(t.Fudge) >= t.TimeSigned && now <= t.TimeSigned+uint64(t.Fudge)
+}
+
+// unsigned returns the message in data as it was before its TSIG record
+// at offset was added: without the record, with one fewer additional
+// record and with its original ID.
+func unsigned(data []byte, offset int, originalID uint16) []byte {
+	msg := append([]byte(nil), data[:offset]...)
+	binary.BigEndian.PutUint16(msg, originalID)
+	binary.BigEndian.PutUint16(msg[10:], binary.BigEndian.Uint16(msg[10:])-1)
+	return msg
+}
+
+// findTSIG returns the offset and contents of the TSIG record in data, or
+// an offset of -1 if there is none. A TSIG record anywhere but at the end
+// of the additional section is an error.
+func findTSIG(data []byte) (int, Answer, error) {
+	header, err := ParseHeader(data)
+	if err != nil {
+		return 0, Answer{}, err
+	}
+	offset := HeaderSize
+	for i := uint16(0); i < header.QDCount; i++ {
+		_, n, err := ParseQuestion(data, offset)
+		if err != nil {
+			return 0, Answer{}, err
+		}
+		offset += n
+	}
+
+	total := int(header.ANCount) + int(header.NSCount) + int(header.ARCount)
+	for i := 0; i < total; i++ {
+		rr, n, err := ParseAnswer(data, offset)
+		if err != nil {
+			return 0, Answer{}, err
+		}
+		if rr.Type == TypeTSIG {
+			if i != total-1 || header.ARCount == 0 {
+				return 0, Answer{}, errors.New("TSIG record is not the last record")
+			}
+			if _, ok := rr.RData.(*TSIG); !ok {
+				return 0, Answer{}, errors.New("empty TSIG record")
+			}
+			return offset, rr, nil
+		}
+		offset += n
+	}
+	return -1, Answer{}, nil
+}
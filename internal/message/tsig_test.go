@@ -0,0 +1,238 @@
+package message
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTSIGKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TSIGKey
+		wantErr bool
+	}{
+		{"xfr.example.:c2VjcmV0", TSIGKey{Name: "xfr.example", Algorithm: HmacSHA256, Secret: []byte("secret")}, false},
+		{"HMAC-SHA512:xfr:c2VjcmV0", TSIGKey{Name: "xfr", Algorithm: HmacSHA512, Secret: []byte("secret")}, false},
+		{"hmac-md5:xfr:c2VjcmV0", TSIGKey{}, true},
+		{"xfr:not base64", TSIGKey{}, true},
+		{".:c2VjcmV0", TSIGKey{}, true},
+		{"c2VjcmV0", TSIGKey{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTSIGKey(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got.Name != tt.want.Name || got.Algorithm != tt.want.Algorithm || string(got.Secret) != string(tt.want.Secret) {
+				t.Errorf("ParseTSIGKey = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func tsigQuery() Message {
+	return Message{
+		Header:    Header{ID: 0x1234},
+		Questions: []Question{{Name: "example.com", Type: TypeAXFR, Class: ClassINET}},
+	}
+}
+
+// tsigRecord returns the TSIG record at the end of the message in data.
+func tsigRecord(t *testing.T, data []byte) (Answer, *TSIG) {
+	t.Helper()
+	msg, err := ParseMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := msg.Additional[len(msg.Additional)-1]
+	tsig, ok := rr.RData.(*TSIG)
+	if !ok {
+		t.Fatalf("last additional record is %s, not TSIG", TypeString(rr.Type))
+	}
+	return rr, tsig
+}
+
+func TestTSIGRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{HmacSHA256, HmacSHA384, HmacSHA512} {
+		t.Run(algorithm, func(t *testing.T) {
+			key := TSIGKey{Name: "xfr.example", Algorithm: algorithm, Secret: []byte("0123456789abcdef")}
+			keys := func(name string) (TSIGKey, bool) { return key, EqualNames(name, key.Name) }
+
+			client := NewTSIGSigner(key)
+			query := tsigQuery()
+			query.SetTSIG(client)
+			request := query.Encode()
+			rr, tsig := tsigRecord(t, request)
+			if rr.Name != key.Name || rr.Class != ClassANY || tsig.OriginalID != 0x1234 || tsig.Fudge != TSIGFudge {
+				t.Errorf("request TSIG = %v %+v", rr, tsig)
+			}
+
+			server, err := VerifyTSIG(request, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A multi-message response: only the first covers all the
+			// TSIG variables, and each covers the MAC before it
+			for i := 0; i < 3; i++ {
+				response := Message{Header: Header{ID: 0x1234, QR: 1}}
+				if i == 0 {
+					response.Questions = query.Questions
+				}
+				response.SetTSIG(server)
+				if err := client.Verify(response.Encode()); err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTSIGVerifyResponse(t *testing.T) {
+	key := TSIGKey{Name: "xfr.example", Algorithm: HmacSHA256, Secret: []byte("0123456789abcdef")}
+	other := TSIGKey{Name: "xfr.example", Algorithm: HmacSHA256, Secret: []byte("fedcba9876543210")}
+
+	// respond signs a request with key, has it verified with serverKey and
+	// returns the client's signer and the server's next n responses
+	respond := func(t *testing.T, serverKey TSIGKey, n int) (*TSIGSigner, [][]byte) {
+		client := NewTSIGSigner(key)
+		query := tsigQuery()
+		query.SetTSIG(client)
+		server, _ := VerifyTSIG(query.Encode(), func(string) (TSIGKey, bool) { return serverKey, true })
+		var responses [][]byte
+		for i := 0; i < n; i++ {
+			response := Message{Header: Header{ID: 0x1234, QR: 1}}
+			response.SetTSIG(server)
+			responses = append(responses, response.Encode())
+		}
+		return client, responses
+	}
+
+	tests := []struct {
+		name      string
+		serverKey TSIGKey
+		mangle    func(responses [][]byte) [][]byte
+		code      int
+	}{
+		{"signed", key, nil, RCodeSuccess},
+		{"modified", key, func(r [][]byte) [][]byte { r[0][3] ^= 0x80; return r }, RCodeBadSig},
+		{"message skipped", key, func(r [][]byte) [][]byte { return r[1:] }, RCodeBadSig},
+		{"wrong secret", other, nil, RCodeBadSig},
+		{"unsigned", key, func(r [][]byte) [][]byte {
+			r[0] = append([]byte(nil), r[0][:HeaderSize]...)
+			r[0][11] = 0
+			return r
+		}, RCodeBadSig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, responses := respond(t, tt.serverKey, 2)
+			if tt.mangle != nil {
+				responses = tt.mangle(responses)
+			}
+			var err error
+			for _, response := range responses {
+				if err = client.Verify(response); err != nil {
+					break
+				}
+			}
+			var tsigErr *TSIGError
+			switch {
+			case tt.code == RCodeSuccess && err != nil:
+				t.Errorf("Verify: %v", err)
+			case tt.code != RCodeSuccess && (!errors.As(err, &tsigErr) || tsigErr.Code != tt.code):
+				t.Errorf("Verify = %v, want %s", err, tsigErrorString(tt.code))
+			}
+		})
+	}
+}
+
+func TestVerifyTSIG(t *testing.T) {
+	key := TSIGKey{Name: "xfr.example", Algorithm: HmacSHA256, Secret: []byte("0123456789abcdef")}
+	keys := func(name string) (TSIGKey, bool) { return key, EqualNames(name, key.Name) }
+
+	// signed signs the query with k, at the given time if it is not zero
+	signed := func(k TSIGKey, at uint64) []byte {
+		query := tsigQuery()
+		if at == 0 {
+			query.SetTSIG(NewTSIGSigner(k))
+			return query.Encode()
+		}
+		data := query.Encode()
+		s := NewTSIGSigner(k)
+		tsig := &TSIG{Algorithm: k.Algorithm, TimeSigned: at, Fudge: TSIGFudge, OriginalID: query.Header.ID}
+		tsig.MAC = s.digest(nil, data, tsig, true)
+		data = append(data, s.record(tsig)...)
+		data[11]++
+		return data
+	}
+	unsignedQuery := tsigQuery()
+	now := uint64(time.Now().Unix())
+	withRecordAfter := func() []byte {
+		data := signed(key, 0)
+		a := Answer{Name: "example.com", Type: TypeA, Class: ClassINET, RData: &A{}}
+		var p packer
+		a.pack(&p)
+		data = append(data, p.buf...)
+		data[11]++
+		return data
+	}()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		code     int  // TSIG error VerifyTSIG reports, if any
+		response bool // whether a signer for the error response is returned
+	}{
+		{"unsigned", unsignedQuery.Encode(), RCodeSuccess, false},
+		{"signed", signed(key, 0), RCodeSuccess, true},
+		{"within the fudge", signed(key, now-TSIGFudge+30), RCodeSuccess, true},
+		{"unknown key", signed(TSIGKey{Name: "other", Algorithm: HmacSHA256, Secret: key.Secret}, 0), RCodeBadKey, true},
+		{"wrong algorithm", signed(TSIGKey{Name: key.Name, Algorithm: HmacSHA512, Secret: key.Secret}, 0), RCodeBadKey, true},
+		{"wrong secret", signed(TSIGKey{Name: key.Name, Algorithm: HmacSHA256, Secret: []byte("x")}, 0), RCodeBadSig, true},
+		{"too old", signed(key, now-TSIGFudge-60), RCodeBadTime, true},
+		{"too new", signed(key, now+TSIGFudge+60), RCodeBadTime, true},
+		{"not the last record", withRecordAfter, RCodeFormatError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := VerifyTSIG(tt.data, keys)
+			if (s != nil) != tt.response {
+				t.Errorf("signer = %v, want one %v", s, tt.response)
+			}
+			if tt.code == RCodeSuccess {
+				if err != nil {
+					t.Errorf("VerifyTSIG: %v", err)
+				}
+				return
+			}
+			var tsigErr *TSIGError
+			if !errors.As(err, &tsigErr) || tsigErr.Code != tt.code {
+				t.Fatalf("VerifyTSIG = %v, want %s", err, tsigErrorString(tt.code))
+			}
+			if s == nil {
+				return
+			}
+
+			// The error response carries the TSIG error, with an empty MAC
+			// unless only the time was wrong (RFC 8945 §5.2)
+			response := Message{Header: Header{ID: 0x1234, QR: 1, RCode: RCodeNotAuth}}
+			response.SetTSIG(s)
+			_, tsig := tsigRecord(t, response.Encode())
+			if int(tsig.Error) != tt.code {
+				t.Errorf("response TSIG error = %s, want %s", tsigErrorString(int(tsig.Error)), tsigErrorString(tt.code))
+			}
+			if (len(tsig.MAC) > 0) != (tt.code == RCodeBadTime) {
+				t.Errorf("response MAC is %d bytes", len(tsig.MAC))
+			}
+			if tt.code == RCodeBadTime {
+				_, request := tsigRecord(t, tt.data)
+				if tsig.TimeSigned != request.TimeSigned || len(tsig.OtherData) != 6 {
+					t.Errorf("BADTIME response signed at %d with other data %x, want the request's time %d and the server's",
+						tsig.TimeSigned, tsig.OtherData, request.TimeSigned)
+				}
+			}
+		})
+	}
+}
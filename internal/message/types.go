@@ -0,0 +1,175 @@
+package message
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resource record types
+const (
+	TypeA          uint16 = 1
+	TypeNS         uint16 = 2
+	TypeCNAME      uint16 = 5
+	TypeSOA        uint16 = 6
+	TypePTR        uint16 = 12
+	TypeMX         uint16 = 15
+	TypeTXT        uint16 = 16
+	TypeAAAA       uint16 = 28
+	TypeSRV        uint16 = 33
+	TypeOPT        uint16 = 41
+	TypeDS         uint16 = 43
+	TypeRRSIG      uint16 = 46
+	TypeNSEC       uint16 = 47
+	TypeDNSKEY     uint16 = 48
+	TypeNSEC3      uint16 = 50
+	TypeNSEC3PARAM uint16 = 51
+	TypeTSIG       uint16 = 250
+	TypeIXFR       uint16 = 251
+	TypeAXFR       uint16 = 252
+	TypeANY        uint16 = 255
+	TypeCAA        uint16 = 257
+)
+
+// Resource record classes
+const (
+	ClassINET   uint16 = 1
+	ClassCHAOS  uint16 = 3
+	ClassHESIOD uint16 = 4
+	ClassNONE   uint16 = 254 // RFC 2136
+	ClassANY    uint16 = 255
+)
+
+// Response codes. Values above 15 need the extended bits of an OPT record.
+const (
+	RCodeSuccess        = 0
+	RCodeFormatError    = 1
+	RCodeServerFailure  = 2
+	RCodeNameError      = 3
+	RCodeNotImplemented = 4
+	RCodeRefused        = 5
+	RCodeYXDomain       = 6
+	RCodeYXRRSet        = 7
+	RCodeNXRRSet        = 8
+	RCodeNotAuth        = 9
+	RCodeNotZone        = 10
+	RCodeBadVersion     = 16
+
+	// TSIG errors (RFC 8945 §3), carried in the TSIG record rather than
+	// the header. BADSIG shares its value with BADVERS.
+	RCodeBadSig   = 16
+	RCodeBadKey   = 17
+	RCodeBadTime  = 18
+	RCodeBadTrunc = 22
+)
+
+var typeNames = map[uint16]string{
+	TypeA:          "A",
+	TypeNS:         "NS",
+	TypeCNAME:      "CNAME",
+	TypeSOA:        "SOA",
+	TypePTR:        "PTR",
+	TypeMX:         "MX",
+	TypeTXT:        "TXT",
+	TypeAAAA:       "AAAA",
+	TypeSRV:        "SRV",
+	TypeOPT:        "OPT",
+	TypeDS:         "DS",
+	TypeRRSIG:      "RRSIG",
+	TypeNSEC:       "NSEC",
+	TypeDNSKEY:     "DNSKEY",
+	TypeNSEC3:      "NSEC3",
+	TypeNSEC3PARAM: "NSEC3PARAM",
+	TypeTSIG:       "TSIG",
+	TypeIXFR:       "IXFR",
+	TypeAXFR:       "AXFR",
+	TypeANY:        "ANY",
+	TypeCAA:        "CAA",
+}
+
+var rcodeNames = map[int]string{
+	RCodeSuccess:        "NOERROR",
+	RCodeFormatError:    "FORMERR",
+	RCodeServerFailure:  "SERVFAIL",
+	RCodeNameError:      "NXDOMAIN",
+	RCodeNotImplemented: "NOTIMP",
+	RCodeRefused:        "REFUSED",
+	RCodeYXDomain:       "YXDOMAIN",
+	RCodeYXRRSet:        "YXRRSET",
+	RCodeNXRRSet:        "NXRRSET",
+	RCodeNotAuth:        "NOTAUTH",
+	RCodeNotZone:        "NOTZONE",
+	RCodeBadVersion:     "BADVERS",
+	RCodeBadKey:         "BADKEY",
+	RCodeBadTime:        "BADTIME",
+	RCodeBadTrunc:       "BADTRUNC",
+}
+
+var classNames = map[uint16]string{
+	ClassINET:   "IN",
+	ClassCHAOS:  "CH",
+	ClassHESIOD: "HS",
+	ClassNONE:   "NONE",
+	ClassANY:    "ANY",
+}
+
+// TypeString returns the mnemonic for a record type, or the RFC 3597
+// TYPEnnn form for types without one.
+func TypeString(t uint16) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// ClassString returns the mnemonic for a record class, or the RFC 3597
+// CLASSnnn form for classes without one.
+func ClassString(c uint16) string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CLASS%d", c)
+}
+
+// RCodeString returns the mnemonic for a response code, or RCODEnnn for
+// codes without one.
+func RCodeString(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// ParseClass converts a class mnemonic such as "IN", or an RFC 3597
+// "CLASSnnn" string, to its numeric value.
+func ParseClass(s string) (uint16, error) {
+	upper := strings.ToUpper(s)
+	for c, name := range classNames {
+		if name == upper {
+			return c, nil
+		}
+	}
+	if digits, ok := strings.CutPrefix(upper, "CLASS"); ok {
+		if c, err := strconv.ParseUint(digits, 10, 16); err == nil {
+			return uint16(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown class %q", s)
+}
+
+// ParseType converts a record type mnemonic such as "AAAA", an RFC 3597
+// "TYPEnnn" string, or a decimal number to its numeric value.
+func ParseType(s string) (uint16, error) {
+	upper := strings.ToUpper(s)
+	for t, name := range typeNames {
+		if name == upper {
+			return t, nil
+		}
+	}
+	digits := strings.TrimPrefix(upper, "TYPE")
+	t, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown record type %q", s)
+	}
+	return uint16(t), nil
+}
@@ -0,0 +1,579 @@
+package resolver
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// DefaultCacheSize is the number of RRsets a cache holds by default.
+	DefaultCacheSize = 10000
+
+	// maxCacheTTL caps how long any RRset is cached, whatever its TTL.
+	maxCacheTTL = 24 * 60 * 60
+
+	// maxNegativeTTL caps negative caching at the three hours
+	// recommended by RFC 2308 §5.
+	maxNegativeTTL = 3 * 60 * 60
+
+	// DefaultMaxStale is how long expired data is kept for serve-stale, at
+	// the low end of the one to three days suggested by RFC 8767 §5.
+	DefaultMaxStale = 24 * time.Hour
+
+	// staleAnswerTTL is the TTL given to stale records (RFC 8767 §4).
+	staleAnswerTTL = 30
+
+	// staleRetryInterval is how long to wait between background attempts
+	// to refresh stale data, during which clients get stale answers
+	// without waiting on the failing upstream (RFC 8767 §4).
+	staleRetryInterval = 30 * time.Second
+
+	// refreshTimeout bounds each background refresh attempt.
+	refreshTimeout = 10 * time.Second
+
+	// typeNXDOMAIN keys a cached NXDOMAIN, which covers every type at a
+	// name (RFC 2308 §5).
+	typeNXDOMAIN uint16 = 0
+)
+
+// Handler answers wire-format queries. It has the same shape as the
+// server's MessageHandler, so resolvers can be stacked and served directly.
+type Handler interface {
+	Handle(ctx context.Context, data []byte) (message.Message, error)
+}
+
+// Cache answers queries from previously seen responses and passes misses
+// to the next handler. It stores RRsets from answer sections, keyed by
+// name, type and class, and follows cached CNAMEs on lookup. NXDOMAIN and
+// NODATA responses are cached for the SOA minimum (RFC 2308). The least
+// recently used RRsets are evicted once the cache is full.
+//
+// DNSSEC records are always requested and cached along with the data they
+// cover, so clients that set DO can be answered from the cache too, and
+// whether the next handler validated each RRset is remembered for the AD
+// bit. Responses to queries with CD set are not cached, as they may hold
+// data that failed validation.
+//
+// With serve-stale enabled (RFC 8767), expired data is kept for a while
+// longer and returned when the next handler fails, and the cache keeps
+// trying to refresh it in the background until Shutdown is called.
+type Cache struct {
+	next     Handler
+	capacity int
+	maxStale time.Duration
+	log      *gotracer.Logger
+	now      func() time.Time
+
+	// refreshCtx is the parent of every background refresh, cancelled by
+	// Shutdown; refreshes tracks the goroutines running them
+	refreshCtx    context.Context
+	stopRefreshes context.CancelFunc
+	refreshes     sync.WaitGroup
+
+	mu         sync.Mutex
+	entries    map[cacheKey]*list.Element
+	lru        *list.List // front is most recently used
+	refreshing map[cacheKey]bool
+	stopped    bool
+}
+
+// CacheOption configures a Cache.
+type CacheOption func(*Cache)
+
+// WithServeStale keeps expired data for up to maxAge past its expiry and
+// answers with it when the next handler fails. Zero disables serve-stale.
+func WithServeStale(maxAge time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.maxStale = maxAge
+	}
+}
+
+type cacheKey struct {
+	name  string // lowercased
+	qtype uint16
+	class uint16
+}
+
+// cacheEntry is a cached RRset, or a negative answer when rcode is
+// NXDOMAIN or records is empty.
+type cacheEntry struct {
+	key     cacheKey
+	records []message.Answer
+	sigs    []message.Answer // RRSIGs over records
+
+	// authority holds the SOA and NSEC or NSEC3 records proving a
+	// negative answer, or the proof an answer synthesized from a wildcard
+	// needs
+	authority []message.Answer
+
+	// authenticated is set if the next handler validated the data
+	authenticated bool
+
+	rcode  int
+	stored time.Time
+	ttl    uint32
+}
+
+// NewCache creates a cache holding up to capacity RRsets in front of next.
+func NewCache(next Handler, capacity int, log *gotracer.Logger, opts ...CacheOption) *Cache {
+	c := &Cache{
+		next:       next,
+		capacity:   capacity,
+		log:        log,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+		refreshing: make(map[cacheKey]bool),
+	}
+	c.refreshCtx, c.stopRefreshes = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Shutdown cancels background refreshes of stale data, and stops new ones
+// from starting, then waits for them to return until ctx is done.
+func (c *Cache) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+	c.stopRefreshes()
+
+	done := make(chan struct{})
+	go func() {
+		c.refreshes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handle answers the query in data from the cache if it can, and otherwise
+// passes it on and caches the response. If the next handler fails and
+// stale data is available, the stale data is returned instead.
+func (c *Cache) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	query, err := message.ParseMessage(data)
+	if err != nil || len(query.Questions) != 1 || query.Header.Opcode != message.StandardQuery {
+		return c.next.Handle(ctx, data)
+	}
+
+	q := query.Questions[0]
+	if response, ok := c.lookup(query, q, false); ok {
+		c.log.Debugf("Cache hit", map[string]interface{}{
+			"name": q.Name,
+			"type": message.TypeString(q.Type),
+		})
+		return clientResponse(query, response), nil
+	}
+
+	// While a refresh is pending the upstream is known to be failing, so
+	// answer from stale data straight away
+	if c.isRefreshing(q) {
+		if response, ok := c.lookup(query, q, true); ok {
+			return clientResponse(query, staleResponse(response)), nil
+		}
+	}
+
+	upstream := query
+	upstream.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: true})
+	data = upstream.Encode()
+	checkingDisabled := query.Header.Z&message.ZCheckingDisabled != 0
+
+	response, err := c.next.Handle(ctx, data)
+	if err == nil && response.RCode() != message.RCodeServerFailure {
+		if !checkingDisabled {
+			c.store(q, response)
+		}
+		return clientResponse(query, response), nil
+	}
+
+	if stale, ok := c.lookup(query, q, true); ok {
+		c.log.Debugf("Serving stale answer", map[string]interface{}{
+			"name": q.Name,
+			"type": message.TypeString(q.Type),
+		})
+		if !checkingDisabled {
+			c.refresh(upstream, data)
+		}
+		return clientResponse(query, staleResponse(stale)), nil
+	}
+	if err == nil {
+		response = clientResponse(query, response)
+	}
+	return response, err
+}
+
+// Len returns the number of cached RRsets and negative answers.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// lookup builds a response to q from cached data, following CNAMEs. Only
+// complete answers are returned; a chain leading to an uncached name is a
+// miss. Expired entries are used only if stale is true. The response
+// carries all cached DNSSEC records, and the AD bit if every entry used
+// was validated.
+func (c *Cache) lookup(query message.Message, q message.Question, stale bool) (message.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	response := message.Message{
+		Header: message.Header{
+			ID:     query.Header.ID,
+			QR:     1,
+			Opcode: query.Header.Opcode,
+			RD:     query.Header.RD,
+			RA:     1,
+		},
+		Questions: query.Questions,
+	}
+
+	authenticated := true
+	done := func(e *cacheEntry) (message.Message, bool) {
+		if authenticated && e.authenticated {
+			response.Header.Z |= message.ZAuthenticData
+		}
+		return response, true
+	}
+
+	name := q.Name
+	for i := 0; i <= maxCNAMEChain; i++ {
+		if e, ok := c.get(cacheKey{strings.ToLower(name), typeNXDOMAIN, q.Class}, now, stale); ok {
+			response.Authority = e.age(e.authority, now)
+			response.SetRCode(message.RCodeNameError)
+			return done(e)
+		}
+		if e, ok := c.get(cacheKey{strings.ToLower(name), q.Type, q.Class}, now, stale); ok {
+			if len(e.records) == 0 {
+				response.Authority = e.age(e.authority, now)
+			} else {
+				response.Answers = append(response.Answers, e.age(e.records, now)...)
+				response.Answers = append(response.Answers, e.age(e.sigs, now)...)
+				response.Authority = append(response.Authority, e.age(e.authority, now)...)
+			}
+			return done(e)
+		}
+		if q.Type == message.TypeCNAME {
+			return message.Message{}, false
+		}
+		// A cached NODATA for the CNAME type says nothing about q.Type
+		e, ok := c.get(cacheKey{strings.ToLower(name), message.TypeCNAME, q.Class}, now, stale)
+		if !ok || len(e.records) == 0 {
+			return message.Message{}, false
+		}
+		cname, ok := e.records[0].RData.(*message.CNAME)
+		if !ok {
+			return message.Message{}, false
+		}
+		response.Answers = append(response.Answers, e.age(e.records, now)...)
+		response.Answers = append(response.Answers, e.age(e.sigs, now)...)
+		response.Authority = append(response.Authority, e.age(e.authority, now)...)
+		authenticated = authenticated && e.authenticated
+		name = cname.Target
+	}
+	return message.Message{}, false
+}
+
+// get returns the entry for key, marking it recently used. Expired entries
+// are returned only if stale is true, and are removed once they are too
+// old to serve. c.mu must be held.
+func (c *Cache) get(key cacheKey, now time.Time, stale bool) (*cacheEntry, bool) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	if e.expired(now) {
+		if now.Sub(e.stored) >= time.Duration(e.ttl)*time.Second+c.maxStale {
+			c.remove(elem)
+			return nil, false
+		}
+		if !stale {
+			return nil, false
+		}
+	}
+	c.lru.MoveToFront(elem)
+	return e, true
+}
+
+// store caches the RRsets in a response to q, and the negative answer at
+// the end of its CNAME chain if there is one.
+func (c *Cache) store(q message.Question, response message.Message) {
+	rcode := response.RCode()
+	if response.Header.TC == 1 || (rcode != message.RCodeSuccess && rcode != message.RCodeNameError) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	authenticated := response.Header.Z&message.ZAuthenticData != 0
+
+	chain, answered := answerChain(response.Answers, q)
+	for _, rrset := range splitRRsets(response.Answers) {
+		// Only cache records that are part of the answer to q, so a server
+		// cannot plant data for names outside the chain. Signatures are
+		// cached with the RRsets they cover
+		if rrset[0].Class != q.Class || !containsName(chain, rrset[0].Name) || (rrset[0].Type == message.TypeRRSIG && q.Type != message.TypeRRSIG) {
+			continue
+		}
+		ttl := minTTL(rrset)
+		if ttl == 0 {
+			continue
+		}
+		e := &cacheEntry{
+			key:           cacheKey{strings.ToLower(rrset[0].Name), rrset[0].Type, rrset[0].Class},
+			records:       rrset,
+			authenticated: authenticated,
+			rcode:         message.RCodeSuccess,
+			stored:        now,
+			ttl:           min(ttl, maxCacheTTL),
+		}
+		for _, rr := range response.Answers {
+			if sig, ok := rr.RData.(*message.RRSIG); ok && rr.Type == message.TypeRRSIG && sig.TypeCovered == rrset[0].Type && message.EqualNames(rr.Name, rrset[0].Name) {
+				e.sigs = append(e.sigs, rr)
+				// An answer synthesized from a wildcard needs its proof
+				if int(sig.Labels) < message.CountLabels(rr.Name) && len(e.authority) == 0 {
+					e.authority = proofRecords(response.Authority)
+					if len(e.authority) > 0 {
+						e.ttl = min(e.ttl, minTTL(e.authority))
+					}
+				}
+			}
+		}
+		c.put(e)
+	}
+
+	if answered {
+		return
+	}
+	soa, ttl, ok := negativeTTL(response.Authority)
+	if !ok {
+		return
+	}
+	key := cacheKey{strings.ToLower(chain[len(chain)-1]), q.Type, q.Class}
+	if rcode == message.RCodeNameError {
+		key.qtype = typeNXDOMAIN
+	}
+	c.put(&cacheEntry{
+		key:           key,
+		authority:     append(soa, proofRecords(response.Authority)...),
+		authenticated: authenticated,
+		rcode:         rcode,
+		stored:        now,
+		ttl:           min(ttl, maxNegativeTTL),
+	})
+}
+
+// put inserts or replaces an entry, evicting the least recently used
+// entries beyond capacity. c.mu must be held.
+func (c *Cache) put(e *cacheEntry) {
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return now.Sub(e.stored) >= time.Duration(e.ttl)*time.Second
+}
+
+// age returns copies of records with their TTLs reduced by the time spent
+// in the cache. Expired records get the short stale answer TTL.
+func (e *cacheEntry) age(records []message.Answer, now time.Time) []message.Answer {
+	ttl := uint32(staleAnswerTTL)
+	if !e.expired(now) {
+		ttl = e.ttl - uint32(now.Sub(e.stored)/time.Second)
+	}
+	out := make([]message.Answer, len(records))
+	for i, rr := range records {
+		rr.TTL = ttl
+		out[i] = rr
+	}
+	return out
+}
+
+func (c *Cache) isRefreshing(q message.Question) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshing[questionKey(q)]
+}
+
+// refresh retries query in the background until the next handler answers
+// it, no stale data is left to serve or the cache is shut down. Only one
+// refresh runs per question.
+func (c *Cache) refresh(query message.Message, data []byte) {
+	q := query.Questions[0]
+	key := questionKey(q)
+
+	// The refresh is registered under the lock Shutdown takes, so none
+	// starts once Shutdown is waiting for the rest
+	c.mu.Lock()
+	if c.stopped || c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.refreshes.Add(1)
+	c.mu.Unlock()
+
+	data = append([]byte(nil), data...)
+	go func() {
+		defer c.refreshes.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		for {
+			timer := time.NewTimer(staleRetryInterval)
+			select {
+			case <-timer.C:
+			case <-c.refreshCtx.Done():
+				timer.Stop()
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(c.refreshCtx, refreshTimeout)
+			response, err := c.next.Handle(ctx, data)
+			cancel()
+			if err == nil && response.RCode() != message.RCodeServerFailure {
+				c.store(q, response)
+				c.log.Debugf("Refreshed stale data", map[string]interface{}{
+					"name": q.Name,
+					"type": message.TypeString(q.Type),
+				})
+				return
+			}
+			if _, ok := c.lookup(query, q, true); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// clientResponse adapts a response built for the cache, which always asks
+// for DNSSEC records, to query: DNSSEC records are left out unless it set
+// DO, and the AD bit unless it set DO or AD (RFC 6840 §5.8).
+func clientResponse(query, response message.Message) message.Message {
+	edns, _ := query.EDNS()
+	if !edns.DO && query.Header.Z&message.ZAuthenticData == 0 {
+		response.Header.Z &^= message.ZAuthenticData
+	}
+	return withoutDNSSEC(response, query.Questions[0].Type, edns.DO)
+}
+
+// staleResponse marks a response built from stale data with the Extended
+// DNS Error for stale answers (RFC 8914 §4.4).
+func staleResponse(response message.Message) message.Message {
+	response.AddExtendedError(message.EDEStaleAnswer, "")
+	return response
+}
+
+func questionKey(q message.Question) cacheKey {
+	return cacheKey{strings.ToLower(q.Name), q.Type, q.Class}
+}
+
+// answerChain follows the CNAME chain for q through answers. It returns
+// the names in the chain, starting with the question name, and whether
+// records of the queried type exist at its end.
+func answerChain(answers []message.Answer, q message.Question) ([]string, bool) {
+	names := []string{q.Name}
+	for len(names) <= maxCNAMEChain {
+		name := names[len(names)-1]
+		var target string
+		for _, rr := range answers {
+			if !message.EqualNames(rr.Name, name) {
+				continue
+			}
+			if rr.Type == q.Type || q.Type == message.TypeANY {
+				return names, true
+			}
+			if c, ok := rr.RData.(*message.CNAME); ok && rr.Type == message.TypeCNAME {
+				target = c.Target
+			}
+		}
+		if target == "" || containsName(names, target) {
+			break
+		}
+		names = append(names, target)
+	}
+	return names, false
+}
+
+// negativeTTL finds the SOA record in a negative response's authority
+// section. Its TTL is the lesser of the record's TTL and its minimum field
+// (RFC 2308 §5). Responses without an SOA are not cached.
+func negativeTTL(authority []message.Answer) ([]message.Answer, uint32, bool) {
+	for _, rr := range authority {
+		if soa, ok := rr.RData.(*message.SOA); ok && rr.Type == message.TypeSOA {
+			ttl := min(rr.TTL, soa.Minimum)
+			rr.TTL = ttl
+			return []message.Answer{rr}, ttl, ttl > 0
+		}
+	}
+	return nil, 0, false
+}
+
+// proofRecords returns the NSEC and NSEC3 records in authority, and the
+// signatures over them and the SOA.
+func proofRecords(authority []message.Answer) []message.Answer {
+	var proof []message.Answer
+	for _, rr := range authority {
+		if isDNSSECType(rr.Type) {
+			proof = append(proof, rr)
+		}
+	}
+	return proof
+}
+
+// splitRRsets groups records by owner name, type and class, preserving
+// their order. The OPT pseudo-record is skipped.
+func splitRRsets(records []message.Answer) [][]message.Answer {
+	var sets [][]message.Answer
+	index := make(map[cacheKey]int)
+	for _, rr := range records {
+		if rr.Type == message.TypeOPT {
+			continue
+		}
+		key := cacheKey{strings.ToLower(rr.Name), rr.Type, rr.Class}
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []message.Answer{rr})
+	}
+	return sets
+}
+
+func minTTL(records []message.Answer) uint32 {
+	ttl := records[0].TTL
+	for _, rr := range records[1:] {
+		ttl = min(ttl, rr.TTL)
+	}
+	return ttl
+}
@@ -0,0 +1,242 @@
+package resolver
+
+import (
+	"context"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+// handlerFunc adapts a function to the Handler interface.
+type handlerFunc func(ctx context.Context, data []byte) (message.Message, error)
+
+func (f handlerFunc) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	return f(ctx, data)
+}
+
+func testLogger() *gotracer.Logger {
+	log := gotracer.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func testQuery(name string, qtype uint16) []byte {
+	query := message.Message{
+		Header:    message.Header{ID: 1, RD: 1},
+		Questions: []message.Question{{Name: name, Type: qtype, Class: message.ClassINET}},
+	}
+	return query.Encode()
+}
+
+func testSOA(zone string) message.Answer {
+	return message.Answer{Name: zone, Type: message.TypeSOA, Class: message.ClassINET, TTL: 300, RData: &message.SOA{
+		MName: "ns1." + zone, RName: "hostmaster." + zone, Serial: 1, Refresh: 3600, Retry: 900, Expire: 604800, Minimum: 300,
+	}}
+}
+
+func testA(name, addr string) message.Answer {
+	return message.Answer{Name: name, Type: message.TypeA, Class: message.ClassINET, TTL: 300, RData: &message.A{Addr: netip.MustParseAddr(addr)}}
+}
+
+// answering returns a handler that answers every query with respond, and
+// counts the queries it gets.
+func answering(calls *int, respond func(q message.Question) message.Message) Handler {
+	return handlerFunc(func(ctx context.Context, data []byte) (message.Message, error) {
+		*calls++
+		query, err := message.ParseMessage(data)
+		if err != nil {
+			return message.Message{}, err
+		}
+		response := respond(query.Questions[0])
+		response.Header.ID = query.Header.ID
+		response.Header.QR = 1
+		response.Questions = query.Questions
+		return response, nil
+	})
+}
+
+func TestCacheHit(t *testing.T) {
+	calls := 0
+	next := answering(&calls, func(q message.Question) message.Message {
+		return message.Message{Answers: []message.Answer{testA(q.Name, "192.0.2.1")}}
+	})
+	c := NewCache(next, 10, testLogger())
+
+	for i := 0; i < 3; i++ {
+		response, err := c.Handle(context.Background(), testQuery("www.example.com", message.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Answers) != 1 || response.Header.ID != 1 {
+			t.Fatalf("response %d = %+v", i, response)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("next handler called %d times, want 1", calls)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	calls := 0
+	next := answering(&calls, func(q message.Question) message.Message {
+		response := message.Message{Authority: []message.Answer{testSOA("example.com")}}
+		response.SetRCode(message.RCodeNameError)
+		return response
+	})
+	c := NewCache(next, 10, testLogger())
+
+	// An NXDOMAIN covers every type at the name
+	for _, qtype := range []uint16{message.TypeA, message.TypeAAAA} {
+		response, err := c.Handle(context.Background(), testQuery("nx.example.com", qtype))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.RCode() != message.RCodeNameError || len(response.Authority) != 1 {
+			t.Fatalf("%s: got %s with %d authority records", message.TypeString(qtype), message.RCodeString(response.RCode()), len(response.Authority))
+		}
+	}
+	if calls != 1 {
+		t.Errorf("next handler called %d times, want 1", calls)
+	}
+}
+
+func TestCacheFollowsCNAME(t *testing.T) {
+	calls := 0
+	next := answering(&calls, func(q message.Question) message.Message {
+		return message.Message{Answers: []message.Answer{
+			{Name: "alias.example.com", Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300, RData: &message.CNAME{Target: "www.example.com"}},
+			testA("www.example.com", "192.0.2.1"),
+		}}
+	})
+	c := NewCache(next, 10, testLogger())
+
+	for i := 0; i < 2; i++ {
+		response, err := c.Handle(context.Background(), testQuery("alias.example.com", message.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Answers) != 2 {
+			t.Fatalf("response %d has %d answers, want 2", i, len(response.Answers))
+		}
+	}
+	if calls != 1 {
+		t.Errorf("next handler called %d times, want 1", calls)
+	}
+}
+
+// A cached NODATA for a name's CNAME type, or a CNAME without RDATA, must
+// be a miss for other types rather than a CNAME to follow.
+func TestCacheUnusableCNAMEEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		cname message.Message
+	}{
+		{"NODATA", message.Message{Authority: []message.Answer{testSOA("example.com")}}},
+		{"empty RDATA", message.Message{Answers: []message.Answer{
+			{Name: "x.example.com", Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			next := answering(&calls, func(q message.Question) message.Message {
+				if q.Type == message.TypeCNAME {
+					return tt.cname
+				}
+				return message.Message{Answers: []message.Answer{testA(q.Name, "192.0.2.1")}}
+			})
+			c := NewCache(next, 10, testLogger())
+
+			if _, err := c.Handle(context.Background(), testQuery("x.example.com", message.TypeCNAME)); err != nil {
+				t.Fatal(err)
+			}
+			response, err := c.Handle(context.Background(), testQuery("x.example.com", message.TypeA))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Answers) != 1 || response.Answers[0].Type != message.TypeA {
+				t.Fatalf("answers = %v, want the A record", response.Answers)
+			}
+			if calls != 2 {
+				t.Errorf("next handler called %d times, want 2", calls)
+			}
+		})
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	calls := 0
+	next := answering(&calls, func(q message.Question) message.Message {
+		return message.Message{Answers: []message.Answer{testA(q.Name, "192.0.2.1")}}
+	})
+	c := NewCache(next, 2, testLogger())
+
+	for _, name := range []string{"a.example.com", "b.example.com", "a.example.com", "c.example.com", "a.example.com"} {
+		if _, err := c.Handle(context.Background(), testQuery(name, message.TypeA)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+	// b was least recently used when c was stored, so only a stayed cached
+	if calls != 3 {
+		t.Errorf("next handler called %d times, want 3", calls)
+	}
+}
+
+func TestCacheShutdownStopsRefreshes(t *testing.T) {
+	calls := 0
+	next := answering(&calls, func(q message.Question) message.Message {
+		var response message.Message
+		if calls == 1 {
+			response.Answers = []message.Answer{testA(q.Name, "192.0.2.1")}
+		} else {
+			response.SetRCode(message.RCodeServerFailure)
+		}
+		return response
+	})
+	c := NewCache(next, 10, testLogger(), WithServeStale(time.Hour))
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	q := message.Question{Name: "www.example.com", Type: message.TypeA, Class: message.ClassINET}
+
+	stale := func() {
+		t.Helper()
+		response, err := c.Handle(context.Background(), testQuery(q.Name, q.Type))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Answers) != 1 || len(response.ExtendedErrors()) != 1 {
+			t.Fatalf("response = %+v, want a stale answer", response)
+		}
+	}
+
+	if _, err := c.Handle(context.Background(), testQuery(q.Name, q.Type)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	stale()
+	if !c.isRefreshing(q) {
+		t.Fatal("no refresh started for stale data")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+	if c.isRefreshing(q) {
+		t.Error("refresh still registered after Shutdown")
+	}
+
+	// Stale data is still served, but no refresh starts
+	stale()
+	if c.isRefreshing(q) {
+		t.Error("refresh started after Shutdown")
+	}
+}
@@ -0,0 +1,189 @@
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+const (
+	// DefaultTimeout bounds a single exchange with an upstream server.
+	DefaultTimeout = 2 * time.Second
+
+	// ednsUDPSize is the UDP payload size advertised in queries, the 1232
+	// bytes recommended by DNS Flag Day 2020 to avoid fragmentation.
+	ednsUDPSize = 1232
+
+	// maxMessageSize is the largest DNS message any transport can carry.
+	maxMessageSize = 65535
+)
+
+// Client sends queries to other name servers over UDP, retrying over TCP
+// when a response comes back truncated. Responses to queries signed with
+// Message.SetTSIG must be signed with the same key.
+type Client struct {
+	// Timeout bounds each exchange; DefaultTimeout is used when zero
+	Timeout time.Duration
+}
+
+// Exchange sends query to the server at addr and returns its response.
+// The response must carry the query's ID and question.
+func (c *Client) Exchange(ctx context.Context, addr string, query message.Message) (message.Message, error) {
+	response, err := c.exchangeUDP(ctx, addr, query)
+	if err != nil {
+		return message.Message{}, err
+	}
+	if response.Header.TC == 1 {
+		return c.ExchangeTCP(ctx, addr, query)
+	}
+	return response, nil
+}
+
+// ExchangeTCP sends query to the server at addr over TCP and returns the
+// first response.
+func (c *Client) ExchangeTCP(ctx context.Context, addr string, query message.Message) (message.Message, error) {
+	conn, err := c.DialTCP(ctx, addr)
+	if err != nil {
+		return message.Message{}, err
+	}
+	defer conn.Close()
+
+	if err := WriteMessage(conn, query.Encode()); err != nil {
+		return message.Message{}, fmt.Errorf("failed to send query to %s: %w", addr, err)
+	}
+	for {
+		data, err := ReadMessage(conn)
+		if err != nil {
+			return message.Message{}, fmt.Errorf("failed to read response from %s: %w", addr, err)
+		}
+		response, err := message.ParseMessage(data)
+		if err != nil {
+			return message.Message{}, fmt.Errorf("failed to parse response from %s: %w", addr, err)
+		}
+		if matches(query, response) {
+			return response, verify(query, data)
+		}
+	}
+}
+
+// DialTCP opens a TCP connection to addr whose deadline is the earlier of
+// ctx's deadline and the client timeout.
+func (c *Client) DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(c.deadline(ctx))
+	return conn, nil
+}
+
+func (c *Client) exchangeUDP(ctx context.Context, addr string, query message.Message) (message.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return message.Message{}, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(c.deadline(ctx))
+
+	// Unblock the read if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(query.Encode()); err != nil {
+		return message.Message{}, fmt.Errorf("failed to send query to %s: %w", addr, err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return message.Message{}, ctx.Err()
+			}
+			return message.Message{}, fmt.Errorf("failed to read response from %s: %w", addr, err)
+		}
+		response, err := message.ParseMessage(buf[:n])
+		if err != nil {
+			// A truncated response may be cut mid-record; its header is enough
+			if header, herr := message.ParseHeader(buf[:n]); herr == nil && header.TC == 1 && header.ID == query.Header.ID {
+				return message.Message{Header: header, Questions: query.Questions}, nil
+			}
+			return message.Message{}, fmt.Errorf("failed to parse response from %s: %w", addr, err)
+		}
+		// Ignore stray datagrams, which may be spoofing attempts
+		if matches(query, response) {
+			return response, verify(query, buf[:n])
+		}
+	}
+}
+
+func (c *Client) deadline(ctx context.Context) time.Time {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// matches reports whether response answers query: same ID, the QR bit set
+// and, when both carry one, the same question.
+func matches(query, response message.Message) bool {
+	if response.Header.ID != query.Header.ID || response.Header.QR != 1 {
+		return false
+	}
+	if len(query.Questions) == 0 || len(response.Questions) == 0 {
+		return true
+	}
+	q, r := query.Questions[0], response.Questions[0]
+	return q.Type == r.Type && q.Class == r.Class && message.EqualNames(q.Name, r.Name)
+}
+
+// verify checks the TSIG record of a response if query was signed.
+func verify(query message.Message, data []byte) error {
+	if signer := query.TSIG(); signer != nil {
+		return signer.Verify(data)
+	}
+	return nil
+}
+
+// ReadMessage reads one message framed with a two-byte length prefix
+// (RFC 1035 §4.2.2).
+func ReadMessage(r io.Reader) ([]byte, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint16(prefix[:])
+	if length == 0 {
+		return nil, errors.New("zero-length message")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteMessage writes one message framed with a two-byte length prefix.
+func WriteMessage(w io.Writer, data []byte) error {
+	if len(data) > maxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds TCP frame limit", len(data))
+	}
+	frame := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(frame, uint16(len(data)))
+	copy(frame[2:], data)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,240 @@
+package resolver
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// maxNSEC3Iterations is the most NSEC3 hash iterations a validator
+// should have to spend; zones that use more are treated as insecure
+// (RFC 9276 §3.2).
+const maxNSEC3Iterations = 150
+
+// denial is what the NSEC or NSEC3 records of a response prove about a
+// name and type.
+type denial struct {
+	// nxdomain is set if the name does not exist, and not set if it, or
+	// the wildcard that would have matched it, has no data of the type
+	nxdomain bool
+
+	// delegation is set if the name is an unsigned zone cut: it has NS
+	// records but neither DS nor SOA records
+	delegation bool
+
+	// insecure is set if the proof cannot be trusted to be complete: an
+	// NSEC3 opt-out span covers the name, which may hide an unsigned
+	// delegation (RFC 5155 §6), or the chain uses too many iterations
+	insecure bool
+}
+
+// proveDenial checks that records, the authority section of a negative
+// answer whose signatures have been verified, prove there are no records
+// of type qtype at name (RFC 4035 §5.4, RFC 5155 §8). It reports false if
+// they do not.
+func proveDenial(name string, qtype uint16, records []message.Answer) (denial, bool) {
+	var nsecs, nsec3s []message.Answer
+	for _, rr := range records {
+		switch rr.RData.(type) {
+		case *message.NSEC:
+			nsecs = append(nsecs, rr)
+		case *message.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+	if len(nsec3s) > 0 {
+		return proveNSEC3(name, qtype, nsec3s)
+	}
+	return proveNSEC(name, qtype, nsecs)
+}
+
+func proveNSEC(name string, qtype uint16, nsecs []message.Answer) (denial, bool) {
+	// The name exists but lacks the type
+	for _, rr := range nsecs {
+		if message.EqualNames(rr.Name, name) {
+			return typeDenial(rr.RData.(*message.NSEC).Types, qtype)
+		}
+	}
+
+	covering, ok := nsecCovering(nsecs, name)
+	if !ok {
+		return denial{}, false
+	}
+	// An empty non-terminal: something exists below the name
+	if next := covering.RData.(*message.NSEC).NextDomain; message.IsSubdomain(next, name) {
+		return denial{}, true
+	}
+
+	// The name does not exist, so the wildcard at its closest encloser
+	// must not exist either, or must lack the type
+	encloser := commonAncestor(covering.Name, name)
+	if next := commonAncestor(covering.RData.(*message.NSEC).NextDomain, name); len(next) > len(encloser) {
+		encloser = next
+	}
+	wildcard := message.WildcardName(encloser)
+	for _, rr := range nsecs {
+		if message.EqualNames(rr.Name, wildcard) {
+			return typeDenial(rr.RData.(*message.NSEC).Types, qtype)
+		}
+	}
+	if _, ok := nsecCovering(nsecs, wildcard); !ok {
+		return denial{}, false
+	}
+	return denial{nxdomain: true}, true
+}
+
+// nsecCovering returns the NSEC record whose span holds name strictly
+// between its owner and next name in canonical order. The last NSEC of a
+// zone wraps around to the apex.
+func nsecCovering(nsecs []message.Answer, name string) (message.Answer, bool) {
+	for _, rr := range nsecs {
+		next := rr.RData.(*message.NSEC).NextDomain
+		afterOwner := message.CompareNames(rr.Name, name) < 0
+		beforeNext := message.CompareNames(name, next) < 0
+		if message.CompareNames(rr.Name, next) < 0 {
+			if afterOwner && beforeNext {
+				return rr, true
+			}
+		} else if afterOwner || beforeNext {
+			return rr, true
+		}
+	}
+	return message.Answer{}, false
+}
+
+func proveNSEC3(name string, qtype uint16, nsec3s []message.Answer) (denial, bool) {
+	for _, rr := range nsec3s {
+		if nsec3 := rr.RData.(*message.NSEC3); nsec3.Iterations > maxNSEC3Iterations || nsec3.HashAlgorithm != message.NSEC3HashSHA1 {
+			return denial{insecure: true}, true
+		}
+	}
+
+	if rr, ok := nsec3Matching(nsec3s, name); ok {
+		return typeDenial(rr.RData.(*message.NSEC3).Types, qtype)
+	}
+
+	// Closest encloser proof (RFC 5155 §8.3): the nearest ancestor that
+	// exists, and a span covering the name just below it
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, encloser := range message.Ancestors(key) {
+		if _, ok := nsec3Matching(nsec3s, encloser); !ok {
+			continue
+		}
+		covering, ok := nsec3Covering(nsec3s, message.NextCloser(key, encloser))
+		if !ok {
+			return denial{}, false
+		}
+		if covering.RData.(*message.NSEC3).Flags&message.NSEC3FlagOptOut != 0 {
+			return denial{insecure: true}, true
+		}
+		if rr, ok := nsec3Matching(nsec3s, message.WildcardName(encloser)); ok {
+			return typeDenial(rr.RData.(*message.NSEC3).Types, qtype)
+		}
+		if _, ok := nsec3Covering(nsec3s, message.WildcardName(encloser)); !ok {
+			return denial{}, false
+		}
+		return denial{nxdomain: true}, true
+	}
+	return denial{}, false
+}
+
+// nsec3Matching returns the NSEC3 record owned by the hash of name.
+func nsec3Matching(nsec3s []message.Answer, name string) (message.Answer, bool) {
+	for _, rr := range nsec3s {
+		if owner, ok := nsec3Owner(rr); ok && bytes.Equal(owner, nsec3Hash(rr, name)) {
+			return rr, true
+		}
+	}
+	return message.Answer{}, false
+}
+
+// nsec3Covering returns the NSEC3 record whose span holds the hash of
+// name strictly between its owner's hash and the next one, wrapping
+// around at the end of the chain.
+func nsec3Covering(nsec3s []message.Answer, name string) (message.Answer, bool) {
+	for _, rr := range nsec3s {
+		owner, ok := nsec3Owner(rr)
+		if !ok {
+			continue
+		}
+		hash, next := nsec3Hash(rr, name), rr.RData.(*message.NSEC3).NextHashed
+		afterOwner := bytes.Compare(owner, hash) < 0
+		beforeNext := bytes.Compare(hash, next) < 0
+		if bytes.Compare(owner, next) < 0 {
+			if afterOwner && beforeNext {
+				return rr, true
+			}
+		} else if afterOwner || beforeNext {
+			return rr, true
+		}
+	}
+	return message.Answer{}, false
+}
+
+// nsec3Owner decodes the hash an NSEC3 record is owned by.
+func nsec3Owner(rr message.Answer) ([]byte, bool) {
+	label, _, _ := strings.Cut(rr.Name, ".")
+	hash, err := message.DecodeNSEC3Hash(label)
+	return hash, err == nil
+}
+
+// nsec3Hash hashes name with the parameters of rr.
+func nsec3Hash(rr message.Answer, name string) []byte {
+	nsec3 := rr.RData.(*message.NSEC3)
+	return message.HashName(name, nsec3.Iterations, nsec3.Salt)
+}
+
+// typeDenial checks the types listed at a name that exists: the type
+// asked for and a CNAME, which would have been followed, must be absent.
+// A DS denial must come from the parent side of a zone cut, where there
+// is no SOA.
+func typeDenial(types []uint16, qtype uint16) (denial, bool) {
+	has := make(map[uint16]bool, len(types))
+	for _, t := range types {
+		has[t] = true
+	}
+	if has[qtype] || has[message.TypeCNAME] || (qtype == message.TypeDS && has[message.TypeSOA]) {
+		return denial{}, false
+	}
+	return denial{delegation: has[message.TypeNS] && !has[message.TypeSOA]}, true
+}
+
+// proveExpansion checks that records prove name does not exist, as an
+// answer synthesized from a wildcard at the name's ancestor with the
+// given number of labels must (RFC 4035 §5.3.4, RFC 5155 §8.8).
+func proveExpansion(name string, labels int, records []message.Answer) bool {
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	parts := strings.Split(key, ".")
+	if labels >= len(parts) {
+		return false
+	}
+	encloser := strings.Join(parts[len(parts)-labels:], ".")
+
+	var nsecs, nsec3s []message.Answer
+	for _, rr := range records {
+		switch rr.RData.(type) {
+		case *message.NSEC:
+			nsecs = append(nsecs, rr)
+		case *message.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+	if len(nsec3s) > 0 {
+		_, ok := nsec3Covering(nsec3s, message.NextCloser(key, encloser))
+		return ok
+	}
+	_, ok := nsecCovering(nsecs, key)
+	return ok
+}
+
+// commonAncestor returns the longest name both a and b are at or below.
+func commonAncestor(a, b string) string {
+	la := strings.Split(strings.ToLower(strings.TrimSuffix(a, ".")), ".")
+	lb := strings.Split(strings.ToLower(strings.TrimSuffix(b, ".")), ".")
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] && la[len(la)-1-n] != "" {
+		n++
+	}
+	return strings.Join(la[len(la)-n:], ".")
+}
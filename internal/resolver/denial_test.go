@@ -0,0 +1,121 @@
+package resolver
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func testNSEC(owner, next string, types ...uint16) message.Answer {
+	return message.Answer{Name: owner, Type: message.TypeNSEC, Class: message.ClassINET, TTL: 300,
+		RData: &message.NSEC{NextDomain: next, Types: append(types, message.TypeRRSIG, message.TypeNSEC)}}
+}
+
+// testNSEC3Chain links the hashes of names, each with types, into a loop
+// of NSEC3 records in zone.
+func testNSEC3Chain(zone string, flags uint8, iterations uint16, names map[string][]uint16) []message.Answer {
+	type hashed struct {
+		hash  []byte
+		types []uint16
+	}
+	var chain []hashed
+	for name, types := range names {
+		chain = append(chain, hashed{message.HashName(name, iterations, nil), types})
+	}
+	sort.Slice(chain, func(i, j int) bool { return string(chain[i].hash) < string(chain[j].hash) })
+
+	var records []message.Answer
+	for i, h := range chain {
+		records = append(records, message.Answer{
+			Name: message.EncodeNSEC3Hash(h.hash) + "." + zone, Type: message.TypeNSEC3, Class: message.ClassINET, TTL: 300,
+			RData: &message.NSEC3{HashAlgorithm: message.NSEC3HashSHA1, Flags: flags, Iterations: iterations,
+				NextHashed: chain[(i+1)%len(chain)].hash, Types: h.types},
+		})
+	}
+	return records
+}
+
+func TestProveDenial(t *testing.T) {
+	// example.com holds a, b.c (below the empty non-terminal c), the
+	// unsigned delegation sub and the wildcard *.w
+	apex := testNSEC("example.com", "a.example.com", message.TypeSOA, message.TypeNS, message.TypeDNSKEY)
+	a := testNSEC("a.example.com", "b.c.example.com", message.TypeA)
+	bc := testNSEC("b.c.example.com", "sub.example.com", message.TypeA)
+	sub := testNSEC("sub.example.com", "*.w.example.com", message.TypeNS)
+	wild := testNSEC("*.w.example.com", "example.com", message.TypeTXT)
+
+	names := map[string][]uint16{
+		"example.com":   {message.TypeSOA, message.TypeNS, message.TypeDNSKEY, message.TypeRRSIG},
+		"a.example.com": {message.TypeA, message.TypeRRSIG},
+	}
+	nsec3 := testNSEC3Chain("example.com", 0, 0, names)
+	optOut := testNSEC3Chain("example.com", message.NSEC3FlagOptOut, 0, names)
+	expensive := testNSEC3Chain("example.com", 0, maxNSEC3Iterations+1, names)
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		records []message.Answer
+		ok      bool
+		want    denial
+	}{
+		{"NSEC no data", "a.example.com", message.TypeAAAA, []message.Answer{a}, true, denial{}},
+		{"NSEC type present", "a.example.com", message.TypeA, []message.Answer{a}, false, denial{}},
+		{"NSEC name error", "missing.example.com", message.TypeA, []message.Answer{bc, apex}, true, denial{nxdomain: true}},
+		{"NSEC wildcard not denied", "missing.example.com", message.TypeA, []message.Answer{bc}, false, denial{}},
+		{"NSEC name not covered", "missing.example.com", message.TypeA, []message.Answer{a, apex}, false, denial{}},
+		{"NSEC empty non-terminal", "c.example.com", message.TypeA, []message.Answer{a}, true, denial{}},
+		{"NSEC wildcard no data", "x.w.example.com", message.TypeAAAA, []message.Answer{wild}, true, denial{}},
+		{"NSEC wildcard has the type", "x.w.example.com", message.TypeTXT, []message.Answer{wild}, false, denial{}},
+		{"NSEC unsigned delegation", "sub.example.com", message.TypeDS, []message.Answer{sub}, true, denial{delegation: true}},
+		{"NSEC DS denied by the child", "example.com", message.TypeDS, []message.Answer{apex}, false, denial{}},
+		{"no records", "missing.example.com", message.TypeA, nil, false, denial{}},
+
+		{"NSEC3 no data", "a.example.com", message.TypeAAAA, nsec3, true, denial{}},
+		{"NSEC3 type present", "a.example.com", message.TypeA, nsec3, false, denial{}},
+		{"NSEC3 name error", "missing.example.com", message.TypeA, nsec3, true, denial{nxdomain: true}},
+		{"NSEC3 no closest encloser", "missing.example.net", message.TypeA, nsec3, false, denial{}},
+		{"NSEC3 opt-out", "missing.example.com", message.TypeA, optOut, true, denial{insecure: true}},
+		{"NSEC3 too many iterations", "missing.example.com", message.TypeA, expensive, true, denial{insecure: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := proveDenial(tt.qname, tt.qtype, tt.records)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("proveDenial = %+v, %v; want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestProveExpansion(t *testing.T) {
+	names := map[string][]uint16{
+		"example.com":     {message.TypeSOA, message.TypeNS, message.TypeRRSIG},
+		"w.example.com":   nil,
+		"*.w.example.com": {message.TypeA, message.TypeRRSIG},
+	}
+	nsec3 := testNSEC3Chain("example.com", 0, 0, names)
+
+	tests := []struct {
+		name    string
+		qname   string
+		labels  int
+		records []message.Answer
+		want    bool
+	}{
+		{"NSEC covers the name", "x.w.example.com", 3, []message.Answer{testNSEC("*.w.example.com", "example.com", message.TypeA)}, true},
+		{"NSEC matches the name", "x.w.example.com", 3, []message.Answer{testNSEC("x.w.example.com", "example.com", message.TypeA)}, false},
+		{"no proof", "x.w.example.com", 3, nil, false},
+		{"NSEC3 covers the next closer name", "x.w.example.com", 3, nsec3, true},
+		{"labels not below the owner", "x.w.example.com", 4, nsec3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := proveExpansion(tt.qname, tt.labels, tt.records); got != tt.want {
+				t.Errorf("proveExpansion = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
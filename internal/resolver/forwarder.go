@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+// Forwarder answers queries by relaying them to a single upstream resolver.
+type Forwarder struct {
+	upstream string
+	client   *Client
+	log      *gotracer.Logger
+}
+
+// NewForwarder creates a Forwarder that relays queries to the resolver at
+// upstream, given as ip:port.
+func NewForwarder(upstream string, log *gotracer.Logger) *Forwarder {
+	return &Forwarder{
+		upstream: upstream,
+		client:   &Client{},
+		log:      log,
+	}
+}
+
+// Handle relays the query in data upstream and returns the upstream
+// response unchanged. Timeouts and network errors are returned as errors
+// so the caller can decide how to answer the client.
+func (f *Forwarder) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	query, err := message.ParseMessage(data)
+	if err != nil {
+		return message.Message{}, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	f.log.Debugf("Forwarding query upstream", map[string]interface{}{
+		"upstream":  f.upstream,
+		"id":        query.Header.ID,
+		"questions": len(query.Questions),
+	})
+
+	response, err := f.client.Exchange(ctx, f.upstream, query)
+	if err != nil {
+		return message.Message{}, fmt.Errorf("upstream %s: %w", f.upstream, err)
+	}
+
+	f.log.Debugf("Received upstream response", map[string]interface{}{
+		"upstream": f.upstream,
+		"id":       response.Header.ID,
+		"rcode":    response.RCode(),
+		"answers":  len(response.Answers),
+	})
+	return response, nil
+}
@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// startUpstream serves a fake resolver on one loopback port over UDP and
+// TCP. Over UDP, big.example.com comes back truncated, spoof.example.com
+// is first answered under the wrong ID and silent.example.com not at all.
+func startUpstream(t *testing.T) string {
+	t.Helper()
+	var tcp net.Listener
+	var udp net.PacketConn
+	for {
+		var err error
+		if tcp, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if udp, err = net.ListenPacket("udp", tcp.Addr().String()); err == nil {
+			break
+		}
+		tcp.Close()
+	}
+	t.Cleanup(func() {
+		tcp.Close()
+		udp.Close()
+	})
+
+	answer := func(query message.Message) message.Message {
+		q := query.Questions[0]
+		return message.Message{
+			Header:    message.Header{ID: query.Header.ID, QR: 1, RD: query.Header.RD, RA: 1},
+			Questions: query.Questions,
+			Answers:   []message.Answer{testA(q.Name, "192.0.2.1")},
+		}
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := message.ParseMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			response := answer(query)
+			switch query.Questions[0].Name {
+			case "big.example.com":
+				response.Answers = nil
+				response.Header.TC = 1
+			case "spoof.example.com":
+				spoofed := response
+				spoofed.Header.ID++
+				spoofed.Answers = []message.Answer{testA("spoof.example.com", "203.0.113.66")}
+				udp.WriteTo(spoofed.Encode(), addr)
+			case "silent.example.com":
+				continue
+			}
+			udp.WriteTo(response.Encode(), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			data, err := ReadMessage(conn)
+			if err == nil {
+				if query, err := message.ParseMessage(data); err == nil {
+					response := answer(query)
+					WriteMessage(conn, response.Encode())
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return tcp.Addr().String()
+}
+
+func TestForwarder(t *testing.T) {
+	f := NewForwarder(startUpstream(t), testLogger())
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"www.example.com", true},
+		{"big.example.com", true},
+		{"spoof.example.com", true},
+		{"silent.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			response, err := f.Handle(ctx, testQuery(tt.name, message.TypeA))
+			if !tt.ok {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("error %v, want a timeout", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Header.ID != 1 || len(response.Answers) != 1 {
+				t.Fatalf("response = %+v", response)
+			}
+			if a := response.Answers[0].RData.(*message.A); a.Addr.String() != "192.0.2.1" {
+				t.Errorf("answer %s, want 192.0.2.1", a.Addr)
+			}
+		})
+	}
+
+	if _, err := f.Handle(context.Background(), []byte{1, 2, 3}); err == nil {
+		t.Error("malformed query: no error")
+	}
+}
@@ -0,0 +1,417 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// defaultQueryBudget caps the upstream queries spent on one client
+	// query, including glueless NS lookups and CNAME targets, so a
+	// malicious zone cannot turn one query into an amplification attack.
+	defaultQueryBudget = 64
+
+	// maxGluelessDepth limits how deeply NS name lookups may nest.
+	maxGluelessDepth = 4
+
+	// maxCNAMEChain limits the number of CNAMEs followed for one query.
+	maxCNAMEChain = 16
+
+	// maxReferrals limits the number of delegations followed for one name.
+	maxReferrals = 32
+)
+
+// RootHints are the IPv4 addresses of the root name servers a through m.
+var RootHints = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+var (
+	errBudgetExhausted = errors.New("query budget exhausted")
+	errCNAMELoop       = errors.New("CNAME loop detected")
+	errCNAMEChain      = errors.New("CNAME chain too long")
+	errNoServers       = errors.New("no reachable name servers")
+	errReferralLoop    = errors.New("too many referrals")
+	errGluelessDepth   = errors.New("glueless delegation too deep")
+)
+
+// Recursive is an iterative resolver. It starts at the root servers and
+// follows referrals down to a server authoritative for each name.
+type Recursive struct {
+	hints  []string
+	port   string
+	budget int
+	client *Client
+	log    *gotracer.Logger
+}
+
+// RecursiveOption configures a Recursive resolver.
+type RecursiveOption func(*Recursive)
+
+// WithRootHints replaces the built-in root server addresses.
+func WithRootHints(addrs ...string) RecursiveOption {
+	return func(r *Recursive) {
+		r.hints = addrs
+	}
+}
+
+// WithPort sets the port every name server is queried on, 53 by default.
+// Other ports are only useful for testing against local servers.
+func WithPort(port string) RecursiveOption {
+	return func(r *Recursive) {
+		r.port = port
+	}
+}
+
+// WithQueryBudget sets how many upstream queries one client query may cost.
+func WithQueryBudget(n int) RecursiveOption {
+	return func(r *Recursive) {
+		r.budget = n
+	}
+}
+
+// NewRecursive creates an iterative resolver using the built-in root hints.
+func NewRecursive(log *gotracer.Logger, opts ...RecursiveOption) *Recursive {
+	r := &Recursive{
+		hints:  RootHints,
+		port:   "53",
+		budget: defaultQueryBudget,
+		client: &Client{},
+		log:    log,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Handle resolves the first question in data. Resolution failures, such as
+// unreachable servers, CNAME loops or an exhausted query budget, are
+// answered with SERVFAIL rather than returned as errors.
+func (r *Recursive) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	query, err := message.ParseMessage(data)
+	if err != nil {
+		return message.Message{}, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	response := message.Message{
+		Header: message.Header{
+			ID:     query.Header.ID,
+			QR:     1,
+			Opcode: query.Header.Opcode,
+			RD:     query.Header.RD,
+			RA:     1,
+		},
+		Questions: query.Questions,
+	}
+	if len(query.Questions) == 0 {
+		response.SetRCode(message.RCodeFormatError)
+		return response, nil
+	}
+
+	q := query.Questions[0]
+	budget := r.budget
+	result, err := r.resolve(ctx, q, &budget, 0)
+	if err != nil {
+		r.log.Errorf("Recursive resolution failed", map[string]interface{}{
+			"error": err.Error(),
+			"name":  q.Name,
+			"type":  message.TypeString(q.Type),
+		})
+		response.SetRCode(message.RCodeServerFailure)
+		return response, nil
+	}
+
+	response.Answers = result.Answers
+	response.Authority = result.Authority
+	response.SetRCode(result.RCode())
+	edns, _ := query.EDNS()
+	return withoutDNSSEC(response, q.Type, edns.DO), nil
+}
+
+// resolve answers q, following CNAMEs to their targets. The returned
+// message holds the whole chain in its answer section and, for negative
+// answers, the final server's authority section.
+func (r *Recursive) resolve(ctx context.Context, q message.Question, budget *int, depth int) (message.Message, error) {
+	var chain []message.Answer
+	seen := make(map[string]bool)
+	name := q.Name
+
+	for {
+		key := strings.ToLower(name)
+		if seen[key] {
+			return message.Message{}, fmt.Errorf("%w at %s", errCNAMELoop, message.Fqdn(name))
+		}
+		if len(seen) > maxCNAMEChain {
+			return message.Message{}, errCNAMEChain
+		}
+		seen[key] = true
+
+		response, err := r.iterate(ctx, message.Question{Name: name, Type: q.Type, Class: q.Class}, budget, depth)
+		if err != nil {
+			return message.Message{}, err
+		}
+
+		// The server may have followed part of the chain itself
+		records, target := followChain(response.Answers, name, q.Type, seen)
+		chain = append(chain, records...)
+		if target == "" {
+			return message.Message{
+				Header:    response.Header,
+				Answers:   chain,
+				Authority: answerAuthority(response, records, q.Type),
+			}, nil
+		}
+
+		r.log.Debugf("Following CNAME", map[string]interface{}{
+			"from": name,
+			"to":   target,
+		})
+		name = target
+	}
+}
+
+// followChain collects the records in answers that answer name, and the
+// signatures over them, following CNAMEs within the section. It returns
+// the name still to be resolved, or "" when the answer is complete. Names
+// followed within the section are recorded in seen.
+func followChain(answers []message.Answer, name string, qtype uint16, seen map[string]bool) ([]message.Answer, string) {
+	var records []message.Answer
+	for {
+		var cname *message.CNAME
+		var sigs []message.Answer
+		found := false
+		for _, rr := range answers {
+			if !message.EqualNames(rr.Name, name) {
+				continue
+			}
+			if rr.Type == qtype || qtype == message.TypeANY {
+				records = append(records, rr)
+				found = true
+			} else if c, ok := rr.RData.(*message.CNAME); ok && rr.Type == message.TypeCNAME && cname == nil {
+				records = append(records, rr)
+				cname = c
+			} else if rr.Type == message.TypeRRSIG {
+				sigs = append(sigs, rr)
+			}
+		}
+		for _, rr := range sigs {
+			sig, ok := rr.RData.(*message.RRSIG)
+			if !ok {
+				continue
+			}
+			if (found && sig.TypeCovered == qtype) || (!found && cname != nil && sig.TypeCovered == message.TypeCNAME) {
+				records = append(records, rr)
+			}
+		}
+		if found || cname == nil {
+			return records, ""
+		}
+		// Continue within the section if the server included the target
+		name = cname.Target
+		if seen[strings.ToLower(name)] || !hasOwner(answers, name) {
+			return records, name
+		}
+		seen[strings.ToLower(name)] = true
+	}
+}
+
+func hasOwner(records []message.Answer, name string) bool {
+	for _, rr := range records {
+		if message.EqualNames(rr.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// answerAuthority keeps the authority section of negative answers, whose
+// SOA record clients use for negative caching. A CNAME chain ending in no
+// data is negative too. Positive answers keep only the NSEC or NSEC3
+// records that prove an answer synthesized from a wildcard.
+func answerAuthority(response message.Message, records []message.Answer, qtype uint16) []message.Answer {
+	if response.RCode() != message.RCodeSuccess {
+		return response.Authority
+	}
+	for _, rr := range records {
+		if rr.Type == qtype || qtype == message.TypeANY {
+			return proofRecords(response.Authority)
+		}
+	}
+	return response.Authority
+}
+
+// iterate walks down the delegation tree from the root until a server
+// answers q authoritatively, returns an answer, or denies the name.
+func (r *Recursive) iterate(ctx context.Context, q message.Question, budget *int, depth int) (message.Message, error) {
+	servers := r.hints
+	zone := ""
+
+	for i := 0; i < maxReferrals; i++ {
+		response, err := r.query(ctx, servers, q, budget)
+		if err != nil {
+			return message.Message{}, err
+		}
+		if response.RCode() != message.RCodeSuccess || len(response.Answers) > 0 || response.Header.AA == 1 {
+			return response, nil
+		}
+
+		child, hosts := referral(response, q.Name, zone)
+		if child == "" {
+			// Neither an answer nor a usable referral: treat as no data
+			return response, nil
+		}
+
+		r.log.Debugf("Following referral", map[string]interface{}{
+			"name": q.Name,
+			"zone": message.Fqdn(child),
+			"ns":   len(hosts),
+		})
+
+		addrs := glue(response.Additional, hosts, zone)
+		if len(addrs) == 0 {
+			if addrs, err = r.resolveHosts(ctx, hosts, q.Class, budget, depth); err != nil {
+				return message.Message{}, err
+			}
+		}
+		servers, zone = addrs, child
+	}
+	return message.Message{}, errReferralLoop
+}
+
+// query asks each server in turn until one gives a usable response.
+func (r *Recursive) query(ctx context.Context, servers []string, q message.Question, budget *int) (message.Message, error) {
+	err := errNoServers
+	for _, server := range servers {
+		if *budget <= 0 {
+			return message.Message{}, errBudgetExhausted
+		}
+		*budget--
+
+		query := message.Message{
+			Header:    message.Header{ID: message.RandomID()},
+			Questions: []message.Question{q},
+		}
+		// Always ask for DNSSEC records, so the answer can be validated
+		// whether or not this client wants them
+		query.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: true})
+
+		addr := net.JoinHostPort(server, r.port)
+		response, exchangeErr := r.client.Exchange(ctx, addr, query)
+		if exchangeErr != nil {
+			if ctx.Err() != nil {
+				return message.Message{}, ctx.Err()
+			}
+			err = exchangeErr
+			continue
+		}
+
+		switch response.RCode() {
+		case message.RCodeSuccess, message.RCodeNameError:
+			return response, nil
+		default:
+			err = fmt.Errorf("%s answered %s", addr, message.RCodeString(response.RCode()))
+		}
+	}
+	return message.Message{}, err
+}
+
+// resolveHosts looks up the addresses of name servers that came without
+// glue. It stops at the first host that resolves.
+func (r *Recursive) resolveHosts(ctx context.Context, hosts []string, class uint16, budget *int, depth int) ([]string, error) {
+	if depth >= maxGluelessDepth {
+		return nil, errGluelessDepth
+	}
+
+	err := errNoServers
+	for _, host := range hosts {
+		result, resolveErr := r.resolve(ctx, message.Question{Name: host, Type: message.TypeA, Class: class}, budget, depth+1)
+		if resolveErr != nil {
+			if errors.Is(resolveErr, errBudgetExhausted) || ctx.Err() != nil {
+				return nil, resolveErr
+			}
+			err = resolveErr
+			continue
+		}
+		var addrs []string
+		for _, rr := range result.Answers {
+			if a, ok := rr.RData.(*message.A); ok {
+				addrs = append(addrs, a.Addr.String())
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+	return nil, err
+}
+
+// referral returns the delegated zone and its name server hosts when
+// response delegates name to a zone below the current one.
+func referral(response message.Message, name, zone string) (string, []string) {
+	var child string
+	var hosts []string
+	for _, rr := range response.Authority {
+		ns, ok := rr.RData.(*message.NS)
+		if !ok || rr.Type != message.TypeNS {
+			continue
+		}
+		// Each referral must make progress towards name
+		if message.EqualNames(rr.Name, zone) || !message.IsSubdomain(rr.Name, zone) || !message.IsSubdomain(name, rr.Name) {
+			continue
+		}
+		if child == "" {
+			child = rr.Name
+		}
+		if message.EqualNames(rr.Name, child) {
+			hosts = append(hosts, ns.Host)
+		}
+	}
+	return child, hosts
+}
+
+// glue returns the addresses given for hosts in the additional section.
+// Records outside the referring server's zone are ignored, as it has no
+// authority to supply them. IPv4 addresses are listed first.
+func glue(additional []message.Answer, hosts []string, zone string) []string {
+	var v4, v6 []string
+	for _, rr := range additional {
+		if !message.IsSubdomain(rr.Name, zone) || !containsName(hosts, rr.Name) {
+			continue
+		}
+		switch rdata := rr.RData.(type) {
+		case *message.A:
+			v4 = append(v4, rdata.Addr.String())
+		case *message.AAAA:
+			v6 = append(v6, rdata.Addr.String())
+		}
+	}
+	return append(v4, v6...)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if message.EqualNames(n, name) {
+			return true
+		}
+	}
+	return false
+}
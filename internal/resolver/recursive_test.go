@@ -0,0 +1,282 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func TestFollowChainSkipsEmptyRRSIG(t *testing.T) {
+	answers := []message.Answer{
+		testA("www.example.com", "192.0.2.1"),
+		// RDLENGTH 0 leaves the RDATA unparsed
+		{Name: "www.example.com", Type: message.TypeRRSIG, Class: message.ClassINET, TTL: 300},
+		{Name: "www.example.com", Type: message.TypeRRSIG, Class: message.ClassINET, TTL: 300, RData: &message.RRSIG{TypeCovered: message.TypeA}},
+	}
+	records, next := followChain(answers, "www.example.com", message.TypeA, map[string]bool{})
+	if next != "" {
+		t.Errorf("chain continues at %q", next)
+	}
+	if len(records) != 2 || records[1].RData == nil {
+		t.Errorf("records = %v, want the A record and its signature", records)
+	}
+}
+
+// authority answers questions as a name server would. Servers in a test
+// tree each get their own loopback address, all on one port.
+type authority func(q message.Question) message.Message
+
+// startTree serves each authority on the loopback address it is keyed by
+// and returns the shared port.
+func startTree(t *testing.T, tree map[string]authority) string {
+	t.Helper()
+	for attempt := 0; attempt < 10; attempt++ {
+		var conns []net.PacketConn
+		port := "0"
+		for addr := range tree {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(addr, port))
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+			_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+		}
+		if len(conns) < len(tree) {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			continue
+		}
+		for _, conn := range conns {
+			t.Cleanup(func() { conn.Close() })
+			host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+			go serveAuthority(conn, tree[host])
+		}
+		return port
+	}
+	t.Fatal("no port free on every loopback address")
+	return ""
+}
+
+func serveAuthority(conn net.PacketConn, answer authority) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query, err := message.ParseMessage(buf[:n])
+		if err != nil || len(query.Questions) == 0 {
+			continue
+		}
+		response := answer(query.Questions[0])
+		response.Header.ID = query.Header.ID
+		response.Header.QR = 1
+		response.Questions = query.Questions
+		conn.WriteTo(response.Encode(), addr)
+	}
+}
+
+func testNS(zone, host string) message.Answer {
+	return message.Answer{Name: zone, Type: message.TypeNS, Class: message.ClassINET, TTL: 300, RData: &message.NS{Host: host}}
+}
+
+func testCNAME(name, target string) message.Answer {
+	return message.Answer{Name: name, Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300, RData: &message.CNAME{Target: target}}
+}
+
+// zoneServer returns an authority for zone holding records, which refers
+// names below each NS record's owner to its hosts. The glue for those
+// hosts is taken from glue, whether or not it belongs in the zone.
+func zoneServer(zone string, records, delegations, glue []message.Answer) authority {
+	return func(q message.Question) message.Message {
+		var response message.Message
+		for _, ns := range delegations {
+			if !message.IsSubdomain(q.Name, ns.Name) {
+				continue
+			}
+			response.Authority = append(response.Authority, ns)
+			for _, rr := range glue {
+				if message.EqualNames(rr.Name, ns.RData.(*message.NS).Host) {
+					response.Additional = append(response.Additional, rr)
+				}
+			}
+		}
+		if len(response.Authority) > 0 {
+			return response
+		}
+
+		response.Header.AA = 1
+		exists := false
+		for _, rr := range records {
+			if !message.EqualNames(rr.Name, q.Name) {
+				continue
+			}
+			exists = true
+			if rr.Type == q.Type || rr.Type == message.TypeCNAME {
+				response.Answers = append(response.Answers, rr)
+			}
+		}
+		if len(response.Answers) == 0 {
+			response.Authority = []message.Answer{testSOA(zone)}
+			if !exists {
+				response.SetRCode(message.RCodeNameError)
+			}
+		}
+		return response
+	}
+}
+
+// testTree is a small namespace:
+//
+//	127.0.0.1  the root, delegating com and net with glue
+//	127.0.0.2  com, delegating example.com with glue, glueless.com to a
+//	           host under net, bailiwick.com with glue for a host under
+//	           net that it has no authority to give, and a chain of
+//	           zones d1.com to d5.com each served by a host in the next
+//	127.0.0.3  every zone delegated by com
+//	127.0.0.4  net, holding the addresses of the hosts under it
+//	127.0.0.5  a server the bogus glue points at, with wrong answers
+func testTree() map[string]authority {
+	// dN.com is served by ns.dN+1.com, and d6.com by a host with glue
+	var chain, hosts []message.Answer
+	for i := 1; i <= 5; i++ {
+		chain = append(chain, testNS(fmt.Sprintf("d%d.com", i), fmt.Sprintf("ns.d%d.com", i+1)))
+		hosts = append(hosts, testA(fmt.Sprintf("ns.d%d.com", i+1), "127.0.0.3"))
+	}
+	chain = append(chain, testNS("d6.com", "ns1.example.com"))
+	return map[string]authority{
+		"127.0.0.1": zoneServer("", nil,
+			[]message.Answer{testNS("com", "a.gtld.com"), testNS("net", "a.gtld.net")},
+			[]message.Answer{testA("a.gtld.com", "127.0.0.2"), testA("a.gtld.net", "127.0.0.4")},
+		),
+		"127.0.0.2": zoneServer("com", nil,
+			append([]message.Answer{
+				testNS("example.com", "ns1.example.com"),
+				testNS("glueless.com", "ns2.example.net"),
+				testNS("bailiwick.com", "ns.example.net"),
+			}, chain...),
+			[]message.Answer{testA("ns1.example.com", "127.0.0.3"), testA("ns.example.net", "127.0.0.5")},
+		),
+		"127.0.0.3": zoneServer("example.com", append([]message.Answer{
+			testA("www.example.com", "192.0.2.1"),
+			testCNAME("alias.example.com", "www.example.net"),
+			testCNAME("a.example.com", "b.example.com"),
+			testCNAME("b.example.com", "a.example.com"),
+			testA("www.glueless.com", "192.0.2.2"),
+			testA("www.bailiwick.com", "192.0.2.3"),
+			testA("www.d1.com", "192.0.2.5"),
+			testA("www.d4.com", "192.0.2.5"),
+		}, hosts...), nil, nil),
+		"127.0.0.4": zoneServer("net", []message.Answer{
+			testA("ns.example.net", "127.0.0.3"),
+			testA("ns2.example.net", "127.0.0.3"),
+			testA("www.example.net", "192.0.2.4"),
+		}, nil, nil),
+		"127.0.0.5": zoneServer("bailiwick.com", []message.Answer{
+			testA("www.bailiwick.com", "192.0.2.66"),
+		}, nil, nil),
+	}
+}
+
+func TestRecursiveResolves(t *testing.T) {
+	port := startTree(t, testTree())
+	tests := []struct {
+		name    string
+		rcode   int
+		answers []string // the A records expected at the end of the chain
+	}{
+		{"www.example.com", message.RCodeSuccess, []string{"192.0.2.1"}},
+		{"alias.example.com", message.RCodeSuccess, []string{"192.0.2.4"}},
+		{"www.glueless.com", message.RCodeSuccess, []string{"192.0.2.2"}},
+		// The glue com gave for a host under net must not be used
+		{"www.bailiwick.com", message.RCodeSuccess, []string{"192.0.2.3"}},
+		{"missing.example.com", message.RCodeNameError, nil},
+		{"a.example.com", message.RCodeServerFailure, nil},
+		// Each NS host needs a glueless lookup of the next one, five
+		// deep from d1.com but only two from d4.com
+		{"www.d1.com", message.RCodeServerFailure, nil},
+		{"www.d4.com", message.RCodeSuccess, []string{"192.0.2.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecursive(testLogger(), WithRootHints("127.0.0.1"), WithPort(port))
+			response, err := r.Handle(context.Background(), testQuery(tt.name, message.TypeA))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rcode := response.RCode(); rcode != tt.rcode {
+				t.Fatalf("got %s, want %s", message.RCodeString(rcode), message.RCodeString(tt.rcode))
+			}
+			var got []string
+			for _, rr := range response.Answers {
+				if a, ok := rr.RData.(*message.A); ok {
+					got = append(got, a.Addr.String())
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.answers) {
+				t.Errorf("addresses = %v, want %v", got, tt.answers)
+			}
+			if tt.rcode == message.RCodeNameError && len(response.Authority) == 0 {
+				t.Error("NXDOMAIN without the zone's SOA record")
+			}
+		})
+	}
+}
+
+func TestRecursiveQueryBudget(t *testing.T) {
+	port := startTree(t, testTree())
+	// www.example.com takes three queries: the root, com and example.com
+	for budget, rcode := range map[int]int{2: message.RCodeServerFailure, 3: message.RCodeSuccess} {
+		r := NewRecursive(testLogger(), WithRootHints("127.0.0.1"), WithPort(port), WithQueryBudget(budget))
+		response, err := r.Handle(context.Background(), testQuery("www.example.com", message.TypeA))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.RCode() != rcode {
+			t.Errorf("budget %d: got %s, want %s", budget, message.RCodeString(response.RCode()), message.RCodeString(rcode))
+		}
+	}
+}
+
+// A server that keeps referring a long name one label further down must
+// be given up on after maxReferrals.
+func TestRecursiveReferralLimit(t *testing.T) {
+	var labels []string
+	for i := 0; i < maxReferrals+8; i++ {
+		labels = append(labels, fmt.Sprintf("l%d", i))
+	}
+	name := strings.Join(labels, ".") + ".com"
+
+	var queries atomic.Int32
+	tree := map[string]authority{
+		"127.0.0.1": func(q message.Question) message.Message {
+			n := int(queries.Add(1))
+			if n > len(labels) {
+				return message.Message{Header: message.Header{AA: 1}}
+			}
+			child := strings.Join(labels[len(labels)-n:], ".") + ".com"
+			return message.Message{
+				Authority:  []message.Answer{testNS(child, "ns."+child)},
+				Additional: []message.Answer{testA("ns."+child, "127.0.0.1")},
+			}
+		},
+	}
+	port := startTree(t, tree)
+	r := NewRecursive(testLogger(), WithRootHints("127.0.0.1"), WithPort(port))
+	response, err := r.Handle(context.Background(), testQuery(name, message.TypeA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.RCode() != message.RCodeServerFailure {
+		t.Errorf("got %s, want SERVFAIL", message.RCodeString(response.RCode()))
+	}
+	if n := queries.Load(); n != maxReferrals {
+		t.Errorf("sent %d queries, want %d", n, maxReferrals)
+	}
+}
@@ -0,0 +1,108 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// Transfer sends query, an AXFR or IXFR query, to the server at addr over
+// TCP and returns the answer records of every response message in order.
+// The stream ends once the closing SOA record arrives. Servers may send
+// any number of records per message, down to one, so a lone SOA ends the
+// stream only if it answers an IXFR and is no newer than the client's
+// version, which is how a server says the client is up to date (RFC 1995
+// §2). The client timeout applies to each message rather than to the
+// transfer as a whole.
+func (c *Client) Transfer(ctx context.Context, addr string, query message.Message) ([]message.Answer, error) {
+	conn, err := c.DialTCP(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Unblock the read if ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := WriteMessage(conn, query.Encode()); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", addr, err)
+	}
+
+	var records []message.Answer
+	var serial uint32
+	closing := 0 // SOA records seen with the transfer's final serial
+	for {
+		conn.SetDeadline(c.deadline(ctx))
+		data, err := ReadMessage(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("zone transfer from %s interrupted: %w", addr, err)
+		}
+		response, err := message.ParseMessage(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse response from %s: %w", addr, err)
+		}
+		if !matches(query, response) {
+			continue
+		}
+		if err := verify(query, data); err != nil {
+			return nil, fmt.Errorf("zone transfer from %s: %w", addr, err)
+		}
+		if rcode := response.RCode(); rcode != message.RCodeSuccess {
+			return nil, fmt.Errorf("zone transfer from %s failed: %s", addr, message.RCodeString(rcode))
+		}
+
+		for _, rr := range response.Answers {
+			soa, ok := rr.RData.(*message.SOA)
+			if len(records) == 0 {
+				if !ok || rr.Type != message.TypeSOA {
+					return nil, errors.New("zone transfer does not begin with an SOA record")
+				}
+				serial = soa.Serial
+			}
+			if ok && rr.Type == message.TypeSOA && soa.Serial == serial {
+				closing++
+			}
+			records = append(records, rr)
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("empty zone transfer response from %s", addr)
+		}
+		if (len(records) == 1 && upToDate(query, serial)) || closing == transferEnd(records) {
+			return records, nil
+		}
+	}
+}
+
+// upToDate reports whether a transfer opening with serial tells the
+// sender of query, an IXFR, that its version of the zone is current.
+func upToDate(query message.Message, serial uint32) bool {
+	if len(query.Questions) == 0 || query.Questions[0].Type != message.TypeIXFR {
+		return false
+	}
+	for _, rr := range query.Authority {
+		if soa, ok := rr.RData.(*message.SOA); ok && rr.Type == message.TypeSOA {
+			return int32(serial-soa.Serial) <= 0
+		}
+	}
+	return false
+}
+
+// transferEnd returns how many times the final SOA appears in a complete
+// transfer. In AXFR form it opens and closes the stream. In incremental
+// form, recognised by a second SOA with an older serial, it also ends the
+// last change.
+func transferEnd(records []message.Answer) int {
+	if len(records) > 1 && records[1].Type == message.TypeSOA {
+		if soa, ok := records[1].RData.(*message.SOA); ok && soa.Serial != records[0].RData.(*message.SOA).Serial {
+			return 3
+		}
+	}
+	return 2
+}
@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// servePrimary answers one zone transfer on a loopback TCP listener,
+// sending the records returned by stream for the query in batches of
+// perMessage records. It returns the listener's address.
+func servePrimary(t *testing.T, perMessage int, stream func(query message.Message) []message.Answer) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		query, err := message.ParseMessage(data)
+		if err != nil {
+			return
+		}
+		records := stream(query)
+		for len(records) > 0 {
+			n := min(perMessage, len(records))
+			response := message.Message{
+				Header:    message.Header{ID: query.Header.ID, QR: 1, AA: 1},
+				Questions: query.Questions,
+				Answers:   records[:n],
+			}
+			if err := WriteMessage(conn, response.Encode()); err != nil {
+				return
+			}
+			records = records[n:]
+		}
+		// Hold the connection open, so a client waiting for more records
+		// times out rather than seeing EOF
+		conn.Read(make([]byte, 1))
+	}()
+	return l.Addr().String()
+}
+
+func soaWithSerial(serial uint32) message.Answer {
+	soa := testSOA("example.com")
+	soa.RData.(*message.SOA).Serial = serial
+	return soa
+}
+
+func transferQuery(qtype uint16, serial uint32) message.Message {
+	query := message.Message{
+		Header:    message.Header{ID: 7},
+		Questions: []message.Question{{Name: "example.com", Type: qtype, Class: message.ClassINET}},
+	}
+	if qtype == message.TypeIXFR {
+		query.Authority = []message.Answer{soaWithSerial(serial)}
+	}
+	return query
+}
+
+func TestTransferOneRecordPerMessage(t *testing.T) {
+	zone := []message.Answer{
+		soaWithSerial(2),
+		testA("www.example.com", "192.0.2.1"),
+		testA("mail.example.com", "192.0.2.2"),
+		soaWithSerial(2),
+	}
+	for _, perMessage := range []int{1, 2, len(zone)} {
+		addr := servePrimary(t, perMessage, func(message.Message) []message.Answer { return zone })
+		records, err := (&Client{}).Transfer(context.Background(), addr, transferQuery(message.TypeAXFR, 0))
+		if err != nil {
+			t.Fatalf("%d per message: %v", perMessage, err)
+		}
+		if len(records) != len(zone) {
+			t.Errorf("%d per message: got %d records, want %d", perMessage, len(records), len(zone))
+		}
+	}
+}
+
+func TestTransferIncremental(t *testing.T) {
+	// One delta from serial 1 to 2, sent a record at a time
+	stream := []message.Answer{
+		soaWithSerial(2),
+		soaWithSerial(1),
+		testA("old.example.com", "192.0.2.1"),
+		soaWithSerial(2),
+		testA("new.example.com", "192.0.2.2"),
+		soaWithSerial(2),
+	}
+	addr := servePrimary(t, 1, func(message.Message) []message.Answer { return stream })
+	records, err := (&Client{}).Transfer(context.Background(), addr, transferQuery(message.TypeIXFR, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(stream) {
+		t.Errorf("got %d records, want %d", len(records), len(stream))
+	}
+}
+
+func TestTransferUpToDate(t *testing.T) {
+	addr := servePrimary(t, 1, func(message.Message) []message.Answer {
+		return []message.Answer{soaWithSerial(5)}
+	})
+	records, err := (&Client{}).Transfer(context.Background(), addr, transferQuery(message.TypeIXFR, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records, want the lone SOA", len(records))
+	}
+}
+
+func TestTransferNotStartingWithSOA(t *testing.T) {
+	addr := servePrimary(t, 1, func(message.Message) []message.Answer {
+		return []message.Answer{testA("www.example.com", "192.0.2.1")}
+	})
+	if _, err := (&Client{}).Transfer(context.Background(), addr, transferQuery(message.TypeAXFR, 0)); err == nil {
+		t.Error("Transfer succeeded, want an error")
+	}
+}
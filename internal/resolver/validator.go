@@ -0,0 +1,623 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// maxKeyCacheTTL caps how long a zone's validated keys, or proof that
+	// it is unsigned, are trusted before being fetched again.
+	maxKeyCacheTTL = 60 * 60
+
+	// bogusKeyCacheTTL is how long a zone whose chain of trust failed is
+	// remembered, so every query for it does not refetch its keys (RFC
+	// 4035 §4.7).
+	bogusKeyCacheTTL = 60
+)
+
+// RootTrustAnchors are the DS records of the root zone's key signing keys
+// published by IANA: KSK-2017 and KSK-2024.
+var RootTrustAnchors = []message.Answer{
+	rootAnchor(20326, "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+	rootAnchor(38696, "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"),
+}
+
+func rootAnchor(keyTag uint16, digest string) message.Answer {
+	data, err := hex.DecodeString(digest)
+	if err != nil {
+		panic(err)
+	}
+	return message.Answer{
+		Name:  "",
+		Type:  message.TypeDS,
+		Class: message.ClassINET,
+		RData: &message.DS{KeyTag: keyTag, Algorithm: message.AlgRSASHA256, DigestType: message.DigestSHA256, Digest: data},
+	}
+}
+
+// bogusError is a validation failure. Its code is the Extended DNS Error
+// the client is told about (RFC 8914).
+type bogusError struct {
+	code   uint16
+	reason string
+}
+
+func (e *bogusError) Error() string { return e.reason }
+
+func bogus(code uint16, format string, args ...interface{}) error {
+	return &bogusError{code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+// Validator checks DNSSEC signatures on the responses of the next handler
+// (RFC 4035 §5). It builds a chain of trust from its trust anchors down to
+// the zone each RRset was signed by, following DS records through every
+// zone cut, and checks that negative answers are proven by NSEC or NSEC3
+// records.
+//
+// Data that validates gets the AD bit, if the client asked for it by
+// setting DO or AD, and data from zones proven to be unsigned is passed
+// through without it. Bogus data is never returned: the client gets
+// SERVFAIL with an Extended DNS Error saying why. Clients that set CD do
+// their own validation and get responses unchecked.
+type Validator struct {
+	next    Handler
+	anchors map[string][]message.Answer // DS and DNSKEY records by lowercased owner
+	log     *gotracer.Logger
+	now     func() time.Time
+
+	mu    sync.Mutex
+	zones map[string]*zoneKeys // by lowercased zone name
+}
+
+// zoneKeys is the outcome of building the chain of trust to a zone.
+type zoneKeys struct {
+	// keys are the zone's validated DNSKEY records; none if the zone is
+	// provably unsigned
+	keys    []*message.DNSKEY
+	err     error // set if the chain of trust is broken
+	expires time.Time
+}
+
+func (zk *zoneKeys) secure() bool { return len(zk.keys) > 0 }
+
+// NewValidator creates a Validator in front of next that trusts anchors,
+// DS or DNSKEY records such as RootTrustAnchors. Names not below any
+// anchor are treated as unsigned.
+func NewValidator(next Handler, anchors []message.Answer, log *gotracer.Logger) *Validator {
+	v := &Validator{
+		next:    next,
+		anchors: make(map[string][]message.Answer),
+		log:     log,
+		now:     time.Now,
+		zones:   make(map[string]*zoneKeys),
+	}
+	for _, rr := range anchors {
+		if rr.Type == message.TypeDS || rr.Type == message.TypeDNSKEY {
+			key := strings.ToLower(rr.Name)
+			v.anchors[key] = append(v.anchors[key], rr)
+		}
+	}
+	return v
+}
+
+// Handle passes the query in data on with DNSSEC records requested and
+// checking disabled, so bogus data reaches the validator rather than
+// being dropped upstream, then validates the response unless the client
+// set CD.
+func (v *Validator) Handle(ctx context.Context, data []byte) (message.Message, error) {
+	query, err := message.ParseMessage(data)
+	if err != nil || len(query.Questions) != 1 || query.Header.Opcode != message.StandardQuery {
+		return v.next.Handle(ctx, data)
+	}
+
+	q := query.Questions[0]
+	edns, _ := query.EDNS()
+	upstream := query
+	upstream.Header.Z |= message.ZCheckingDisabled
+	upstream.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: true})
+	response, err := v.next.Handle(ctx, upstream.Encode())
+	if err != nil {
+		return message.Message{}, err
+	}
+	response.Header.Z &^= message.ZAuthenticData
+	response.Header.Z |= query.Header.Z & message.ZCheckingDisabled
+
+	rcode := response.RCode()
+	if query.Header.Z&message.ZCheckingDisabled != 0 || (rcode != message.RCodeSuccess && rcode != message.RCodeNameError) {
+		return withoutDNSSEC(response, q.Type, edns.DO), nil
+	}
+
+	secure, err := v.validate(ctx, q, response)
+	var failure *bogusError
+	if errors.As(err, &failure) {
+		v.log.Infof("DNSSEC validation failed", map[string]interface{}{
+			"name":   q.Name,
+			"type":   message.TypeString(q.Type),
+			"reason": failure.reason,
+		})
+		servfail := message.Message{Header: response.Header, Questions: response.Questions}
+		servfail.Header.AA = 0
+		servfail.SetRCode(message.RCodeServerFailure)
+		servfail.AddExtendedError(failure.code, failure.reason)
+		return servfail, nil
+	}
+	if err != nil {
+		return message.Message{}, err
+	}
+
+	if secure && (edns.DO || query.Header.Z&message.ZAuthenticData != 0) {
+		response.Header.Z |= message.ZAuthenticData
+	}
+	return withoutDNSSEC(response, q.Type, edns.DO), nil
+}
+
+// validate checks the signatures on every RRset in the answer section and,
+// for negative answers or answers synthesized from wildcards, the proof in
+// the authority section. It reports whether all of it is secure; bogus
+// data is a *bogusError.
+func (v *Validator) validate(ctx context.Context, q message.Question, response message.Message) (bool, error) {
+	secure := true
+	for _, rrset := range splitRRsets(response.Answers) {
+		if rrset[0].Type == message.TypeRRSIG {
+			// Signatures are checked with the RRsets they cover; asked for
+			// directly, they are returned unchecked
+			secure = secure && q.Type != message.TypeRRSIG
+			continue
+		}
+		sig, ok, err := v.verifyRRset(ctx, rrset, response.Answers, "")
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			secure = false
+			continue
+		}
+
+		// A signature over fewer labels than its owner has was made over
+		// the wildcard the RRset was synthesized from, which only holds if
+		// the owner does not exist
+		if labels := int(sig.Labels); labels < message.CountLabels(rrset[0].Name) {
+			zoneSecure, err := v.verifyProof(ctx, response.Authority, sig.SignerName)
+			if err != nil {
+				return false, err
+			}
+			if zoneSecure && !proveExpansion(rrset[0].Name, labels, response.Authority) {
+				return false, bogus(message.EDENSECMissing, "no proof that %s does not exist for wildcard expansion", message.Fqdn(rrset[0].Name))
+			}
+		}
+	}
+
+	chain, answered := answerChain(response.Answers, q)
+	if answered {
+		return secure, nil
+	}
+
+	// A negative answer at the end of the chain: the zone it came from
+	// must prove it
+	name := chain[len(chain)-1]
+	zone := name
+	if soa, ok := findSOA(response.Authority); ok && message.IsSubdomain(name, soa.Name) {
+		zone = soa.Name
+	}
+	zk, err := v.keys(ctx, zone)
+	if err != nil {
+		return false, err
+	}
+	if !zk.secure() {
+		return false, nil
+	}
+	if _, err := v.verifyProof(ctx, response.Authority, zone); err != nil {
+		return false, err
+	}
+	proof, ok := proveDenial(name, q.Type, response.Authority)
+	switch {
+	case !ok:
+		return false, bogus(message.EDENSECMissing, "no proof of %s %s %s", message.RCodeString(response.RCode()), message.Fqdn(name), message.TypeString(q.Type))
+	case proof.insecure:
+		return false, nil
+	case proof.nxdomain != (response.RCode() == message.RCodeNameError):
+		return false, bogus(message.EDEDNSSECBogus, "denial of %s %s does not match %s", message.Fqdn(name), message.TypeString(q.Type), message.RCodeString(response.RCode()))
+	}
+	return secure, nil
+}
+
+// verifyRRset checks rrset against the signatures for it in records, only
+// those made by signer if it is set. It returns the signature that
+// verified, or false if rrset belongs to an unsigned zone.
+func (v *Validator) verifyRRset(ctx context.Context, rrset, records []message.Answer, signer string) (*message.RRSIG, bool, error) {
+	owner, rtype := rrset[0].Name, rrset[0].Type
+	var sigs []*message.RRSIG
+	for _, rr := range records {
+		sig, ok := rr.RData.(*message.RRSIG)
+		if !ok || rr.Type != message.TypeRRSIG || sig.TypeCovered != rtype || !message.EqualNames(rr.Name, owner) {
+			continue
+		}
+		if signer == "" || message.EqualNames(sig.SignerName, signer) {
+			sigs = append(sigs, sig)
+		}
+	}
+
+	if len(sigs) == 0 && signer != "" {
+		return nil, false, bogus(message.EDERRSIGsMissing, "no signature by %s for %s %s", message.Fqdn(signer), message.Fqdn(owner), message.TypeString(rtype))
+	}
+	if len(sigs) == 0 {
+		// Unsigned data is only acceptable from an unsigned zone. A DS
+		// RRset lives in the parent zone, above its owner
+		zone := owner
+		if rtype == message.TypeDS {
+			zone = parentName(owner)
+		}
+		zk, err := v.keys(ctx, zone)
+		if err != nil {
+			return nil, false, err
+		}
+		if zk.secure() {
+			return nil, false, bogus(message.EDERRSIGsMissing, "no signature for %s %s", message.Fqdn(owner), message.TypeString(rtype))
+		}
+		return nil, false, nil
+	}
+
+	err := bogus(message.EDERRSIGsMissing, "no usable signature for %s %s", message.Fqdn(owner), message.TypeString(rtype))
+	for _, sig := range sigs {
+		if !message.IsSubdomain(owner, sig.SignerName) || (rtype == message.TypeDS && message.EqualNames(owner, sig.SignerName)) {
+			continue
+		}
+		zk, keysErr := v.keys(ctx, sig.SignerName)
+		if keysErr != nil {
+			return nil, false, keysErr
+		}
+		if !zk.secure() {
+			return nil, false, nil
+		}
+		if err = v.checkSignature(sig, zk.keys, rrset); err == nil {
+			return sig, true, nil
+		}
+	}
+	return nil, false, err
+}
+
+// verifyProof checks the signatures on the SOA, NSEC and NSEC3 records in
+// authority, which must come from zone. It reports false if zone is
+// unsigned.
+func (v *Validator) verifyProof(ctx context.Context, authority []message.Answer, zone string) (bool, error) {
+	zk, err := v.keys(ctx, zone)
+	if err != nil || !zk.secure() {
+		return false, err
+	}
+	for _, rrset := range splitRRsets(authority) {
+		switch rrset[0].Type {
+		case message.TypeSOA, message.TypeNSEC, message.TypeNSEC3:
+		default:
+			continue
+		}
+		if !message.IsSubdomain(rrset[0].Name, zone) {
+			return false, bogus(message.EDEDNSSECBogus, "%s %s is outside zone %s", message.Fqdn(rrset[0].Name), message.TypeString(rrset[0].Type), message.Fqdn(zone))
+		}
+		_, ok, err := v.verifyRRset(ctx, rrset, authority, zone)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, bogus(message.EDERRSIGsMissing, "%s %s is not signed by zone %s", message.Fqdn(rrset[0].Name), message.TypeString(rrset[0].Type), message.Fqdn(zone))
+		}
+	}
+	return true, nil
+}
+
+// checkSignature checks sig over rrset with whichever of keys made it.
+func (v *Validator) checkSignature(sig *message.RRSIG, keys []*message.DNSKEY, rrset []message.Answer) error {
+	owner, rtype := rrset[0].Name, rrset[0].Type
+	if err := sig.ValidAt(v.now()); err != nil {
+		code := message.EDESignatureExpired
+		if errors.Is(err, message.ErrSignatureNotYetValid) {
+			code = message.EDESignatureNotYetValid
+		}
+		return bogus(code, "%v for %s %s", err, message.Fqdn(owner), message.TypeString(rtype))
+	}
+	if int(sig.Labels) > message.CountLabels(owner) {
+		return bogus(message.EDEDNSSECBogus, "signature for %s %s has too many labels", message.Fqdn(owner), message.TypeString(rtype))
+	}
+
+	found := false
+	for _, key := range keys {
+		if key.Algorithm != sig.Algorithm || key.KeyTag() != sig.KeyTag {
+			continue
+		}
+		found = true
+		if sig.Verify(key, rrset) == nil {
+			return nil
+		}
+	}
+	if !found {
+		return bogus(message.EDEDNSKEYMissing, "no DNSKEY %d for signature over %s %s", sig.KeyTag, message.Fqdn(owner), message.TypeString(rtype))
+	}
+	return bogus(message.EDEDNSSECBogus, "invalid signature over %s %s", message.Fqdn(owner), message.TypeString(rtype))
+}
+
+// keys returns the validated keys of the zone at or above name that holds
+// its data. The chain of trust is built downwards from the nearest trust
+// anchor: at each name the DS RRset, signed by the zone above, either
+// vouches for the keys of a zone starting there, or is proven not to
+// exist. Results are cached for the TTL of the records that established
+// them.
+func (v *Validator) keys(ctx context.Context, name string) (*zoneKeys, error) {
+	key := strings.ToLower(strings.TrimSuffix(name, "."))
+	now := v.now()
+
+	v.mu.Lock()
+	zk, ok := v.zones[key]
+	v.mu.Unlock()
+	if ok && now.Before(zk.expires) {
+		return zk, zk.err
+	}
+
+	zk, ttl, err := v.buildKeys(ctx, key)
+	var failure *bogusError
+	if err != nil && !errors.As(err, &failure) {
+		return nil, err
+	}
+	if err != nil {
+		zk, ttl = &zoneKeys{err: err}, bogusKeyCacheTTL
+	}
+	zk.expires = now.Add(time.Duration(min(ttl, maxKeyCacheTTL)) * time.Second)
+
+	v.mu.Lock()
+	v.zones[key] = zk
+	v.mu.Unlock()
+	return zk, zk.err
+}
+
+// buildKeys takes one step down the chain of trust to key, returning its
+// zone's keys and how long they may be cached for.
+func (v *Validator) buildKeys(ctx context.Context, key string) (*zoneKeys, uint32, error) {
+	if anchors, ok := v.anchors[key]; ok {
+		return v.fetchKeys(ctx, key, anchors)
+	}
+	if !v.anchored(key) {
+		return &zoneKeys{}, maxKeyCacheTTL, nil
+	}
+	parent := parentName(key)
+
+	response, err := v.lookup(ctx, key, message.TypeDS)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ds []message.Answer
+	for _, rr := range response.Answers {
+		if rr.Type == message.TypeDS && message.EqualNames(rr.Name, key) {
+			ds = append(ds, rr)
+		}
+	}
+
+	if len(ds) > 0 {
+		// A zone starts here, signed if the zone above vouches for it
+		_, ok, err := v.verifyRRset(ctx, ds, response.Answers, "")
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			return &zoneKeys{}, minTTL(ds), nil
+		}
+		return v.fetchKeys(ctx, key, ds)
+	}
+
+	// No DS: the denial, signed by the zone above, says whether this is an
+	// unsigned delegation or not a zone cut at all
+	var signer string
+	for _, rr := range response.Authority {
+		if sig, ok := rr.RData.(*message.RRSIG); ok && rr.Type == message.TypeRRSIG {
+			signer = sig.SignerName
+			break
+		}
+	}
+	if signer == "" || !message.IsSubdomain(key, signer) || message.EqualNames(key, signer) {
+		// Unsigned, or answered from below the cut: only acceptable if the
+		// zone above is unsigned too
+		zk, err := v.keys(ctx, parent)
+		if err != nil {
+			return nil, 0, err
+		}
+		if zk.secure() {
+			return nil, 0, bogus(message.EDENSECMissing, "no signed proof of DS for %s", message.Fqdn(key))
+		}
+		return zk, maxKeyCacheTTL, nil
+	}
+
+	zk, err := v.keys(ctx, signer)
+	if err != nil || !zk.secure() {
+		return zk, maxKeyCacheTTL, err
+	}
+	if _, err := v.verifyProof(ctx, response.Authority, signer); err != nil {
+		return nil, 0, err
+	}
+	proof, ok := proveDenial(key, message.TypeDS, response.Authority)
+	if !ok {
+		return nil, 0, bogus(message.EDENSECMissing, "no proof of missing DS for %s", message.Fqdn(key))
+	}
+	ttl := maxKeyCacheTTL
+	if _, negative, ok := negativeTTL(response.Authority); ok {
+		ttl = int(negative)
+	}
+	if proof.delegation || proof.insecure {
+		return &zoneKeys{}, uint32(ttl), nil
+	}
+	return zk, uint32(ttl), nil
+}
+
+// fetchKeys fetches the DNSKEY RRset of zone and validates it against
+// trusted, the DS records vouching for its keys or the keys themselves.
+// A zone whose DS records all use algorithms or digests the validator
+// does not support is treated as unsigned (RFC 4035 §5.2).
+func (v *Validator) fetchKeys(ctx context.Context, zone string, trusted []message.Answer) (*zoneKeys, uint32, error) {
+	usable := false
+	for _, rr := range trusted {
+		switch rdata := rr.RData.(type) {
+		case *message.DS:
+			usable = usable || (message.SupportedAlgorithm(rdata.Algorithm) && supportedDigest(rdata.DigestType))
+		case *message.DNSKEY:
+			usable = usable || message.SupportedAlgorithm(rdata.Algorithm)
+		}
+	}
+	if !usable {
+		return &zoneKeys{}, maxKeyCacheTTL, nil
+	}
+
+	response, err := v.lookup(ctx, zone, message.TypeDNSKEY)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rrset []message.Answer
+	var keys []*message.DNSKEY
+	for _, rr := range response.Answers {
+		if dnskey, ok := rr.RData.(*message.DNSKEY); ok && rr.Type == message.TypeDNSKEY && message.EqualNames(rr.Name, zone) {
+			rrset = append(rrset, rr)
+			if dnskey.Flags&message.DNSKEYFlagZone != 0 && dnskey.Protocol == 3 {
+				keys = append(keys, dnskey)
+			}
+		}
+	}
+	if len(rrset) == 0 {
+		return nil, 0, bogus(message.EDEDNSKEYMissing, "no DNSKEY records for %s", message.Fqdn(zone))
+	}
+
+	var entry []*message.DNSKEY
+	for _, dnskey := range keys {
+		if trusts(trusted, zone, dnskey) {
+			entry = append(entry, dnskey)
+		}
+	}
+	if len(entry) == 0 {
+		return nil, 0, bogus(message.EDEDNSKEYMissing, "no DNSKEY of %s matches its DS records", message.Fqdn(zone))
+	}
+
+	// The RRset must be signed by one of the keys the trust comes from
+	err = bogus(message.EDERRSIGsMissing, "no signature for %s DNSKEY", message.Fqdn(zone))
+	for _, rr := range response.Answers {
+		sig, ok := rr.RData.(*message.RRSIG)
+		if !ok || rr.Type != message.TypeRRSIG || sig.TypeCovered != message.TypeDNSKEY || !message.EqualNames(sig.SignerName, zone) {
+			continue
+		}
+		if err = v.checkSignature(sig, entry, rrset); err == nil {
+			return &zoneKeys{keys: keys}, minTTL(rrset), nil
+		}
+	}
+	return nil, 0, err
+}
+
+// trusts reports whether dnskey, owned by zone, is one of the anchors or
+// DS records in trusted.
+func trusts(trusted []message.Answer, zone string, dnskey *message.DNSKEY) bool {
+	for _, rr := range trusted {
+		switch rdata := rr.RData.(type) {
+		case *message.DS:
+			if rdata.KeyTag != dnskey.KeyTag() || rdata.Algorithm != dnskey.Algorithm || !supportedDigest(rdata.DigestType) {
+				continue
+			}
+			if ds, err := dnskey.ToDS(zone, rdata.DigestType); err == nil && bytes.Equal(ds.Digest, rdata.Digest) {
+				return true
+			}
+		case *message.DNSKEY:
+			if bytes.Equal(message.PackRData(rdata), message.PackRData(dnskey)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func supportedDigest(digestType uint8) bool {
+	switch digestType {
+	case message.DigestSHA1, message.DigestSHA256, message.DigestSHA384:
+		return true
+	}
+	return false
+}
+
+// lookup asks the next handler for the records of type qtype at name,
+// with checking disabled, for building the chain of trust.
+func (v *Validator) lookup(ctx context.Context, name string, qtype uint16) (message.Message, error) {
+	query := message.Message{
+		Header:    message.Header{ID: message.RandomID(), RD: 1, Z: message.ZCheckingDisabled},
+		Questions: []message.Question{{Name: name, Type: qtype, Class: message.ClassINET}},
+	}
+	query.SetEDNS(message.EDNS{UDPSize: ednsUDPSize, DO: true})
+	response, err := v.next.Handle(ctx, query.Encode())
+	if err != nil {
+		return message.Message{}, fmt.Errorf("failed to look up %s %s: %w", message.Fqdn(name), message.TypeString(qtype), err)
+	}
+	if rcode := response.RCode(); rcode != message.RCodeSuccess && rcode != message.RCodeNameError {
+		return message.Message{}, fmt.Errorf("looking up %s %s: %s", message.Fqdn(name), message.TypeString(qtype), message.RCodeString(rcode))
+	}
+	return response, nil
+}
+
+// anchored reports whether a trust anchor is at or above key.
+func (v *Validator) anchored(key string) bool {
+	for {
+		if _, ok := v.anchors[key]; ok {
+			return true
+		}
+		if key == "" {
+			return false
+		}
+		key = parentName(key)
+	}
+}
+
+// parentName returns the name one label above name; the root is its own
+// parent.
+func parentName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// findSOA returns the SOA record in records.
+func findSOA(records []message.Answer) (message.Answer, bool) {
+	for _, rr := range records {
+		if rr.Type == message.TypeSOA {
+			return rr, true
+		}
+	}
+	return message.Answer{}, false
+}
+
+// isDNSSECType reports whether t is a type of record that carries DNSSEC
+// proofs, which responses to clients that did not set DO leave out unless
+// asked for (RFC 4035 §3.2.1).
+func isDNSSECType(t uint16) bool {
+	return t == message.TypeRRSIG || t == message.TypeNSEC || t == message.TypeNSEC3
+}
+
+// withoutDNSSEC removes DNSSEC records that a response to a query for
+// qtype need not carry, unless dnssec is set.
+func withoutDNSSEC(response message.Message, qtype uint16, dnssec bool) message.Message {
+	if dnssec {
+		return response
+	}
+	strip := func(records []message.Answer, keep bool) []message.Answer {
+		var out []message.Answer
+		for _, rr := range records {
+			if !isDNSSECType(rr.Type) || (keep && rr.Type == qtype) {
+				out = append(out, rr)
+			}
+		}
+		return out
+	}
+	response.Answers = strip(response.Answers, true)
+	response.Authority = strip(response.Authority, false)
+	response.Additional = strip(response.Additional, false)
+	return response
+}
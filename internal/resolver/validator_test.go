@@ -0,0 +1,266 @@
+package resolver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// testKey signs the RRsets of one zone with an Ed25519 key.
+type testKey struct {
+	zone    string
+	private ed25519.PrivateKey
+	dnskey  *message.DNSKEY
+}
+
+func newTestKey(t *testing.T, zone string) *testKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{zone: zone, private: private, dnskey: &message.DNSKEY{
+		Flags: message.DNSKEYFlagZone | message.DNSKEYFlagSEP, Protocol: 3, Algorithm: message.AlgED25519, PublicKey: public,
+	}}
+}
+
+func (k *testKey) record() message.Answer {
+	return message.Answer{Name: k.zone, Type: message.TypeDNSKEY, Class: message.ClassINET, TTL: 3600, RData: k.dnskey}
+}
+
+func (k *testKey) ds(t *testing.T) message.Answer {
+	t.Helper()
+	ds, err := k.dnskey.ToDS(k.zone, message.DigestSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message.Answer{Name: k.zone, Type: message.TypeDS, Class: message.ClassINET, TTL: 3600, RData: ds}
+}
+
+// sign returns rrset followed by its signature. A signature for a
+// wildcard expansion covers one label fewer than the owner has.
+func (k *testKey) sign(rrset []message.Answer, wildcard bool) []message.Answer {
+	now := time.Now()
+	sig := &message.RRSIG{
+		TypeCovered: rrset[0].Type,
+		Algorithm:   message.AlgED25519,
+		Labels:      uint8(message.CountLabels(rrset[0].Name)),
+		OriginalTTL: rrset[0].TTL,
+		Expiration:  uint32(now.Add(24 * time.Hour).Unix()),
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:      k.dnskey.KeyTag(),
+		SignerName:  k.zone,
+	}
+	if wildcard {
+		sig.Labels--
+	}
+	sig.Signature = ed25519.Sign(k.private, sig.SignedData(rrset))
+	rr := message.Answer{Name: rrset[0].Name, Type: message.TypeRRSIG, Class: rrset[0].Class, TTL: rrset[0].TTL, RData: sig}
+	return append(append([]message.Answer(nil), rrset...), rr)
+}
+
+// signEach signs each record as an RRset of its own.
+func (k *testKey) signEach(records ...message.Answer) []message.Answer {
+	var signed []message.Answer
+	for _, rr := range records {
+		signed = append(signed, k.sign([]message.Answer{rr}, false)...)
+	}
+	return signed
+}
+
+// validatorTree is the upstream view of a small signed tree: com, whose
+// key is the trust anchor, the signed zones example.com (NSEC) and
+// hashed.com (NSEC3), and the unsigned delegation insecure.com.
+func validatorTree(t *testing.T) (anchor message.Answer, responses map[string]message.Message) {
+	com, example, hashed := newTestKey(t, "com"), newTestKey(t, "example.com"), newTestKey(t, "hashed.com")
+	answer := func(records ...message.Answer) message.Message {
+		return message.Message{Header: message.Header{QR: 1, AA: 1}, Answers: records}
+	}
+	negative := func(rcode int, authority ...message.Answer) message.Message {
+		m := message.Message{Header: message.Header{QR: 1, AA: 1}, Authority: authority}
+		m.SetRCode(rcode)
+		return m
+	}
+	hashedChain := testNSEC3Chain("hashed.com", 0, 0, map[string][]uint16{
+		"hashed.com":     {message.TypeSOA, message.TypeNS, message.TypeDNSKEY, message.TypeRRSIG, message.TypeNSEC3PARAM},
+		"www.hashed.com": {message.TypeA, message.TypeRRSIG},
+	})
+
+	responses = map[string]message.Message{
+		"com DNSKEY":         answer(com.sign([]message.Answer{com.record()}, false)...),
+		"example.com DS":     answer(com.sign([]message.Answer{example.ds(t)}, false)...),
+		"example.com DNSKEY": answer(example.sign([]message.Answer{example.record()}, false)...),
+		"hashed.com DS":      answer(com.sign([]message.Answer{hashed.ds(t)}, false)...),
+		"hashed.com DNSKEY":  answer(hashed.sign([]message.Answer{hashed.record()}, false)...),
+		"insecure.com DS": negative(message.RCodeSuccess, com.signEach(
+			testSOA("com"),
+			testNSEC("insecure.com", "zzz.com", message.TypeNS),
+		)...),
+
+		// Keys are looked up at the owner of unsigned data too
+		"www.example.com DS": negative(message.RCodeSuccess, example.signEach(
+			testSOA("example.com"),
+			testNSEC("www.example.com", "example.com", message.TypeA),
+		)...),
+		"www.insecure.com DS": negative(message.RCodeSuccess, testSOA("insecure.com")),
+
+		"www.example.com A": answer(example.sign([]message.Answer{testA("www.example.com", "192.0.2.1")}, false)...),
+		"missing.example.com A": negative(message.RCodeNameError, example.signEach(
+			testSOA("example.com"),
+			testNSEC("example.com", "*.wild.example.com", message.TypeSOA, message.TypeNS, message.TypeDNSKEY),
+		)...),
+		"www.example.com AAAA": negative(message.RCodeSuccess, example.signEach(
+			testSOA("example.com"),
+			testNSEC("www.example.com", "example.com", message.TypeA),
+		)...),
+		"x.wild.example.com A": {
+			Header:    message.Header{QR: 1, AA: 1},
+			Answers:   example.sign([]message.Answer{testA("x.wild.example.com", "192.0.2.2")}, true),
+			Authority: example.signEach(testNSEC("*.wild.example.com", "www.example.com", message.TypeA)),
+		},
+		"www.insecure.com A": answer(testA("www.insecure.com", "192.0.2.3")),
+		"missing.hashed.com A": negative(message.RCodeNameError, append(hashed.signEach(testSOA("hashed.com")),
+			hashed.signEach(hashedChain...)...)...),
+	}
+	return com.record(), responses
+}
+
+func TestValidator(t *testing.T) {
+	anchor, tree := validatorTree(t)
+	_, otherTree := validatorTree(t)
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		cd      bool
+		dnssec  bool
+		anchor  message.Answer
+		now     time.Duration // how far the validator's clock is ahead
+		mangle  func(m *message.Message)
+		rcode   int
+		ad      bool
+		ede     uint16 // Extended DNS Error of a SERVFAIL
+		answers int
+	}{
+		{name: "secure answer", qname: "www.example.com", qtype: message.TypeA, dnssec: true, rcode: message.RCodeSuccess, ad: true, answers: 2},
+		{name: "secure answer without DO", qname: "www.example.com", qtype: message.TypeA, rcode: message.RCodeSuccess, answers: 1},
+		{name: "secure name error", qname: "missing.example.com", qtype: message.TypeA, dnssec: true, rcode: message.RCodeNameError, ad: true},
+		{name: "secure no data", qname: "www.example.com", qtype: message.TypeAAAA, dnssec: true, rcode: message.RCodeSuccess, ad: true},
+		{name: "secure wildcard expansion", qname: "x.wild.example.com", qtype: message.TypeA, dnssec: true, rcode: message.RCodeSuccess, ad: true, answers: 2},
+		{name: "NSEC3 name error", qname: "missing.hashed.com", qtype: message.TypeA, dnssec: true, rcode: message.RCodeNameError, ad: true},
+		{name: "unsigned delegation", qname: "www.insecure.com", qtype: message.TypeA, dnssec: true, rcode: message.RCodeSuccess, answers: 1},
+		{name: "no anchor above", qname: "www.example.com", qtype: message.TypeA, dnssec: true,
+			anchor: message.Answer{Name: "net", Type: message.TypeDNSKEY, Class: message.ClassINET, RData: anchor.RData},
+			rcode:  message.RCodeSuccess, answers: 2},
+
+		{name: "modified answer", qname: "www.example.com", qtype: message.TypeA, dnssec: true,
+			mangle: func(m *message.Message) { m.Answers[0] = testA("www.example.com", "192.0.2.66") },
+			rcode:  message.RCodeServerFailure, ede: message.EDEDNSSECBogus},
+		{name: "unsigned data in a signed zone", qname: "www.example.com", qtype: message.TypeA, dnssec: true,
+			mangle: func(m *message.Message) { m.Answers = m.Answers[:1] },
+			rcode:  message.RCodeServerFailure, ede: message.EDERRSIGsMissing},
+		{name: "signature expired", qname: "www.example.com", qtype: message.TypeA, dnssec: true, now: 48 * time.Hour,
+			rcode: message.RCodeServerFailure, ede: message.EDESignatureExpired},
+		{name: "signature not yet valid", qname: "www.example.com", qtype: message.TypeA, dnssec: true, now: -2 * time.Hour,
+			rcode: message.RCodeServerFailure, ede: message.EDESignatureNotYetValid},
+		{name: "denial stripped", qname: "missing.example.com", qtype: message.TypeA, dnssec: true,
+			mangle: func(m *message.Message) { m.Authority = m.Authority[:2] },
+			rcode:  message.RCodeServerFailure, ede: message.EDENSECMissing},
+		{name: "no data proof for a name error", qname: "www.example.com", qtype: message.TypeAAAA, dnssec: true,
+			mangle: func(m *message.Message) { m.SetRCode(message.RCodeNameError) },
+			rcode:  message.RCodeServerFailure, ede: message.EDEDNSSECBogus},
+		{name: "wildcard proof stripped", qname: "x.wild.example.com", qtype: message.TypeA, dnssec: true,
+			mangle: func(m *message.Message) { m.Authority = nil },
+			rcode:  message.RCodeServerFailure, ede: message.EDENSECMissing},
+		{name: "anchor does not match", qname: "www.example.com", qtype: message.TypeA, dnssec: true,
+			anchor: otherTree["com DNSKEY"].Answers[0],
+			rcode:  message.RCodeServerFailure, ede: message.EDEDNSKEYMissing},
+		{name: "checking disabled", qname: "www.example.com", qtype: message.TypeA, cd: true, dnssec: true,
+			mangle: func(m *message.Message) { m.Answers[0] = testA("www.example.com", "192.0.2.66") },
+			rcode:  message.RCodeSuccess, answers: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			upstream := answering(&calls, func(q message.Question) message.Message {
+				response, ok := tree[strings.ToLower(q.Name)+" "+message.TypeString(q.Type)]
+				if !ok {
+					t.Errorf("unexpected query for %s %s", q.Name, message.TypeString(q.Type))
+					return message.Message{Header: message.Header{QR: 1, RCode: message.RCodeServerFailure}}
+				}
+				response = message.Message{Header: response.Header, Answers: append([]message.Answer(nil), response.Answers...),
+					Authority: append([]message.Answer(nil), response.Authority...)}
+				if tt.mangle != nil && message.EqualNames(q.Name, tt.qname) && q.Type == tt.qtype {
+					tt.mangle(&response)
+				}
+				return response
+			})
+			trusted := anchor
+			if tt.anchor.RData != nil {
+				trusted = tt.anchor
+			}
+			v := NewValidator(upstream, []message.Answer{trusted}, testLogger())
+			v.now = func() time.Time { return time.Now().Add(tt.now) }
+
+			query := message.Message{
+				Header:    message.Header{ID: 1, RD: 1},
+				Questions: []message.Question{{Name: tt.qname, Type: tt.qtype, Class: message.ClassINET}},
+			}
+			if tt.cd {
+				query.Header.Z |= message.ZCheckingDisabled
+			}
+			query.SetEDNS(message.EDNS{UDPSize: 1232, DO: tt.dnssec})
+			response, err := v.Handle(context.Background(), query.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rcode := response.RCode(); rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", message.RCodeString(rcode), message.RCodeString(tt.rcode))
+			}
+			if ad := response.Header.Z&message.ZAuthenticData != 0; ad != tt.ad {
+				t.Errorf("AD = %v, want %v", ad, tt.ad)
+			}
+			if len(response.Answers) != tt.answers {
+				t.Errorf("answers = %v, want %d records", response.Answers, tt.answers)
+			}
+			var codes []uint16
+			for _, o := range response.ExtendedErrors() {
+				codes = append(codes, binary.BigEndian.Uint16(o.Data))
+			}
+			if tt.ede != 0 && (len(codes) != 1 || codes[0] != tt.ede) {
+				t.Errorf("extended errors %v, want %d", codes, tt.ede)
+			}
+			if tt.ede == 0 && len(codes) > 0 {
+				t.Errorf("unexpected extended errors %v", codes)
+			}
+		})
+	}
+}
+
+func TestValidatorCachesKeys(t *testing.T) {
+	anchor, tree := validatorTree(t)
+	var calls int
+	upstream := answering(&calls, func(q message.Question) message.Message {
+		return tree[strings.ToLower(q.Name)+" "+message.TypeString(q.Type)]
+	})
+	v := NewValidator(upstream, []message.Answer{anchor}, testLogger())
+
+	query := testQuery("www.example.com", message.TypeA)
+	for i := 0; i < 3; i++ {
+		if _, err := v.Handle(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// com DNSKEY, example.com DS and DNSKEY once, then the query each time
+	if calls != 6 {
+		t.Errorf("%d upstream queries, want 6", calls)
+	}
+}
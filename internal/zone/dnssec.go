@@ -0,0 +1,198 @@
+package zone
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// isDNSSECType reports whether t is one of the types a signer generates,
+// which responses to clients that did not ask for DNSSEC leave out.
+func isDNSSECType(t uint16) bool {
+	return t == message.TypeRRSIG || t == message.TypeNSEC || t == message.TypeNSEC3
+}
+
+// hashed reports whether n only exists to hold an NSEC3 record. Its name
+// is a hash rather than a name of the zone, so lookups pass it over (RFC
+// 5155 §7.2.8).
+func (n *node) hashed() bool {
+	if _, ok := n.rrsets[message.TypeNSEC3]; !ok {
+		return false
+	}
+	for t := range n.rrsets {
+		if t != message.TypeNSEC3 && t != message.TypeRRSIG {
+			return false
+		}
+	}
+	return true
+}
+
+// indexChain records the zone's NSEC or NSEC3 chain, whether the zone
+// signed it itself or was loaded or transferred signed, so negative
+// answers can find the records that prove them. A zone with an NSEC3PARAM
+// record at its apex is denied with NSEC3. z.mu must be held for writing,
+// or z not yet shared.
+func (z *Zone) indexChain() {
+	z.chain, z.nsec3 = nil, nil
+	if params := z.rrset(z.origin, message.TypeNSEC3PARAM); len(params) > 0 {
+		z.nsec3 = params[0].RData.(*message.NSEC3PARAM)
+	}
+
+	chainType := message.TypeNSEC
+	if z.nsec3 != nil {
+		chainType = message.TypeNSEC3
+	}
+	for key, n := range z.nodes {
+		if _, ok := n.rrsets[chainType]; ok {
+			z.chain = append(z.chain, key)
+		}
+	}
+
+	// Hashed owner names all have one label of the same length above the
+	// origin, and base32hex sorts like the hashes it encodes
+	if z.nsec3 != nil {
+		sort.Strings(z.chain)
+	} else {
+		sort.Slice(z.chain, func(i, j int) bool { return message.CompareNames(z.chain[i], z.chain[j]) < 0 })
+	}
+}
+
+// signatures returns the RRSIG records in rrsets that cover rtype.
+func signatures(rrsets map[uint16][]message.Answer, rtype uint16) []message.Answer {
+	var sigs []message.Answer
+	for _, rr := range rrsets[message.TypeRRSIG] {
+		if sig, ok := rr.RData.(*message.RRSIG); ok && sig.TypeCovered == rtype {
+			sigs = append(sigs, rr)
+		}
+	}
+	return sigs
+}
+
+// withSignatures returns the records of type rtype in rrsets followed,
+// if dnssec is set, by their signatures.
+func withSignatures(rrsets map[uint16][]message.Answer, rtype uint16, dnssec bool) []message.Answer {
+	records := rrsets[rtype]
+	if dnssec {
+		records = append(append([]message.Answer(nil), records...), signatures(rrsets, rtype)...)
+	}
+	return records
+}
+
+// negative returns the authority section of a negative answer for name:
+// the SOA and, if dnssec is set, its signature and the NSEC or NSEC3
+// records that prove the name or data does not exist (RFC 4035 §3.1.3,
+// RFC 5155 §7.2). z.mu must be held.
+func (z *Zone) negative(name string, dnssec bool) []message.Answer {
+	soa := z.negativeSOA()
+	authority := []message.Answer{soa}
+	if !dnssec {
+		return authority
+	}
+	for _, sig := range signatures(z.nodes[strings.ToLower(z.origin)].rrsets, message.TypeSOA) {
+		sig.TTL = soa.TTL
+		authority = append(authority, sig)
+	}
+
+	key := strings.ToLower(name)
+	if z.exists(key) {
+		// No data at a name that exists: the record matching it shows
+		// which types it has
+		return append(authority, z.deny(key)...)
+	}
+
+	// The name does not exist, and neither does a wildcard that could
+	// have matched it, or the wildcard has no data of the type asked for
+	encloser := z.closestEncloser(key)
+	if z.nsec3 != nil {
+		return append(authority, z.deny(encloser, message.NextCloser(key, encloser), message.WildcardName(encloser))...)
+	}
+	return append(authority, z.deny(key, message.WildcardName(encloser))...)
+}
+
+// wildcardProof returns the NSEC or NSEC3 record that proves name does
+// not exist, which an answer synthesized from a wildcard must carry (RFC
+// 4035 §3.1.3.3, RFC 5155 §7.2.6). z.mu must be held.
+func (z *Zone) wildcardProof(name string) []message.Answer {
+	key := strings.ToLower(name)
+	if z.nsec3 != nil {
+		return z.deny(message.NextCloser(key, z.closestEncloser(key)))
+	}
+	return z.deny(key)
+}
+
+// delegationProof returns what makes a referral to cut verifiable: the
+// DS RRset and its signature for a signed child zone, or the record that
+// proves there is no DS for an unsigned one (RFC 4035 §3.1.4). z.mu must
+// be held.
+func (z *Zone) delegationProof(cut *node) []message.Answer {
+	if _, ok := cut.rrsets[message.TypeDS]; ok {
+		return withSignatures(cut.rrsets, message.TypeDS, true)
+	}
+	return z.deny(strings.ToLower(cut.name))
+}
+
+// deny returns the NSEC or NSEC3 records, with their signatures, that
+// match or cover each of keys, leaving out repeats. z.mu must be held.
+func (z *Zone) deny(keys ...string) []message.Answer {
+	if len(z.chain) == 0 {
+		return nil
+	}
+	chainType := message.TypeNSEC
+	if z.nsec3 != nil {
+		chainType = message.TypeNSEC3
+	}
+
+	var records []message.Answer
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		owner := z.covering(key)
+		if seen[owner] {
+			continue
+		}
+		seen[owner] = true
+		records = append(records, withSignatures(z.nodes[owner].rrsets, chainType, true)...)
+	}
+	return records
+}
+
+// covering returns the owner of the chain record that matches key or
+// covers it: the last one at or before it in chain order, or the last of
+// all, whose next name wraps around to the first. z.mu must be held.
+func (z *Zone) covering(key string) string {
+	var i int
+	if z.nsec3 != nil {
+		hash := message.HashName(key, z.nsec3.Iterations, z.nsec3.Salt)
+		target := message.EncodeNSEC3Hash(hash)
+		if origin := strings.ToLower(z.origin); origin != "" {
+			target += "." + origin
+		}
+		i = sort.Search(len(z.chain), func(i int) bool { return z.chain[i] > target })
+	} else {
+		i = sort.Search(len(z.chain), func(i int) bool { return message.CompareNames(z.chain[i], key) > 0 })
+	}
+	if i == 0 {
+		i = len(z.chain)
+	}
+	return z.chain[i-1]
+}
+
+// exists reports whether the name key, which must be lowercase, exists
+// in the zone, as a node or an empty non-terminal. z.mu must be held.
+func (z *Zone) exists(key string) bool {
+	if n, ok := z.nodes[key]; ok {
+		return !n.hashed()
+	}
+	return z.descendants[key] > 0
+}
+
+// closestEncloser returns the nearest ancestor of key that exists (RFC
+// 5155 §1.3). key must be lowercase. z.mu must be held.
+func (z *Zone) closestEncloser(key string) string {
+	for _, ancestor := range z.ancestors(key) {
+		if z.exists(ancestor) {
+			return ancestor
+		}
+	}
+	return strings.ToLower(z.origin)
+}
@@ -0,0 +1,107 @@
+package zone
+
+import (
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// maxJournalDeltas bounds how many changes a zone remembers. Clients
+// further behind than this get a full transfer instead.
+const maxJournalDeltas = 100
+
+// Delta is one change to a zone, taking it from the serial in OldSOA to
+// the serial in NewSOA. Deleted and Added never hold SOA records.
+type Delta struct {
+	OldSOA  message.Answer
+	NewSOA  message.Answer
+	Deleted []message.Answer
+	Added   []message.Answer
+}
+
+// Records returns the delta in the form IXFR sends it (RFC 1995 §4): the
+// old SOA, the deleted records, the new SOA, then the added records.
+func (d Delta) Records() []message.Answer {
+	records := make([]message.Answer, 0, len(d.Deleted)+len(d.Added)+2)
+	records = append(records, d.OldSOA)
+	records = append(records, d.Deleted...)
+	records = append(records, d.NewSOA)
+	return append(records, d.Added...)
+}
+
+// Serial returns the serial number of an SOA record.
+func Serial(soa message.Answer) uint32 {
+	if rdata, ok := soa.RData.(*message.SOA); ok {
+		return rdata.Serial
+	}
+	return 0
+}
+
+// SerialLess reports whether serial a comes before b in the sequence
+// space arithmetic of RFC 1982.
+func SerialLess(a, b uint32) bool {
+	return a != b && int32(b-a) > 0
+}
+
+// Journal returns the changes that take the zone from serial to its
+// current version, oldest first. It reports false when the journal does
+// not reach back to serial.
+func (z *Zone) Journal(serial uint32) ([]Delta, bool) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	for i, d := range z.journal {
+		if Serial(d.OldSOA) == serial {
+			return append([]Delta(nil), z.journal[i:]...), true
+		}
+	}
+	return nil, false
+}
+
+// record appends d to the journal, dropping the oldest changes beyond
+// maxJournalDeltas. z.mu must be held for writing.
+func (z *Zone) record(d Delta) {
+	z.journal = append(z.journal, d)
+	if len(z.journal) > maxJournalDeltas {
+		z.journal = append([]Delta(nil), z.journal[len(z.journal)-maxJournalDeltas:]...)
+	}
+}
+
+// diff returns the records in old but not in new, and those in new but
+// not in old. SOA records are left out.
+func diff(old, new []message.Answer) (deleted, added []message.Answer) {
+	oldSet := recordSet(old)
+	newSet := recordSet(new)
+	for _, rr := range old {
+		if _, ok := newSet[recordKey(rr)]; !ok && rr.Type != message.TypeSOA {
+			deleted = append(deleted, rr)
+		}
+	}
+	for _, rr := range new {
+		if _, ok := oldSet[recordKey(rr)]; !ok && rr.Type != message.TypeSOA {
+			added = append(added, rr)
+		}
+	}
+	return deleted, added
+}
+
+func recordSet(records []message.Answer) map[string]struct{} {
+	set := make(map[string]struct{}, len(records))
+	for _, rr := range records {
+		set[recordKey(rr)] = struct{}{}
+	}
+	return set
+}
+
+// recordKey identifies a record by owner, type, class, TTL and RDATA.
+func recordKey(rr message.Answer) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(rr.Name))
+	b.WriteByte(0)
+	b.WriteString(string([]byte{byte(rr.Type >> 8), byte(rr.Type), byte(rr.Class >> 8), byte(rr.Class),
+		byte(rr.TTL >> 24), byte(rr.TTL >> 16), byte(rr.TTL >> 8), byte(rr.TTL)}))
+	if rr.RData != nil {
+		b.Write(message.PackRData(rr.RData))
+	}
+	return b.String()
+}
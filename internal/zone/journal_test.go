@@ -0,0 +1,126 @@
+package zone
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// zoneVersion returns example.com at serial with the given extra records.
+func zoneVersion(t *testing.T, serial uint32, extra string) []message.Answer {
+	t.Helper()
+	records, err := Parse(strings.NewReader(fmt.Sprintf(`$ORIGIN example.com.
+$TTL 300
+@ SOA ns1 hostmaster %d 3600 900 604800 300
+@ NS ns1
+ns1 A 192.0.2.1
+%s`, serial, extra)), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSerialLess(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		less bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{5, 5, false},
+		{0xFFFFFFFF, 0, true},
+		{0xFFFFFFF0, 5, true},
+		{5, 0xFFFFFFF0, false},
+		{0, 0x7FFFFFFF, true},
+	}
+	for _, tt := range tests {
+		if got := SerialLess(tt.a, tt.b); got != tt.less {
+			t.Errorf("SerialLess(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.less)
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	z, err := New(zoneVersion(t, 1, "www A 192.0.2.10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Replace(zoneVersion(t, 2, "www A 192.0.2.11\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Replace(zoneVersion(t, 3, "www A 192.0.2.11\nmail A 192.0.2.20\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serial uint32
+		ok     bool
+		deltas []string // each delta as its serials, deleted and added owners
+	}{
+		{1, true, []string{"1>2 -www +www", "2>3 +mail"}},
+		{2, true, []string{"2>3 +mail"}},
+		{3, false, nil},
+		{7, false, nil},
+	}
+	for _, tt := range tests {
+		deltas, ok := z.Journal(tt.serial)
+		if ok != tt.ok {
+			t.Errorf("Journal(%d) ok = %v, want %v", tt.serial, ok, tt.ok)
+			continue
+		}
+		var got []string
+		for _, d := range deltas {
+			s := fmt.Sprintf("%d>%d", Serial(d.OldSOA), Serial(d.NewSOA))
+			for _, rr := range d.Deleted {
+				s += " -" + strings.TrimSuffix(rr.Name, ".example.com")
+			}
+			for _, rr := range d.Added {
+				s += " +" + strings.TrimSuffix(rr.Name, ".example.com")
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.deltas, ", ") {
+			t.Errorf("Journal(%d) = %v, want %v", tt.serial, got, tt.deltas)
+		}
+	}
+
+	// IXFR form: old SOA, deletions, new SOA, additions
+	deltas, _ := z.Journal(1)
+	var types []string
+	for _, rr := range deltas[0].Records() {
+		types = append(types, message.TypeString(rr.Type))
+	}
+	if got := strings.Join(types, " "); got != "SOA A SOA A" {
+		t.Errorf("delta records are %s, want SOA A SOA A", got)
+	}
+
+	// A version that does not move the serial forward breaks the chain
+	if err := z.Replace(zoneVersion(t, 3, "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := z.Journal(2); ok {
+		t.Error("journal kept after the serial stood still")
+	}
+}
+
+func TestJournalLimit(t *testing.T) {
+	z, err := New(zoneVersion(t, 0, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for serial := uint32(1); serial <= maxJournalDeltas+5; serial++ {
+		if err := z.Replace(zoneVersion(t, serial, fmt.Sprintf("host%d A 192.0.2.2\n", serial))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := z.Journal(4); ok {
+		t.Error("journal reaches further back than maxJournalDeltas")
+	}
+	deltas, ok := z.Journal(5)
+	if !ok || len(deltas) != maxJournalDeltas {
+		t.Errorf("Journal(5) = %d deltas, %v; want %d", len(deltas), ok, maxJournalDeltas)
+	}
+}
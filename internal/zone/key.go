@@ -0,0 +1,148 @@
+package zone
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// Key is a private key a zone is signed with. It signs every RRset in
+// the zone, so its DNSKEY carries both the zone and SEP flags, as a
+// combined signing key does.
+type Key struct {
+	dnskey  message.DNSKEY
+	private crypto.Signer
+}
+
+// GenerateKey creates a key for algorithm, which must be
+// message.AlgECDSAP256SHA256 or message.AlgED25519.
+func GenerateKey(algorithm uint8) (*Key, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case message.AlgECDSAP256SHA256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case message.AlgED25519:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKey(private)
+}
+
+// LoadKey reads a PKCS #8 private key in PEM form from path. If the file
+// does not exist a new key for algorithm is generated and saved there, so
+// the zone keeps its key, and the DS record its parent holds stays valid,
+// across restarts.
+func LoadKey(path string, algorithm uint8) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := GenerateKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key.private)
+		if err != nil {
+			return nil, err
+		}
+		block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, block, 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+	key, err := newKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// newKey builds the DNSKEY record for private.
+func newKey(private crypto.Signer) (*Key, error) {
+	k := &Key{
+		dnskey:  message.DNSKEY{Flags: message.DNSKEYFlagZone | message.DNSKEYFlagSEP, Protocol: 3},
+		private: private,
+	}
+	switch private := private.(type) {
+	case *ecdsa.PrivateKey:
+		if private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", private.Curve.Params().Name)
+		}
+		public, err := private.PublicKey.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		// The uncompressed point without its leading 0x04 (RFC 6605 §4)
+		k.dnskey.Algorithm = message.AlgECDSAP256SHA256
+		k.dnskey.PublicKey = public.Bytes()[1:]
+	case ed25519.PrivateKey:
+		k.dnskey.Algorithm = message.AlgED25519
+		k.dnskey.PublicKey = []byte(private.Public().(ed25519.PublicKey))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	return k, nil
+}
+
+// DNSKEY returns the key's public DNSKEY record data.
+func (k *Key) DNSKEY() *message.DNSKEY {
+	dnskey := k.dnskey
+	return &dnskey
+}
+
+// Algorithm returns the key's DNSSEC algorithm number.
+func (k *Key) Algorithm() uint8 { return k.dnskey.Algorithm }
+
+// KeyTag returns the tag signatures made with the key carry.
+func (k *Key) KeyTag() uint16 { return k.dnskey.KeyTag() }
+
+// sign signs data in the form the key's algorithm calls for: ECDSA
+// signatures are the integers r and s as two fixed-size halves (RFC 6605
+// §4), Ed25519 signatures are used as they are (RFC 8080 §4).
+func (k *Key) sign(data []byte) ([]byte, error) {
+	switch private := k.private.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(private, data), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", k.private)
+}
@@ -0,0 +1,478 @@
+package zone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// maxIncludeDepth bounds $INCLUDE nesting so include loops fail cleanly.
+const maxIncludeDepth = 8
+
+// ParseError reports a syntax error in a master file.
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// ParseFile reads the master file at path (RFC 1035 §5). Relative names
+// are completed with origin until a $ORIGIN directive changes it.
+func ParseFile(path, origin string) ([]message.Answer, error) {
+	p := &parser{origin: trimDot(origin)}
+	if err := p.parseFile(path, 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// Parse reads master file records from r. name is used in error messages
+// and to resolve $INCLUDE paths.
+func Parse(r io.Reader, name, origin string) ([]message.Answer, error) {
+	p := &parser{origin: trimDot(origin)}
+	if err := p.parse(r, name, 0); err != nil {
+		return nil, err
+	}
+	return p.records, nil
+}
+
+// parser holds the state carried between entries of a master file.
+type parser struct {
+	origin     string
+	defaultTTL uint32 // from $TTL
+	hasTTL     bool
+	lastOwner  string
+	lastTTL    uint32 // TTL of the previous record, used when there is no $TTL
+	hasLastTTL bool
+	lastClass  uint16
+	records    []message.Answer
+}
+
+func (p *parser) parseFile(path string, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.parse(f, path, depth)
+}
+
+func (p *parser) parse(r io.Reader, file string, depth int) error {
+	lex := newLexer(r)
+	for {
+		tokens, blank, line, err := lex.entry()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &ParseError{File: file, Line: line, Err: err}
+		}
+		if err := p.entry(tokens, blank, file, depth); err != nil {
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				return err
+			}
+			return &ParseError{File: file, Line: line, Err: err}
+		}
+	}
+}
+
+// entry handles one logical line: a directive or a resource record.
+func (p *parser) entry(tokens []token, blank bool, file string, depth int) error {
+	if !blank && strings.HasPrefix(tokens[0].text, "$") && !tokens[0].quoted {
+		return p.directive(tokens, file, depth)
+	}
+
+	owner := p.lastOwner
+	if !blank {
+		name, err := p.name(tokens[0])
+		if err != nil {
+			return err
+		}
+		owner = name
+		tokens = tokens[1:]
+	} else if p.lastOwner == "" && len(p.records) == 0 {
+		return errors.New("record has no owner name")
+	}
+
+	// TTL and class are optional and may come in either order
+	ttl, hasTTL := uint32(0), false
+	class, hasClass := uint16(0), false
+	for i := 0; i < 2 && len(tokens) > 0; i++ {
+		if !hasTTL && isTTL(tokens[0].text) {
+			v, err := parseTTL(tokens[0].text)
+			if err != nil {
+				return err
+			}
+			ttl, hasTTL = v, true
+			tokens = tokens[1:]
+			continue
+		}
+		if !hasClass && !tokens[0].quoted {
+			if c, err := message.ParseClass(tokens[0].text); err == nil {
+				class, hasClass = c, true
+				tokens = tokens[1:]
+				continue
+			}
+		}
+		break
+	}
+	if len(tokens) == 0 {
+		return errors.New("missing record type")
+	}
+
+	rtype, err := parseType(tokens[0].text)
+	if err != nil {
+		return err
+	}
+	tokens = tokens[1:]
+
+	if !hasClass {
+		if p.lastClass == 0 {
+			class = message.ClassINET
+		} else {
+			class = p.lastClass
+		}
+	}
+
+	rdata, err := parseRData(rtype, tokens, p.origin)
+	if err != nil {
+		return fmt.Errorf("%s record: %w", message.TypeString(rtype), err)
+	}
+
+	if !hasTTL {
+		switch {
+		case p.hasTTL:
+			ttl = p.defaultTTL
+		case p.hasLastTTL:
+			ttl = p.lastTTL
+		case rtype == message.TypeSOA:
+			// RFC 1035 zones without $TTL take the default from the SOA minimum
+			ttl = rdata.(*message.SOA).Minimum
+		default:
+			return errors.New("no TTL given and no $TTL in effect")
+		}
+	}
+
+	p.lastOwner, p.lastClass = owner, class
+	p.lastTTL, p.hasLastTTL = ttl, true
+	p.records = append(p.records, message.Answer{
+		Name:  owner,
+		Type:  rtype,
+		Class: class,
+		TTL:   ttl,
+		RData: rdata,
+	})
+	return nil
+}
+
+func (p *parser) directive(tokens []token, file string, depth int) error {
+	switch strings.ToUpper(tokens[0].text) {
+	case "$ORIGIN":
+		if len(tokens) != 2 {
+			return errors.New("$ORIGIN takes one domain name")
+		}
+		origin, err := p.name(tokens[1])
+		if err != nil {
+			return err
+		}
+		p.origin = origin
+	case "$TTL":
+		if len(tokens) != 2 {
+			return errors.New("$TTL takes one TTL value")
+		}
+		ttl, err := parseTTL(tokens[1].text)
+		if err != nil {
+			return err
+		}
+		p.defaultTTL, p.hasTTL = ttl, true
+	case "$INCLUDE":
+		if len(tokens) < 2 || len(tokens) > 3 {
+			return errors.New("$INCLUDE takes a file name and an optional origin")
+		}
+		if depth >= maxIncludeDepth {
+			return errors.New("$INCLUDE nested too deeply")
+		}
+		path := tokens[1].text
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(file), path)
+		}
+
+		// The included file may change the origin without affecting this one
+		// (RFC 1035 §5.1)
+		child := *p
+		if len(tokens) == 3 {
+			origin, err := p.name(tokens[2])
+			if err != nil {
+				return err
+			}
+			child.origin = origin
+		}
+		if err := child.parseFile(path, depth+1); err != nil {
+			return err
+		}
+		p.records = child.records
+	default:
+		return fmt.Errorf("unknown directive %s", tokens[0].text)
+	}
+	return nil
+}
+
+// name converts a domain name token to an absolute name.
+func (p *parser) name(t token) (string, error) {
+	return absoluteName(t.text, p.origin)
+}
+
+// absoluteName completes a possibly relative name with origin. "@" stands
+// for the origin itself.
+func absoluteName(name, origin string) (string, error) {
+	switch {
+	case name == "":
+		return "", errors.New("empty domain name")
+	case name == "@":
+		return origin, nil
+	case name == ".":
+		return "", nil
+	case strings.HasSuffix(name, "."):
+		name = strings.TrimSuffix(name, ".")
+	case origin != "":
+		name = name + "." + origin
+	}
+	if strings.Contains(name, "..") || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid domain name %q", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 {
+			return "", fmt.Errorf("label %q longer than 63 bytes", label)
+		}
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("domain name %q longer than 255 bytes", name)
+	}
+	return name, nil
+}
+
+func trimDot(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
+
+// parseType accepts a type mnemonic or the RFC 3597 TYPEnnn form. Unlike
+// message.ParseType it rejects bare numbers, which would be TTLs here.
+func parseType(s string) (uint16, error) {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return 0, fmt.Errorf("unknown record type %q", s)
+	}
+	return message.ParseType(s)
+}
+
+func isTTL(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// parseTTL parses a TTL in seconds, or in the BIND form with w, d, h, m
+// and s units such as 1h30m.
+func parseTTL(s string) (uint32, error) {
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), nil
+	}
+
+	var total, current uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			current = current*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		var unit uint64
+		switch c {
+		case 'w':
+			unit = 7 * 24 * 3600
+		case 'd':
+			unit = 24 * 3600
+		case 'h':
+			unit = 3600
+		case 'm':
+			unit = 60
+		case 's':
+			unit = 1
+		default:
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		total += current * unit
+		current, digits = 0, false
+		if total > 1<<31-1 {
+			return 0, fmt.Errorf("TTL %q out of range", s)
+		}
+	}
+	if digits {
+		return 0, fmt.Errorf("invalid TTL %q: missing unit", s)
+	}
+	return uint32(total), nil
+}
+
+// token is a field of a master file entry. Quoted tokens may contain
+// spaces and are never directives or names.
+type token struct {
+	text   string
+	quoted bool
+}
+
+// lexer splits a master file into entries, joining lines inside
+// parentheses and dropping comments.
+type lexer struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newLexer(r io.Reader) *lexer {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	return &lexer{scanner: scanner}
+}
+
+// entry returns the tokens of the next non-empty entry, whether it began
+// with whitespace (so it reuses the previous owner name), and the line it
+// started on.
+func (l *lexer) entry() ([]token, bool, int, error) {
+	var tokens []token
+	var blank bool
+	depth := 0
+	start := 0
+
+	for l.scanner.Scan() {
+		l.line++
+		text := l.scanner.Text()
+		if depth == 0 {
+			start = l.line
+			blank = len(text) > 0 && (text[0] == ' ' || text[0] == '\t')
+		}
+
+		var err error
+		if tokens, depth, err = l.split(text, tokens, depth); err != nil {
+			return nil, false, l.line, err
+		}
+		if depth == 0 && len(tokens) > 0 {
+			return tokens, blank, start, nil
+		}
+	}
+	if err := l.scanner.Err(); err != nil {
+		return nil, false, l.line, err
+	}
+	if depth > 0 {
+		return nil, false, start, errors.New("unclosed parenthesis")
+	}
+	return nil, false, l.line, io.EOF
+}
+
+// split appends the tokens on one line and returns the new parenthesis depth.
+func (l *lexer) split(text string, tokens []token, depth int) ([]token, int, error) {
+	var b strings.Builder
+	inToken, quoted := false, false
+
+	flush := func() {
+		if inToken {
+			tokens = append(tokens, token{text: b.String(), quoted: quoted})
+		}
+		b.Reset()
+		inToken, quoted = false, false
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if quoted {
+			switch c {
+			case '"':
+				flush()
+			case '\\':
+				n, err := unescape(text[i:], &b)
+				if err != nil {
+					return nil, 0, err
+				}
+				i += n - 1
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case ';':
+			flush()
+			return tokens, depth, nil
+		case ' ', '\t', '\r':
+			flush()
+		case '(':
+			flush()
+			depth++
+		case ')':
+			flush()
+			if depth == 0 {
+				return nil, 0, errors.New("unbalanced closing parenthesis")
+			}
+			depth--
+		case '"':
+			flush()
+			inToken, quoted = true, true
+		case '\\':
+			// Keep the RFC 3597 generic RDATA marker as written
+			if !inToken && strings.HasPrefix(text[i:], `\#`) && (i+2 == len(text) || text[i+2] == ' ' || text[i+2] == '\t') {
+				b.WriteString(`\#`)
+				inToken = true
+				i++
+				continue
+			}
+			n, err := unescape(text[i:], &b)
+			if err != nil {
+				return nil, 0, err
+			}
+			inToken = true
+			i += n - 1
+		default:
+			b.WriteByte(c)
+			inToken = true
+		}
+	}
+	if quoted {
+		return nil, 0, errors.New("unterminated quoted string")
+	}
+	flush()
+	return tokens, depth, nil
+}
+
+// unescape decodes a \X or \DDD escape at the start of s, writing the byte
+// to b, and returns the number of bytes consumed.
+func unescape(s string, b *strings.Builder) (int, error) {
+	if len(s) < 2 {
+		return 0, errors.New("dangling backslash")
+	}
+	if s[1] < '0' || s[1] > '9' {
+		b.WriteByte(s[1])
+		return 2, nil
+	}
+	if len(s) < 4 {
+		return 0, fmt.Errorf("invalid escape %q", s)
+	}
+	v, err := strconv.ParseUint(s[1:4], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid escape %q", s[:4])
+	}
+	b.WriteByte(byte(v))
+	return 4, nil
+}
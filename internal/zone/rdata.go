@@ -0,0 +1,321 @@
+package zone
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// parseRData converts the presentation form of a record's RDATA to its
+// typed value. Any type may use the RFC 3597 generic form \# len hex.
+func parseRData(rtype uint16, tokens []token, origin string) (message.RData, error) {
+	if len(tokens) > 0 && tokens[0].text == `\#` && !tokens[0].quoted {
+		return parseGeneric(rtype, tokens[1:])
+	}
+
+	f := fields{tokens: tokens, origin: origin}
+	var rdata message.RData
+	switch rtype {
+	case message.TypeA:
+		addr := f.addr()
+		if f.err == nil && !addr.Is4() {
+			f.fail(fmt.Errorf("%s is not an IPv4 address", addr))
+		}
+		rdata = &message.A{Addr: addr}
+	case message.TypeAAAA:
+		addr := f.addr()
+		if f.err == nil && !addr.Is6() {
+			f.fail(fmt.Errorf("%s is not an IPv6 address", addr))
+		}
+		rdata = &message.AAAA{Addr: addr}
+	case message.TypeNS:
+		rdata = &message.NS{Host: f.name()}
+	case message.TypeCNAME:
+		rdata = &message.CNAME{Target: f.name()}
+	case message.TypePTR:
+		rdata = &message.PTR{Target: f.name()}
+	case message.TypeMX:
+		rdata = &message.MX{Preference: f.uint16(), Exchange: f.name()}
+	case message.TypeTXT:
+		var text []string
+		for len(f.tokens) > 0 {
+			text = append(text, f.next())
+		}
+		if len(text) == 0 {
+			f.fail(errors.New("missing text"))
+		}
+		rdata = &message.TXT{Text: text}
+	case message.TypeSOA:
+		rdata = &message.SOA{
+			MName:   f.name(),
+			RName:   f.name(),
+			Serial:  f.uint32(),
+			Refresh: f.ttl(),
+			Retry:   f.ttl(),
+			Expire:  f.ttl(),
+			Minimum: f.ttl(),
+		}
+	case message.TypeSRV:
+		rdata = &message.SRV{Priority: f.uint16(), Weight: f.uint16(), Port: f.uint16(), Target: f.name()}
+	case message.TypeCAA:
+		rdata = &message.CAA{Flag: f.uint8(), Tag: f.next(), Value: f.next()}
+	case message.TypeDNSKEY:
+		rdata = &message.DNSKEY{Flags: f.uint16(), Protocol: f.uint8(), Algorithm: f.uint8(), PublicKey: f.base64()}
+	case message.TypeRRSIG:
+		rdata = &message.RRSIG{
+			TypeCovered: f.rtype(),
+			Algorithm:   f.uint8(),
+			Labels:      f.uint8(),
+			OriginalTTL: f.uint32(),
+			Expiration:  f.sigTime(),
+			Inception:   f.sigTime(),
+			KeyTag:      f.uint16(),
+			SignerName:  f.name(),
+			Signature:   f.base64(),
+		}
+	case message.TypeDS:
+		rdata = &message.DS{KeyTag: f.uint16(), Algorithm: f.uint8(), DigestType: f.uint8(), Digest: f.hex()}
+	case message.TypeNSEC:
+		rdata = &message.NSEC{NextDomain: f.name(), Types: f.types()}
+	case message.TypeNSEC3:
+		rdata = &message.NSEC3{
+			HashAlgorithm: f.uint8(),
+			Flags:         f.uint8(),
+			Iterations:    f.uint16(),
+			Salt:          f.salt(),
+			NextHashed:    f.nsec3Hash(),
+			Types:         f.types(),
+		}
+	case message.TypeNSEC3PARAM:
+		rdata = &message.NSEC3PARAM{HashAlgorithm: f.uint8(), Flags: f.uint8(), Iterations: f.uint16(), Salt: f.salt()}
+	default:
+		return nil, fmt.Errorf("type %s needs RDATA in the \\# generic form", message.TypeString(rtype))
+	}
+	if err := f.end(); err != nil {
+		return nil, err
+	}
+	return rdata, nil
+}
+
+// parseGeneric decodes RFC 3597 §5 generic RDATA: a length followed by
+// the data in hex, which may be split across several fields.
+func parseGeneric(rtype uint16, tokens []token) (message.RData, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("missing RDATA length")
+	}
+	length, err := strconv.ParseUint(tokens[0].text, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDATA length %q", tokens[0].text)
+	}
+	var hexData strings.Builder
+	for _, t := range tokens[1:] {
+		hexData.WriteString(t.text)
+	}
+	data, err := hex.DecodeString(hexData.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid RDATA hex: %w", err)
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("RDATA length %d does not match %d bytes of data", length, len(data))
+	}
+	return message.DecodeRData(rtype, data)
+}
+
+// fields consumes RDATA tokens, keeping the first error so record parsers
+// can read every field before checking.
+type fields struct {
+	tokens []token
+	origin string
+	err    error
+}
+
+func (f *fields) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *fields) next() string {
+	if len(f.tokens) == 0 {
+		f.fail(errors.New("too few RDATA fields"))
+		return ""
+	}
+	t := f.tokens[0]
+	f.tokens = f.tokens[1:]
+	return t.text
+}
+
+func (f *fields) end() error {
+	if f.err == nil && len(f.tokens) > 0 {
+		return fmt.Errorf("unexpected RDATA field %q", f.tokens[0].text)
+	}
+	return f.err
+}
+
+func (f *fields) name() string {
+	s := f.next()
+	if f.err != nil {
+		return ""
+	}
+	name, err := absoluteName(s, f.origin)
+	if err != nil {
+		f.fail(err)
+	}
+	return name
+}
+
+func (f *fields) addr() netip.Addr {
+	s := f.next()
+	if f.err != nil {
+		return netip.Addr{}
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid address %q", s))
+	}
+	return addr
+}
+
+func (f *fields) uint(bits int) uint64 {
+	s := f.next()
+	if f.err != nil {
+		return 0
+	}
+	v, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid %d-bit number %q", bits, s))
+	}
+	return v
+}
+
+func (f *fields) uint8() uint8   { return uint8(f.uint(8)) }
+func (f *fields) uint16() uint16 { return uint16(f.uint(16)) }
+func (f *fields) uint32() uint32 { return uint32(f.uint(32)) }
+
+// ttl reads a time value, which may use BIND units like TTLs do.
+func (f *fields) ttl() uint32 {
+	s := f.next()
+	if f.err != nil {
+		return 0
+	}
+	v, err := parseTTL(s)
+	if err != nil {
+		f.fail(err)
+	}
+	return v
+}
+
+// rest joins the remaining fields, for base64 and hex data that may be
+// split by whitespace.
+func (f *fields) rest() string {
+	var b strings.Builder
+	for len(f.tokens) > 0 {
+		b.WriteString(f.next())
+	}
+	if b.Len() == 0 {
+		f.fail(errors.New("too few RDATA fields"))
+	}
+	return b.String()
+}
+
+func (f *fields) base64() []byte {
+	s := f.rest()
+	if f.err != nil {
+		return nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid base64: %w", err))
+	}
+	return b
+}
+
+func (f *fields) hex() []byte {
+	s := f.rest()
+	if f.err != nil {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid hex: %w", err))
+	}
+	return b
+}
+
+func (f *fields) rtype() uint16 {
+	s := f.next()
+	if f.err != nil {
+		return 0
+	}
+	t, err := parseType(s)
+	if err != nil {
+		f.fail(err)
+	}
+	return t
+}
+
+// types reads the type list of an NSEC or NSEC3 record, which may be
+// empty.
+func (f *fields) types() []uint16 {
+	var types []uint16
+	for len(f.tokens) > 0 {
+		types = append(types, f.rtype())
+	}
+	// Keep the order the type bitmap encodes them in
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// sigTime reads an RRSIG timestamp, either YYYYMMDDHHmmSS or seconds since
+// the epoch (RFC 4034 §3.2).
+func (f *fields) sigTime() uint32 {
+	s := f.next()
+	if f.err != nil {
+		return 0
+	}
+	if len(s) == 14 {
+		t, err := time.Parse("20060102150405", s)
+		if err != nil {
+			f.fail(fmt.Errorf("invalid signature time %q", s))
+		}
+		return uint32(t.Unix())
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid signature time %q", s))
+	}
+	return uint32(v)
+}
+
+// salt reads an NSEC3 salt in hex, or "-" for none.
+func (f *fields) salt() []byte {
+	s := f.next()
+	if f.err != nil || s == "-" {
+		return nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid salt %q", s))
+	}
+	return b
+}
+
+func (f *fields) nsec3Hash() []byte {
+	s := f.next()
+	if f.err != nil {
+		return nil
+	}
+	b, err := message.DecodeNSEC3Hash(s)
+	if err != nil {
+		f.fail(fmt.Errorf("invalid hashed owner name %q", s))
+	}
+	return b
+}
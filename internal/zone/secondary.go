@@ -0,0 +1,314 @@
+package zone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/resolver"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// initialRetry is how often a secondary retries its first transfer,
+	// before it has an SOA record to take the retry interval from.
+	initialRetry = 30 * time.Second
+
+	// minRefresh keeps a zone with tiny SOA timers from hammering its
+	// primary.
+	minRefresh = 5 * time.Second
+
+	// transferTimeout bounds each message of a zone transfer.
+	transferTimeout = 30 * time.Second
+)
+
+// Secondary keeps a copy of a zone pulled from its primary server
+// (RFC 1034 §4.3.5). It polls the primary's SOA record at the zone's
+// refresh interval, or the retry interval after a failure, and fetches
+// changes by IXFR, falling back to AXFR when it has no copy yet. A NOTIFY
+// (RFC 1996) triggers an immediate check. If the primary cannot be reached
+// for the zone's expire interval, the zone is withdrawn until it can.
+type Secondary struct {
+	origin  string
+	class   uint16
+	primary netip.AddrPort
+	store   *Store
+	client  *resolver.Client
+	log     *gotracer.Logger
+
+	// key, if set, signs every query to the primary and must sign its
+	// responses and NOTIFYs
+	key *message.TSIGKey
+
+	notify chan struct{}
+
+	// Only Run touches these
+	zone    *Zone
+	checked time.Time // last time the primary confirmed our serial
+}
+
+// SecondaryOption configures a Secondary.
+type SecondaryOption func(*Secondary)
+
+// WithTSIGKey makes the secondary sign its queries to the primary with key
+// (RFC 8945) and reject responses that are not signed with it.
+func WithTSIGKey(key message.TSIGKey) SecondaryOption {
+	return func(s *Secondary) {
+		s.key = &key
+	}
+}
+
+// NewSecondary creates a Secondary that transfers the zone at origin from
+// primary and serves it by adding it to store. Nothing happens until Run
+// is called.
+func NewSecondary(origin string, primary netip.AddrPort, store *Store, log *gotracer.Logger, opts ...SecondaryOption) *Secondary {
+	s := &Secondary{
+		origin:  trimDot(origin),
+		class:   message.ClassINET,
+		primary: primary,
+		store:   store,
+		client:  &resolver.Client{Timeout: transferTimeout},
+		log:     log,
+		notify:  make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Origin returns the name at the zone's apex.
+func (s *Secondary) Origin() string { return s.origin }
+
+// Primary returns the address of the server the zone is pulled from.
+func (s *Secondary) Primary() netip.AddrPort { return s.primary }
+
+// Key returns the TSIG key shared with the primary, if there is one.
+func (s *Secondary) Key() (message.TSIGKey, bool) {
+	if s.key == nil {
+		return message.TSIGKey{}, false
+	}
+	return *s.key, true
+}
+
+// Notify asks Run to check the primary for changes now. It never blocks;
+// notifications arriving while one is pending are merged.
+func (s *Secondary) Notify() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps the zone up to date until ctx is cancelled.
+func (s *Secondary) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(s.refresh(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// refresh checks the primary for a newer version of the zone and returns
+// how long to wait before the next check.
+func (s *Secondary) refresh(ctx context.Context) time.Duration {
+	err := s.update(ctx)
+	if err == nil {
+		s.checked = time.Now()
+		return s.interval(func(soa *message.SOA) uint32 { return soa.Refresh })
+	}
+	if ctx.Err() != nil {
+		return 0
+	}
+
+	s.log.Error.Printf("Failed to refresh zone %s from %s: %v", message.Fqdn(s.origin), s.primary, err)
+	if s.zone == nil {
+		return initialRetry
+	}
+	expire := time.Duration(s.soa().Expire) * time.Second
+	if time.Since(s.checked) >= expire {
+		s.log.Warnf("Zone expired, no longer serving it", map[string]interface{}{
+			"zone":    message.Fqdn(s.origin),
+			"primary": s.primary.String(),
+		})
+		s.store.Remove(s.origin)
+		s.zone = nil
+		return initialRetry
+	}
+	return min(s.interval(func(soa *message.SOA) uint32 { return soa.Retry }), time.Until(s.checked.Add(expire)))
+}
+
+// interval returns one of the timers from the zone's SOA record, or the
+// initial retry interval while there is no copy of the zone.
+func (s *Secondary) interval(timer func(*message.SOA) uint32) time.Duration {
+	if s.zone == nil {
+		return initialRetry
+	}
+	return max(time.Duration(timer(s.soa()))*time.Second, minRefresh)
+}
+
+func (s *Secondary) soa() *message.SOA {
+	soa, _ := s.zone.SOA().RData.(*message.SOA)
+	return soa
+}
+
+// update compares the primary's SOA serial with ours and transfers the
+// zone if the primary's is newer.
+func (s *Secondary) update(ctx context.Context) error {
+	query := message.Message{
+		Header:    message.Header{ID: message.RandomID()},
+		Questions: []message.Question{{Name: s.origin, Type: message.TypeSOA, Class: s.class}},
+	}
+	s.sign(&query)
+	response, err := s.client.Exchange(ctx, s.primary.String(), query)
+	if err != nil {
+		return err
+	}
+	if rcode := response.RCode(); rcode != message.RCodeSuccess || response.Header.AA == 0 {
+		return fmt.Errorf("primary is not authoritative (%s)", message.RCodeString(rcode))
+	}
+	var serial uint32
+	var found bool
+	for _, rr := range response.Answers {
+		if soa, ok := rr.RData.(*message.SOA); ok && rr.Type == message.TypeSOA && message.EqualNames(rr.Name, s.origin) {
+			serial, found = soa.Serial, true
+		}
+	}
+	if !found {
+		return errors.New("primary returned no SOA record")
+	}
+	if s.zone != nil && !SerialLess(Serial(s.zone.SOA()), serial) {
+		return nil
+	}
+	return s.transfer(ctx)
+}
+
+// transfer fetches the zone from the primary, incrementally if we already
+// have a copy, and installs the result.
+func (s *Secondary) transfer(ctx context.Context) error {
+	query := message.Message{
+		Header:    message.Header{ID: message.RandomID()},
+		Questions: []message.Question{{Name: s.origin, Type: message.TypeAXFR, Class: s.class}},
+	}
+	var current []message.Answer
+	if s.zone != nil {
+		current = s.zone.Records()
+		query.Questions[0].Type = message.TypeIXFR
+		query.Authority = []message.Answer{current[0]}
+	}
+	s.sign(&query)
+
+	stream, err := s.client.Transfer(ctx, s.primary.String(), query)
+	if err != nil {
+		return err
+	}
+	records, err := applyTransfer(current, stream)
+	if err != nil {
+		return fmt.Errorf("bad zone transfer: %w", err)
+	}
+	if records == nil {
+		if s.zone == nil {
+			return errors.New("primary sent no zone")
+		}
+		return nil
+	}
+
+	if s.zone == nil {
+		z, err := New(records)
+		if err != nil {
+			return err
+		}
+		if !message.EqualNames(z.origin, s.origin) {
+			return fmt.Errorf("primary sent zone %s", message.Fqdn(z.origin))
+		}
+		s.zone = z
+		s.store.Add(z)
+	} else if err := s.zone.Replace(records); err != nil {
+		return err
+	}
+	s.log.Info.Printf("Transferred zone %s from %s by %s, now at serial %d", message.Fqdn(s.origin), s.primary, message.TypeString(query.Questions[0].Type), Serial(s.zone.SOA()))
+	return nil
+}
+
+// sign attaches a signer for the primary's key to query, if there is one.
+// The client verifies the responses with it.
+func (s *Secondary) sign(query *message.Message) {
+	if s.key != nil {
+		query.SetTSIG(message.NewTSIGSigner(*s.key))
+	}
+}
+
+// applyTransfer returns the zone's new contents given the records of an
+// AXFR or IXFR response. current holds the zone's records when an IXFR
+// was sent. It returns nil if the response says the zone is unchanged.
+func applyTransfer(current, stream []message.Answer) ([]message.Answer, error) {
+	if len(stream) == 1 {
+		// A lone SOA answers an IXFR from a client that is up to date; an
+		// AXFR always ends with a second copy
+		if len(current) == 0 {
+			return nil, errors.New("transfer does not end with the zone's SOA record")
+		}
+		return nil, nil
+	}
+	final := Serial(stream[0])
+	if last := stream[len(stream)-1]; last.Type != message.TypeSOA || Serial(last) != final {
+		return nil, errors.New("transfer does not end with the zone's SOA record")
+	}
+
+	if stream[1].Type != message.TypeSOA || Serial(stream[1]) == final {
+		// AXFR form: the whole zone between two copies of the SOA
+		records := append([]message.Answer{stream[0]}, stream[1:len(stream)-1]...)
+		for _, rr := range records[1:] {
+			if rr.Type == message.TypeSOA {
+				return nil, errors.New("SOA record inside full transfer")
+			}
+		}
+		return records, nil
+	}
+
+	// Incremental form: a sequence of deltas, each the old SOA, the
+	// deleted records, the new SOA and the added records (RFC 1995 §4)
+	if len(current) == 0 {
+		return nil, errors.New("incremental transfer without a zone to apply it to")
+	}
+	records := make(map[string]message.Answer, len(current))
+	for _, rr := range current[1:] {
+		records[recordKey(rr)] = rr
+	}
+	serial := Serial(current[0])
+	i := 1
+	for i < len(stream)-1 {
+		if Serial(stream[i]) != serial {
+			return nil, fmt.Errorf("delta starts at serial %d, zone is at %d", Serial(stream[i]), serial)
+		}
+		for i++; i < len(stream) && stream[i].Type != message.TypeSOA; i++ {
+			delete(records, recordKey(stream[i]))
+		}
+		if i == len(stream)-1 {
+			return nil, errors.New("delta has no new SOA record")
+		}
+		serial = Serial(stream[i])
+		for i++; i < len(stream)-1 && stream[i].Type != message.TypeSOA; i++ {
+			records[recordKey(stream[i])] = stream[i]
+		}
+	}
+	if serial != final {
+		return nil, fmt.Errorf("deltas end at serial %d, not %d", serial, final)
+	}
+
+	result := make([]message.Answer, 0, len(records)+1)
+	result = append(result, stream[0])
+	for _, rr := range records {
+		result = append(result, rr)
+	}
+	return result, nil
+}
@@ -0,0 +1,187 @@
+package zone
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/internal/resolver"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+func testLogger() *gotracer.Logger {
+	log := gotracer.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// fakePrimary serves the SOA of a zone over UDP and transfers it over TCP
+// on the same loopback port, with perMessage records in each transfer
+// message.
+type fakePrimary struct {
+	addr       netip.AddrPort
+	perMessage int
+	records    []message.Answer // the zone, SOA first, sent as AXFR
+
+	// loneSOA makes every transfer just the opening SOA, as if the zone
+	// were up to date
+	loneSOA bool
+}
+
+func startPrimary(t *testing.T, perMessage int, records []message.Answer) *fakePrimary {
+	t.Helper()
+	var tcp net.Listener
+	var udp net.PacketConn
+	for {
+		var err error
+		if tcp, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if udp, err = net.ListenPacket("udp", tcp.Addr().String()); err == nil {
+			break
+		}
+		tcp.Close()
+	}
+	t.Cleanup(func() {
+		tcp.Close()
+		udp.Close()
+	})
+
+	p := &fakePrimary{
+		addr:       netip.MustParseAddrPort(tcp.Addr().String()),
+		perMessage: perMessage,
+		records:    records,
+	}
+	go p.serveUDP(udp)
+	go p.serveTCP(tcp)
+	return p
+}
+
+func (p *fakePrimary) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query, err := message.ParseMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		response := message.Message{
+			Header:    message.Header{ID: query.Header.ID, QR: 1, AA: 1},
+			Questions: query.Questions,
+			Answers:   p.records[:1],
+		}
+		conn.WriteTo(response.Encode(), addr)
+	}
+}
+
+func (p *fakePrimary) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			data, err := resolver.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			query, err := message.ParseMessage(data)
+			if err != nil {
+				return
+			}
+			stream := append(append([]message.Answer(nil), p.records...), p.records[0])
+			if p.loneSOA {
+				stream = p.records[:1]
+			}
+			for len(stream) > 0 {
+				n := min(p.perMessage, len(stream))
+				response := message.Message{
+					Header:    message.Header{ID: query.Header.ID, QR: 1, AA: 1},
+					Questions: query.Questions,
+					Answers:   stream[:n],
+				}
+				if resolver.WriteMessage(conn, response.Encode()) != nil {
+					return
+				}
+				stream = stream[n:]
+			}
+		}()
+	}
+}
+
+func testZoneRecords(t *testing.T) []message.Answer {
+	t.Helper()
+	records, err := Parse(strings.NewReader(`$ORIGIN example.com.
+$TTL 300
+@ SOA ns1 hostmaster 1 3600 900 604800 300
+@ NS ns1
+ns1 A 192.0.2.1
+www A 192.0.2.10
+`), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSecondaryTransfersOneRecordPerMessage(t *testing.T) {
+	records := testZoneRecords(t)
+	primary := startPrimary(t, 1, records)
+	store := NewStore()
+	s := NewSecondary("example.com", primary.addr, store, testLogger())
+
+	if wait := s.refresh(context.Background()); wait != time.Hour {
+		t.Errorf("refresh returned %v, want the SOA refresh interval", wait)
+	}
+	z, ok := store.Zone("example.com")
+	if !ok {
+		t.Fatal("zone not added to the store")
+	}
+	if got := len(z.Records()); got != len(records) {
+		t.Errorf("zone has %d records, want %d", got, len(records))
+	}
+}
+
+func TestSecondaryRejectsLoneSOAForAXFR(t *testing.T) {
+	records := testZoneRecords(t)
+	primary := startPrimary(t, 1, records)
+	primary.loneSOA = true
+	store := NewStore()
+	s := NewSecondary("example.com", primary.addr, store, testLogger())
+
+	// Without a copy of the zone, the secondary must keep retrying
+	if wait := s.refresh(context.Background()); wait != initialRetry {
+		t.Errorf("refresh returned %v, want %v", wait, initialRetry)
+	}
+	if _, ok := store.Zone("example.com"); ok {
+		t.Error("zone added to the store")
+	}
+}
+
+func TestApplyTransfer(t *testing.T) {
+	records := testZoneRecords(t)
+	soa := records[0]
+
+	if _, err := applyTransfer(nil, []message.Answer{soa}); err == nil {
+		t.Error("lone SOA without a zone: no error")
+	}
+	if got, err := applyTransfer(records, []message.Answer{soa}); err != nil || got != nil {
+		t.Errorf("lone SOA answering IXFR = %v, %v; want unchanged", got, err)
+	}
+	got, err := applyTransfer(nil, append(append([]message.Answer(nil), records...), soa))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) {
+		t.Errorf("AXFR gave %d records, want %d", len(got), len(records))
+	}
+}
@@ -0,0 +1,309 @@
+package zone
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+	"github.com/codecrafters-io/dns-server-starter-go/pkg/gotracer"
+)
+
+const (
+	// DefaultSignatureValidity is how long new signatures are valid for.
+	DefaultSignatureValidity = 14 * 24 * time.Hour
+
+	// inceptionSkew backdates new signatures for validators whose clocks
+	// run behind ours.
+	inceptionSkew = time.Hour
+
+	// maxResignInterval bounds how long Run waits between checks for
+	// signatures that are due for renewal.
+	maxResignInterval = time.Hour
+)
+
+// Signer keeps a zone signed with a key (RFC 4035 §2). Every version of
+// the zone, whether loaded, reloaded or changed by a dynamic update, is
+// signed before it is served: the signer publishes the key's DNSKEY at
+// the apex, builds an NSEC or NSEC3 chain and signs every authoritative
+// RRset. Signatures are reused while the RRset they cover is unchanged
+// and they have more than half their validity left, and Run renews them
+// before then, so they never expire while the zone is served.
+type Signer struct {
+	zone     *Zone
+	key      *Key
+	log      *gotracer.Logger
+	validity time.Duration
+
+	// nsec3, if set, makes the signer deny existence with NSEC3 rather
+	// than NSEC
+	nsec3 *message.NSEC3PARAM
+}
+
+// SignerOption configures a Signer.
+type SignerOption func(*Signer)
+
+// WithNSEC3 makes the signer build an NSEC3 chain (RFC 5155), which does
+// not let clients walk the zone's names as NSEC does. RFC 9276 advises
+// against extra iterations and salt, which cost resolvers more than they
+// cost anyone enumerating the zone.
+func WithNSEC3(iterations uint16, salt []byte) SignerOption {
+	return func(s *Signer) {
+		s.nsec3 = &message.NSEC3PARAM{HashAlgorithm: message.NSEC3HashSHA1, Iterations: iterations, Salt: salt}
+	}
+}
+
+// WithSignatureValidity sets how long new signatures are valid for,
+// DefaultSignatureValidity by default.
+func WithSignatureValidity(d time.Duration) SignerOption {
+	return func(s *Signer) {
+		s.validity = d
+	}
+}
+
+// NewSigner signs z with key and keeps signing every new version of it.
+// Call Run to have signatures renewed before they expire.
+func NewSigner(z *Zone, key *Key, log *gotracer.Logger, opts ...SignerOption) (*Signer, error) {
+	s := &Signer{zone: z, key: key, log: log, validity: DefaultSignatureValidity}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	next, err := New(z.records())
+	if err != nil {
+		return nil, err
+	}
+	z.signer = s
+	if err := z.swap(next); err != nil {
+		z.signer = nil
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run renews signatures that are due until ctx is cancelled. Each renewal
+// bumps the zone's serial, so secondaries pick up the new signatures.
+func (s *Signer) Run(ctx context.Context) {
+	interval := min(s.validity/8, maxResignInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		renewed, err := s.zone.resign()
+		if err != nil {
+			s.log.Error.Printf("Failed to re-sign zone %s: %v", message.Fqdn(s.zone.origin), err)
+		} else if renewed {
+			s.log.Info.Printf("Re-signed zone %s at serial %d", message.Fqdn(s.zone.origin), Serial(s.zone.SOA()))
+		}
+	}
+}
+
+// resign renews the zone's signatures that are due, bumping the serial
+// if any were. It reports whether the zone changed.
+func (z *Zone) resign() (bool, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	next, err := New(z.records())
+	if err != nil {
+		return false, err
+	}
+	if err := z.signer.sign(next, z); err != nil {
+		return false, err
+	}
+	if deleted, added := diff(z.records(), next.records()); len(deleted) == 0 && len(added) == 0 {
+		return false, nil
+	}
+
+	soa := next.soa()
+	rdata := *soa.RData.(*message.SOA)
+	rdata.Serial++
+	soa.RData = &rdata
+	next.nodes[strings.ToLower(next.origin)].rrsets[message.TypeSOA] = []message.Answer{soa}
+	return true, z.swap(next)
+}
+
+// sign replaces the DNSSEC records in next, which must not yet be shared,
+// with a fresh DNSKEY, denial chain and signatures. Signatures in prev,
+// the version being replaced, are kept where they still hold. prev.mu
+// must be held.
+func (s *Signer) sign(next, prev *Zone) error {
+	// What the signer generates is rebuilt from scratch; DNSKEY records
+	// other than its own, such as keys being rolled in, are kept
+	for key, n := range next.nodes {
+		for _, t := range sortedTypes(n.rrsets) {
+			if isDNSSECType(t) || t == message.TypeNSEC3PARAM {
+				next.remove(key, t, func(message.Answer) bool { return true })
+			}
+		}
+	}
+
+	soa := next.soa()
+	next.add(message.Answer{Name: next.origin, Type: message.TypeDNSKEY, Class: next.class, TTL: soa.TTL, RData: s.key.DNSKEY()})
+	if s.nsec3 != nil {
+		params := *s.nsec3
+		next.add(message.Answer{Name: next.origin, Type: message.TypeNSEC3PARAM, Class: next.class, RData: &params})
+	}
+
+	owners := next.authoritative()
+	if s.nsec3 != nil {
+		s.addNSEC3(next, owners)
+	} else {
+		s.addNSEC(next, owners)
+	}
+
+	now := time.Now()
+	var sigs []message.Answer
+	for _, owner := range next.authoritative() {
+		n := next.nodes[owner]
+		_, cut := n.rrsets[message.TypeNS]
+		cut = cut && owner != strings.ToLower(next.origin)
+		for _, t := range sortedTypes(n.rrsets) {
+			// A zone cut's NS RRset and glue belong to the child zone
+			if cut && t != message.TypeDS && t != message.TypeNSEC {
+				continue
+			}
+			sig, err := s.signature(n.rrsets[t], prev, now)
+			if err != nil {
+				return err
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	for _, sig := range sigs {
+		next.add(sig)
+	}
+	next.indexChain()
+	return nil
+}
+
+// signature returns an RRSIG record for rrset, reusing the one in prev
+// if it covers the same records and is not yet due for renewal.
+func (s *Signer) signature(rrset []message.Answer, prev *Zone, now time.Time) (message.Answer, error) {
+	owner, rtype := rrset[0].Name, rrset[0].Type
+	if old, ok := prev.nodes[strings.ToLower(owner)]; ok && sameRecords(old.rrsets[rtype], rrset) {
+		for _, rr := range signatures(old.rrsets, rtype) {
+			sig := rr.RData.(*message.RRSIG)
+			remaining := time.Duration(int32(sig.Expiration-uint32(now.Unix()))) * time.Second
+			if sig.KeyTag == s.key.KeyTag() && sig.Algorithm == s.key.Algorithm() && remaining > s.validity/2 {
+				return rr, nil
+			}
+		}
+	}
+
+	sig := &message.RRSIG{
+		TypeCovered: rtype,
+		Algorithm:   s.key.Algorithm(),
+		Labels:      uint8(message.CountLabels(owner)),
+		OriginalTTL: rrset[0].TTL,
+		Expiration:  uint32(now.Add(s.validity).Unix()),
+		Inception:   uint32(now.Add(-inceptionSkew).Unix()),
+		KeyTag:      s.key.KeyTag(),
+		SignerName:  s.zone.origin,
+	}
+	signature, err := s.key.sign(sig.SignedData(rrset))
+	if err != nil {
+		return message.Answer{}, fmt.Errorf("failed to sign %s %s: %w", message.Fqdn(owner), message.TypeString(rtype), err)
+	}
+	sig.Signature = signature
+	return message.Answer{Name: owner, Type: message.TypeRRSIG, Class: rrset[0].Class, TTL: rrset[0].TTL, RData: sig}, nil
+}
+
+// addNSEC links owners, in canonical order, into an NSEC chain (RFC 4035
+// §2.3). Each NSEC lists the types at its owner, including the RRSIG and
+// NSEC records the signer adds there.
+func (s *Signer) addNSEC(z *Zone, owners []string) {
+	sort.Slice(owners, func(i, j int) bool { return message.CompareNames(owners[i], owners[j]) < 0 })
+	ttl := z.negativeSOA().TTL
+	for i, owner := range owners {
+		n := z.nodes[owner]
+		next := z.nodes[owners[(i+1)%len(owners)]]
+		types := append(sortedTypes(n.rrsets), message.TypeRRSIG, message.TypeNSEC)
+		z.add(message.Answer{Name: n.name, Type: message.TypeNSEC, Class: z.class, TTL: ttl, RData: &message.NSEC{NextDomain: next.name, Types: types}})
+	}
+}
+
+// addNSEC3 links the hashes of owners and of the empty non-terminals
+// above them into an NSEC3 chain (RFC 5155 §7.1). Each NSEC3 lists the
+// types at the name it was hashed from, RRSIG included when something
+// there will be signed.
+func (s *Signer) addNSEC3(z *Zone, owners []string) {
+	type hashed struct {
+		hash  []byte
+		types []uint16
+	}
+	origin := strings.ToLower(z.origin)
+	seen := make(map[string]bool)
+	var chain []hashed
+	for _, owner := range owners {
+		for _, name := range append([]string{owner}, z.ancestors(owner)...) {
+			if seen[name] {
+				break
+			}
+			seen[name] = true
+
+			var types []uint16
+			if n, ok := z.nodes[name]; ok {
+				types = sortedTypes(n.rrsets)
+				_, cut := n.rrsets[message.TypeNS]
+				_, ds := n.rrsets[message.TypeDS]
+				if !cut || name == origin || ds {
+					types = append(types, message.TypeRRSIG)
+				}
+			}
+			chain = append(chain, hashed{message.HashName(name, s.nsec3.Iterations, s.nsec3.Salt), types})
+		}
+	}
+	sort.Slice(chain, func(i, j int) bool { return string(chain[i].hash) < string(chain[j].hash) })
+
+	ttl := z.negativeSOA().TTL
+	for i, h := range chain {
+		owner := message.EncodeNSEC3Hash(h.hash)
+		if origin != "" {
+			owner += "." + z.origin
+		}
+		z.add(message.Answer{Name: owner, Type: message.TypeNSEC3, Class: z.class, TTL: ttl, RData: &message.NSEC3{
+			HashAlgorithm: s.nsec3.HashAlgorithm,
+			Iterations:    s.nsec3.Iterations,
+			Salt:          s.nsec3.Salt,
+			NextHashed:    chain[(i+1)%len(chain)].hash,
+			Types:         h.types,
+		}})
+	}
+}
+
+// authoritative returns the names the zone holds authoritative data for,
+// zone cuts included but not the glue below them. z.mu must be held.
+func (z *Zone) authoritative() []string {
+	var owners []string
+	for key := range z.nodes {
+		if cut, ok := z.delegation(key); !ok || strings.ToLower(cut.name) == key {
+			owners = append(owners, key)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// sameRecords reports whether a and b hold the same records, TTLs
+// included, in any order.
+func sameRecords(a, b []message.Answer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := recordSet(a)
+	for _, rr := range b {
+		if _, ok := set[recordKey(rr)]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,354 @@
+package zone
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+const signedZoneText = `$ORIGIN example.com.
+$TTL 300
+@ SOA ns1 hostmaster 1 3600 900 604800 60
+@ NS ns1
+ns1 A 192.0.2.1
+www A 192.0.2.10
+*.wild A 192.0.2.20
+a.b.empty A 192.0.2.30
+sub NS ns.sub
+ns.sub A 192.0.2.40
+secure NS ns1
+secure DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF
+`
+
+// signedZone loads signedZoneText and signs it with a new key for
+// algorithm.
+func signedZone(t *testing.T, algorithm uint8, opts ...SignerOption) (*Zone, *Signer, *Key) {
+	t.Helper()
+	records, err := Parse(strings.NewReader(signedZoneText), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := New(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSigner(z, key, testLogger(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z, s, key
+}
+
+// rrsetsOf groups records by owner name and type, leaving out RRSIGs,
+// and gathers the RRSIGs by the RRset they cover.
+func rrsetsOf(records []message.Answer) (rrsets, sigs map[string][]message.Answer) {
+	rrsets, sigs = make(map[string][]message.Answer), make(map[string][]message.Answer)
+	for _, rr := range records {
+		if sig, ok := rr.RData.(*message.RRSIG); ok {
+			key := strings.ToLower(rr.Name) + " " + message.TypeString(sig.TypeCovered)
+			sigs[key] = append(sigs[key], rr)
+			continue
+		}
+		key := strings.ToLower(rr.Name) + " " + message.TypeString(rr.Type)
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	return rrsets, sigs
+}
+
+// verifySignatures checks every RRSIG in records against the RRset it
+// covers, which must be among records too.
+func verifySignatures(t *testing.T, key *Key, records []message.Answer) {
+	t.Helper()
+	rrsets, sigs := rrsetsOf(records)
+	for name, rrs := range sigs {
+		for _, rr := range rrs {
+			sig := rr.RData.(*message.RRSIG)
+			if err := sig.Verify(key.DNSKEY(), rrsets[name]); err != nil {
+				t.Errorf("signature over %s: %v", name, err)
+			}
+			if err := sig.ValidAt(time.Now()); err != nil {
+				t.Errorf("signature over %s: %v", name, err)
+			}
+		}
+	}
+}
+
+var signerModes = []struct {
+	name      string
+	algorithm uint8
+	opts      []SignerOption
+}{
+	{"NSEC/ECDSA", message.AlgECDSAP256SHA256, nil},
+	{"NSEC/Ed25519", message.AlgED25519, nil},
+	{"NSEC3/ECDSA", message.AlgECDSAP256SHA256, []SignerOption{WithNSEC3(0, nil)}},
+	{"NSEC3/Ed25519", message.AlgED25519, []SignerOption{WithNSEC3(2, []byte{0xaa, 0xbb})}},
+}
+
+func TestSignZone(t *testing.T) {
+	// The delegations' NS RRsets and glue belong to the child zones
+	unsigned := map[string]bool{
+		"sub.example.com NS":    true,
+		"ns.sub.example.com A":  true,
+		"secure.example.com NS": true,
+	}
+	for _, mode := range signerModes {
+		t.Run(mode.name, func(t *testing.T) {
+			z, _, key := signedZone(t, mode.algorithm, mode.opts...)
+			records := z.Records()
+			rrsets, sigs := rrsetsOf(records)
+
+			if dnskey := rrsets["example.com DNSKEY"]; len(dnskey) != 1 || !bytes.Equal(message.PackRData(dnskey[0].RData), message.PackRData(key.DNSKEY())) {
+				t.Errorf("apex DNSKEY = %v", dnskey)
+			}
+			if _, ok := rrsets["example.com NSEC3PARAM"]; ok != (mode.opts != nil) {
+				t.Errorf("NSEC3PARAM at the apex: %v", ok)
+			}
+			for name := range rrsets {
+				if signed := len(sigs[name]) > 0; signed == unsigned[name] {
+					t.Errorf("%s signed: %v", name, signed)
+				}
+			}
+			verifySignatures(t, key, records)
+
+			// The chain links every name in a loop
+			chainType := message.TypeNSEC
+			if mode.opts != nil {
+				chainType = message.TypeNSEC3
+			}
+			next := make(map[string]string)
+			for _, rr := range records {
+				switch r := rr.RData.(type) {
+				case *message.NSEC:
+					next[strings.ToLower(rr.Name)] = strings.ToLower(r.NextDomain)
+				case *message.NSEC3:
+					next[strings.ToLower(rr.Name)] = message.EncodeNSEC3Hash(r.NextHashed) + ".example.com"
+				}
+			}
+			// NSEC: the apex, ns1, www, *.wild, a.b.empty, sub and secure;
+			// NSEC3 adds the empty non-terminals wild, b.empty and empty
+			want := 7
+			if chainType == message.TypeNSEC3 {
+				want = 10
+			}
+			if len(next) != want {
+				t.Fatalf("%d %s records, want %d", len(next), message.TypeString(chainType), want)
+			}
+			var start string
+			for start = range next {
+				break
+			}
+			name := start
+			for i := 0; i < len(next); i++ {
+				name = next[name]
+			}
+			if name != start {
+				t.Errorf("%s chain does not loop through all %d records", message.TypeString(chainType), len(next))
+			}
+		})
+	}
+}
+
+// covers reports whether the NSEC or NSEC3 record rr proves that name
+// does not exist, and matches whether rr is the record for name itself.
+func covers(rr message.Answer, name string) (covered, matched bool) {
+	switch r := rr.RData.(type) {
+	case *message.NSEC:
+		owner, next := rr.Name, r.NextDomain
+		if message.EqualNames(owner, name) {
+			return false, true
+		}
+		after := message.CompareNames(owner, name) < 0
+		before := message.CompareNames(name, next) < 0
+		if message.CompareNames(next, owner) <= 0 {
+			return after || before, false
+		}
+		return after && before, false
+	case *message.NSEC3:
+		owner, err := message.DecodeNSEC3Hash(strings.SplitN(rr.Name, ".", 2)[0])
+		if err != nil {
+			return false, false
+		}
+		hash := message.HashName(name, r.Iterations, r.Salt)
+		if bytes.Equal(owner, hash) {
+			return false, true
+		}
+		after := bytes.Compare(owner, hash) < 0
+		before := bytes.Compare(hash, r.NextHashed) < 0
+		if bytes.Compare(r.NextHashed, owner) <= 0 {
+			return after || before, false
+		}
+		return after && before, false
+	}
+	return false, false
+}
+
+func TestSignedLookup(t *testing.T) {
+	type proof struct {
+		name    string
+		matched bool // proven by the record for name, not one covering it
+	}
+	tests := []struct {
+		name    string
+		qtype   uint16
+		nsec3   bool
+		rcode   int
+		answers int // records in the answer, signatures included
+		proofs  []proof
+	}{
+		{"missing.example.com", message.TypeA, false, message.RCodeNameError, 0,
+			[]proof{{"missing.example.com", false}, {"*.example.com", false}}},
+		{"www.example.com", message.TypeAAAA, false, message.RCodeSuccess, 0, []proof{{"www.example.com", true}}},
+		{"b.empty.example.com", message.TypeA, false, message.RCodeSuccess, 0, []proof{{"b.empty.example.com", false}}},
+		{"x.wild.example.com", message.TypeA, false, message.RCodeSuccess, 2, []proof{{"x.wild.example.com", false}}},
+		{"x.wild.example.com", message.TypeAAAA, false, message.RCodeSuccess, 0,
+			[]proof{{"x.wild.example.com", false}, {"*.wild.example.com", true}}},
+		{"www.sub.example.com", message.TypeA, false, message.RCodeSuccess, 0, []proof{{"sub.example.com", true}}},
+
+		// Closest encloser, next closer name and wildcard (RFC 5155 §7.2.2)
+		{"missing.example.com", message.TypeA, true, message.RCodeNameError, 0,
+			[]proof{{"example.com", true}, {"missing.example.com", false}, {"*.example.com", false}}},
+		{"www.example.com", message.TypeAAAA, true, message.RCodeSuccess, 0, []proof{{"www.example.com", true}}},
+		{"b.empty.example.com", message.TypeA, true, message.RCodeSuccess, 0, []proof{{"b.empty.example.com", true}}},
+		{"x.wild.example.com", message.TypeA, true, message.RCodeSuccess, 2, []proof{{"x.wild.example.com", false}}},
+		{"www.sub.example.com", message.TypeA, true, message.RCodeSuccess, 0, []proof{{"sub.example.com", true}}},
+	}
+	zones := map[bool]*Zone{}
+	keys := map[bool]*Key{}
+	zones[false], _, keys[false] = signedZone(t, message.AlgED25519)
+	zones[true], _, keys[true] = signedZone(t, message.AlgED25519, WithNSEC3(0, nil))
+
+	for _, tt := range tests {
+		mode := "NSEC"
+		if tt.nsec3 {
+			mode = "NSEC3"
+		}
+		t.Run(mode+"/"+tt.name+"/"+message.TypeString(tt.qtype), func(t *testing.T) {
+			response := zones[tt.nsec3].Lookup(message.Question{Name: tt.name, Type: tt.qtype, Class: message.ClassINET}, true)
+			if rcode := response.RCode(); rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", message.RCodeString(rcode), message.RCodeString(tt.rcode))
+			}
+			if len(response.Answers) != tt.answers {
+				t.Errorf("answers = %v, want %d records", response.Answers, tt.answers)
+			}
+			all := append(append(append([]message.Answer(nil), response.Answers...), response.Authority...), response.Additional...)
+			verifySignatures(t, keys[tt.nsec3], all)
+
+			for _, p := range tt.proofs {
+				found := false
+				for _, rr := range response.Authority {
+					covered, matched := covers(rr, p.name)
+					if (p.matched && matched) || (!p.matched && covered) {
+						found = true
+						// A matching record must not list the type asked for
+						if matched && tt.answers == 0 {
+							for _, rtype := range typesOf(rr) {
+								if rtype == tt.qtype || rtype == message.TypeCNAME {
+									t.Errorf("%s lists %s", rr.Name, message.TypeString(rtype))
+								}
+							}
+						}
+					}
+				}
+				if !found {
+					t.Errorf("authority %v does not prove %s (matched %v)", response.Authority, p.name, p.matched)
+				}
+			}
+		})
+	}
+
+	// Without DO the same answers carry no DNSSEC records
+	response := zones[false].Lookup(message.Question{Name: "missing.example.com", Type: message.TypeA, Class: message.ClassINET}, false)
+	if len(response.Authority) != 1 || response.Authority[0].Type != message.TypeSOA {
+		t.Errorf("authority without DO = %v, want the SOA alone", response.Authority)
+	}
+	response = zones[false].Lookup(message.Question{Name: "example.com", Type: message.TypeANY, Class: message.ClassINET}, false)
+	for _, rr := range response.Answers {
+		if isDNSSECType(rr.Type) {
+			t.Errorf("ANY without DO returned %s", message.TypeString(rr.Type))
+		}
+	}
+}
+
+func typesOf(rr message.Answer) []uint16 {
+	switch r := rr.RData.(type) {
+	case *message.NSEC:
+		return r.Types
+	case *message.NSEC3:
+		return r.Types
+	}
+	return nil
+}
+
+func TestResign(t *testing.T) {
+	z, s, key := signedZone(t, message.AlgECDSAP256SHA256, WithSignatureValidity(4*time.Hour))
+	before := z.Records()
+
+	// Signatures with more than half their validity left are kept
+	if renewed, err := z.resign(); err != nil || renewed {
+		t.Fatalf("resign = %v, %v; want nothing renewed", renewed, err)
+	}
+
+	// Once they are due, every signature is made anew and the serial moves
+	s.validity = 10 * time.Hour
+	renewed, err := z.resign()
+	if err != nil || !renewed {
+		t.Fatalf("resign = %v, %v; want the signatures renewed", renewed, err)
+	}
+	if got := Serial(z.SOA()); got != 2 {
+		t.Errorf("serial = %d, want 2", got)
+	}
+	after := z.Records()
+	verifySignatures(t, key, after)
+	_, oldSigs := rrsetsOf(before)
+	_, newSigs := rrsetsOf(after)
+	for name, sigs := range newSigs {
+		old := oldSigs[name]
+		if len(old) > 0 && old[0].RData.(*message.RRSIG).Expiration >= sigs[0].RData.(*message.RRSIG).Expiration {
+			t.Errorf("signature over %s not renewed", name)
+		}
+	}
+
+	// A change to the zone is signed before it is served
+	records := append(z.Records(), message.Answer{Name: "new.example.com", Type: message.TypeA, Class: message.ClassINET, TTL: 300,
+		RData: &message.A{Addr: netip.MustParseAddr("192.0.2.99")}})
+	if err := z.Replace(records); err != nil {
+		t.Fatal(err)
+	}
+	response := z.Lookup(message.Question{Name: "new.example.com", Type: message.TypeA, Class: message.ClassINET}, true)
+	if len(response.Answers) != 2 {
+		t.Fatalf("answers = %v, want the record and its signature", response.Answers)
+	}
+	verifySignatures(t, key, response.Answers)
+}
+
+func TestLoadKey(t *testing.T) {
+	for _, algorithm := range []uint8{message.AlgECDSAP256SHA256, message.AlgED25519} {
+		t.Run(fmt.Sprint(algorithm), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "zone.key")
+			created, err := LoadKey(path, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := LoadKey(path, algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.Algorithm() != algorithm || !bytes.Equal(loaded.DNSKEY().PublicKey, created.DNSKEY().PublicKey) {
+				t.Errorf("reloaded key %v, created %v", loaded.DNSKEY(), created.DNSKEY())
+			}
+		})
+	}
+	if _, err := GenerateKey(message.AlgRSASHA256); err == nil {
+		t.Error("GenerateKey accepted RSA")
+	}
+}
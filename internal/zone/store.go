@@ -0,0 +1,75 @@
+package zone
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store holds the zones a server is authoritative for. It is safe for
+// concurrent use.
+type Store struct {
+	mu    sync.RWMutex
+	zones map[string]*Zone // keyed by lowercased origin
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{zones: make(map[string]*Zone)}
+}
+
+// Add adds z, replacing any zone with the same origin.
+func (s *Store) Add(z *Zone) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[strings.ToLower(z.origin)] = z
+}
+
+// Remove drops the zone with the given origin.
+func (s *Store) Remove(origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.zones, strings.ToLower(trimDot(origin)))
+}
+
+// Zone returns the zone whose origin is exactly origin.
+func (s *Store) Zone(origin string) (*Zone, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	z, ok := s.zones[strings.ToLower(trimDot(origin))]
+	return z, ok
+}
+
+// Find returns the most specific zone containing name.
+func (s *Store) Find(name string) (*Zone, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name = strings.ToLower(trimDot(name))
+	for {
+		if z, ok := s.zones[name]; ok {
+			return z, true
+		}
+		if name == "" {
+			return nil, false
+		}
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		} else {
+			name = ""
+		}
+	}
+}
+
+// Zones returns every zone, sorted by origin.
+func (s *Store) Zones() []*Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zones := make([]*Zone, 0, len(s.zones))
+	for _, z := range s.zones {
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].origin < zones[j].origin })
+	return zones
+}
@@ -0,0 +1,287 @@
+package zone
+
+import (
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// rrsetKey identifies an RRset by lowercased owner name and type.
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
+
+// Update applies a dynamic update (RFC 2136 §3) and returns the response
+// code. prereqs and updates are the prerequisite and update sections of
+// the UPDATE message. Nothing changes unless every prerequisite holds and
+// every update record is well formed. An update that changes the zone
+// bumps the SOA serial unless it sets a newer one itself, so the change
+// is journaled for IXFR like any other.
+func (z *Zone) Update(prereqs, updates []message.Answer) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if rcode := z.checkPrerequisites(prereqs); rcode != message.RCodeSuccess {
+		return rcode
+	}
+	if rcode := z.prescan(updates); rcode != message.RCodeSuccess {
+		return rcode
+	}
+
+	next, err := New(z.records())
+	if err != nil {
+		return message.RCodeServerFailure
+	}
+	changed := false
+	for _, rr := range updates {
+		if next.apply(rr) {
+			changed = true
+		}
+	}
+	if !changed {
+		return message.RCodeSuccess
+	}
+
+	oldSOA := z.soa()
+	if newSOA := next.soa(); !SerialLess(Serial(oldSOA), Serial(newSOA)) {
+		rdata := *newSOA.RData.(*message.SOA)
+		rdata.Serial = Serial(oldSOA) + 1
+		newSOA.RData = &rdata
+		next.nodes[strings.ToLower(next.origin)].rrsets[message.TypeSOA] = []message.Answer{newSOA}
+	}
+	if err := z.swap(next); err != nil {
+		return message.RCodeServerFailure
+	}
+	return message.RCodeSuccess
+}
+
+// checkPrerequisites tests the prerequisites of an update against the
+// zone (RFC 2136 §3.2). z.mu must be held.
+func (z *Zone) checkPrerequisites(prereqs []message.Answer) int {
+	// Value-dependent prerequisites must match whole RRsets, so they are
+	// gathered before being compared
+	want := make(map[rrsetKey][]message.Answer)
+	for _, rr := range prereqs {
+		if rr.TTL != 0 {
+			return message.RCodeFormatError
+		}
+		if !message.IsSubdomain(rr.Name, z.origin) {
+			return message.RCodeNotZone
+		}
+		key := strings.ToLower(rr.Name)
+		n, inUse := z.nodes[key]
+
+		switch rr.Class {
+		case message.ClassANY:
+			if rr.RData != nil {
+				return message.RCodeFormatError
+			}
+			if rr.Type == message.TypeANY {
+				if !inUse {
+					return message.RCodeNameError
+				}
+			} else if !inUse || len(n.rrsets[rr.Type]) == 0 {
+				return message.RCodeNXRRSet
+			}
+		case message.ClassNONE:
+			if rr.RData != nil {
+				return message.RCodeFormatError
+			}
+			if rr.Type == message.TypeANY {
+				if inUse {
+					return message.RCodeYXDomain
+				}
+			} else if inUse && len(n.rrsets[rr.Type]) > 0 {
+				return message.RCodeYXRRSet
+			}
+		case z.class:
+			k := rrsetKey{key, rr.Type}
+			want[k] = append(want[k], rr)
+		default:
+			return message.RCodeFormatError
+		}
+	}
+
+	for k, rrset := range want {
+		if !sameRRset(z.rrset(k.name, k.rtype), rrset) {
+			return message.RCodeNXRRSet
+		}
+	}
+	return message.RCodeSuccess
+}
+
+// prescan checks that every record of the update section is well formed
+// and inside the zone (RFC 2136 §3.4.1). The records a signer maintains
+// in a signed zone cannot be updated (RFC 3007 §4.3). z.mu must be held.
+func (z *Zone) prescan(updates []message.Answer) int {
+	for _, rr := range updates {
+		if !message.IsSubdomain(rr.Name, z.origin) {
+			return message.RCodeNotZone
+		}
+		if z.signer != nil && (isDNSSECType(rr.Type) || rr.Type == message.TypeNSEC3PARAM) {
+			return message.RCodeRefused
+		}
+		switch rr.Class {
+		case z.class:
+			// Records to add need RDATA of their type
+			if isMetaType(rr.Type) || message.CheckRData(rr.Type, rr.RData) != nil {
+				return message.RCodeFormatError
+			}
+		case message.ClassANY:
+			if rr.TTL != 0 || rr.RData != nil || (isMetaType(rr.Type) && rr.Type != message.TypeANY) {
+				return message.RCodeFormatError
+			}
+		case message.ClassNONE:
+			if rr.TTL != 0 || isMetaType(rr.Type) {
+				return message.RCodeFormatError
+			}
+		default:
+			return message.RCodeFormatError
+		}
+	}
+	return message.RCodeSuccess
+}
+
+// apply makes one change from the update section (RFC 2136 §3.4.2) and
+// reports whether the zone changed. Records of the zone's class are
+// added, class ANY deletes RRsets and class NONE deletes single records.
+// The apex SOA and NS RRsets can be changed but never removed, and
+// updates that would put a CNAME beside other data are ignored. z must
+// not yet be shared.
+func (z *Zone) apply(rr message.Answer) bool {
+	key := strings.ToLower(rr.Name)
+	apex := key == strings.ToLower(z.origin)
+	var rrsets map[uint16][]message.Answer
+	data := 0
+	if n, ok := z.nodes[key]; ok {
+		rrsets, data = n.rrsets, n.dataTypes()
+	}
+
+	switch rr.Class {
+	case message.ClassANY:
+		if rr.Type != message.TypeANY {
+			if apex && (rr.Type == message.TypeSOA || rr.Type == message.TypeNS) {
+				return false
+			}
+			return z.remove(key, rr.Type, func(message.Answer) bool { return true })
+		}
+		changed := false
+		for _, t := range sortedTypes(rrsets) {
+			if apex && (t == message.TypeSOA || t == message.TypeNS) {
+				continue
+			}
+			if z.remove(key, t, func(message.Answer) bool { return true }) {
+				changed = true
+			}
+		}
+		return changed
+
+	case message.ClassNONE:
+		if rr.Type == message.TypeSOA {
+			return false
+		}
+		if apex && rr.Type == message.TypeNS && len(rrsets[message.TypeNS]) == 1 {
+			return false
+		}
+		return z.remove(key, rr.Type, func(existing message.Answer) bool {
+			return equalRData(existing.RData, rr.RData)
+		})
+	}
+
+	_, hasCNAME := rrsets[message.TypeCNAME]
+	switch {
+	case rr.Type == message.TypeCNAME && data > 0 && !hasCNAME:
+		return false
+	case rr.Type != message.TypeCNAME && hasCNAME:
+		return false
+	case rr.Type == message.TypeSOA:
+		if !apex || !SerialLess(Serial(rrsets[message.TypeSOA][0]), Serial(rr)) {
+			return false
+		}
+		rrsets[message.TypeSOA] = []message.Answer{rr}
+		return true
+	case rr.Type == message.TypeCNAME:
+		// A name has at most one CNAME, so a new one replaces the old
+		if old := rrsets[message.TypeCNAME]; len(old) == 1 && old[0].TTL == rr.TTL && equalRData(old[0].RData, rr.RData) {
+			return false
+		}
+		z.remove(key, message.TypeCNAME, func(message.Answer) bool { return true })
+		z.add(rr)
+		return true
+	}
+
+	// A record that duplicates an existing one replaces it, which can
+	// only change its TTL
+	for _, existing := range rrsets[rr.Type] {
+		if equalRData(existing.RData, rr.RData) {
+			if existing.TTL == rr.TTL {
+				return false
+			}
+			z.remove(key, rr.Type, func(existing message.Answer) bool { return equalRData(existing.RData, rr.RData) })
+			break
+		}
+	}
+	z.add(rr)
+	return true
+}
+
+// remove deletes the records of type rtype owned by key that match, and
+// the node itself once it holds nothing. It reports whether anything was
+// deleted. z must not yet be shared.
+func (z *Zone) remove(key string, rtype uint16, match func(message.Answer) bool) bool {
+	n, ok := z.nodes[key]
+	if !ok {
+		return false
+	}
+	var kept []message.Answer
+	for _, rr := range n.rrsets[rtype] {
+		if !match(rr) {
+			kept = append(kept, rr)
+		}
+	}
+	if len(kept) == len(n.rrsets[rtype]) {
+		return false
+	}
+
+	if len(kept) > 0 {
+		n.rrsets[rtype] = kept
+		return true
+	}
+	delete(n.rrsets, rtype)
+	if len(n.rrsets) == 0 {
+		delete(z.nodes, key)
+		for _, ancestor := range z.ancestors(key) {
+			if z.descendants[ancestor]--; z.descendants[ancestor] == 0 {
+				delete(z.descendants, ancestor)
+			}
+		}
+	}
+	return true
+}
+
+// sameRRset reports whether a and b hold the same RDATA, in any order.
+func sameRRset(a, b []message.Answer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if equalRData(x.RData, y.RData) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// isMetaType reports whether t is a query or meta type (RFC 6895 §3.1),
+// which never appears as zone data.
+func isMetaType(t uint16) bool {
+	return t == message.TypeOPT || (t >= 128 && t <= 255)
+}
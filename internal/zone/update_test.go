@@ -0,0 +1,46 @@
+package zone
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func TestUpdateRejectsRecordsWithoutRDATA(t *testing.T) {
+	for _, rtype := range []uint16{message.TypeCNAME, message.TypeSOA, message.TypeA} {
+		z, err := New(testZoneRecords(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := message.Answer{Name: "new.example.com", Type: rtype, Class: message.ClassINET, TTL: 300}
+		if rtype == message.TypeSOA {
+			rr.Name = "example.com"
+		}
+		if rcode := z.Update(nil, []message.Answer{rr}); rcode != message.RCodeFormatError {
+			t.Errorf("%s: got %s, want FORMERR", message.TypeString(rtype), message.RCodeString(rcode))
+		}
+		// The zone is unchanged and still answers
+		z.Lookup(message.Question{Name: "new.example.com", Type: message.TypeA, Class: message.ClassINET}, false)
+		if Serial(z.SOA()) != 1 {
+			t.Errorf("%s: serial bumped to %d", message.TypeString(rtype), Serial(z.SOA()))
+		}
+	}
+}
+
+func TestUpdateAddsRecord(t *testing.T) {
+	z, err := New(testZoneRecords(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := message.Answer{Name: "alias.example.com", Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300, RData: &message.CNAME{Target: "www.example.com"}}
+	if rcode := z.Update(nil, []message.Answer{rr}); rcode != message.RCodeSuccess {
+		t.Fatalf("got %s", message.RCodeString(rcode))
+	}
+	response := z.Lookup(message.Question{Name: "alias.example.com", Type: message.TypeA, Class: message.ClassINET}, false)
+	if len(response.Answers) != 2 {
+		t.Errorf("answers = %v, want the CNAME and its target", response.Answers)
+	}
+	if Serial(z.SOA()) != 2 {
+		t.Errorf("serial = %d, want 2", Serial(z.SOA()))
+	}
+}
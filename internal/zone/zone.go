@@ -0,0 +1,458 @@
+package zone
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+// Zone holds the records of one zone the server is authoritative for.
+// It is safe for concurrent use.
+type Zone struct {
+	origin string
+	class  uint16
+
+	mu    sync.RWMutex
+	nodes map[string]*node // keyed by lowercased owner name
+
+	// descendants counts the nodes below each name, so empty
+	// non-terminals can be told apart from names that do not exist
+	descendants map[string]int
+
+	// journal holds recent changes, oldest first, for IXFR
+	journal []Delta
+
+	// chain lists the owners of the zone's NSEC or NSEC3 records in
+	// chain order, and nsec3 the parameters of an NSEC3 chain
+	chain []string
+	nsec3 *message.NSEC3PARAM
+
+	// signer, if set, signs every new version of the zone
+	signer *Signer
+}
+
+// maxCNAMEChain limits how many in-zone CNAMEs one lookup follows.
+const maxCNAMEChain = 16
+
+// node holds the RRsets owned by one name.
+type node struct {
+	name   string
+	rrsets map[uint16][]message.Answer
+}
+
+// New builds a zone from its records. The zone's origin is the owner of
+// its SOA record, which must be the only SOA. Every record must lie within
+// the zone, share the SOA's class and have RDATA of its type.
+func New(records []message.Answer) (*Zone, error) {
+	var soa *message.Answer
+	for i, rr := range records {
+		if rr.Type != message.TypeSOA {
+			continue
+		}
+		if soa != nil {
+			return nil, fmt.Errorf("multiple SOA records (%s and %s)", message.Fqdn(soa.Name), message.Fqdn(rr.Name))
+		}
+		soa = &records[i]
+	}
+	if soa == nil {
+		return nil, errors.New("zone has no SOA record")
+	}
+
+	z := &Zone{
+		origin:      trimDot(soa.Name),
+		class:       soa.Class,
+		nodes:       make(map[string]*node),
+		descendants: make(map[string]int),
+	}
+	for _, rr := range records {
+		if !message.IsSubdomain(rr.Name, z.origin) {
+			return nil, fmt.Errorf("record %s is outside zone %s", message.Fqdn(rr.Name), message.Fqdn(z.origin))
+		}
+		if rr.Class != z.class {
+			return nil, fmt.Errorf("record %s has class %s, zone has %s", message.Fqdn(rr.Name), message.ClassString(rr.Class), message.ClassString(z.class))
+		}
+		if err := message.CheckRData(rr.Type, rr.RData); err != nil {
+			return nil, fmt.Errorf("record %s: %w", message.Fqdn(rr.Name), err)
+		}
+		z.add(rr)
+	}
+
+	// A name with a CNAME may have no other data (RFC 1034 §3.6.2)
+	for _, n := range z.nodes {
+		if _, ok := n.rrsets[message.TypeCNAME]; ok && n.dataTypes() > 1 {
+			return nil, fmt.Errorf("%s has a CNAME and other data", message.Fqdn(n.name))
+		}
+	}
+	z.indexChain()
+	return z, nil
+}
+
+// Load reads a zone from the master file at path. If origin is not empty
+// the zone's SOA must be owned by it.
+func Load(path, origin string) (*Zone, error) {
+	records, err := ParseFile(path, origin)
+	if err != nil {
+		return nil, err
+	}
+	z, err := New(records)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if origin != "" && !message.EqualNames(z.origin, origin) {
+		return nil, fmt.Errorf("%s: SOA is owned by %s, not %s", path, message.Fqdn(z.origin), message.Fqdn(origin))
+	}
+	return z, nil
+}
+
+// add inserts rr, ignoring exact duplicates. z.mu must be held for
+// writing, or z not yet shared.
+func (z *Zone) add(rr message.Answer) {
+	key := strings.ToLower(rr.Name)
+	n, ok := z.nodes[key]
+	if !ok {
+		n = &node{name: rr.Name, rrsets: make(map[uint16][]message.Answer)}
+		z.nodes[key] = n
+		for _, ancestor := range z.ancestors(key) {
+			z.descendants[ancestor]++
+		}
+	}
+	for _, existing := range n.rrsets[rr.Type] {
+		if equalRData(existing.RData, rr.RData) {
+			return
+		}
+	}
+	n.rrsets[rr.Type] = append(n.rrsets[rr.Type], rr)
+}
+
+// Origin returns the name at the zone's apex.
+func (z *Zone) Origin() string { return z.origin }
+
+// Class returns the zone's class.
+func (z *Zone) Class() uint16 { return z.class }
+
+// SOA returns the zone's SOA record.
+func (z *Zone) SOA() message.Answer {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.soa()
+}
+
+// soa is SOA without locking. z.mu must be held.
+func (z *Zone) soa() message.Answer {
+	return z.nodes[strings.ToLower(z.origin)].rrsets[message.TypeSOA][0]
+}
+
+// Replace swaps the zone's contents for records, which must form a valid
+// zone with the same origin. If the SOA serial moved forward the change
+// is journaled for IXFR; otherwise the journal is cleared, since it no
+// longer leads to the current version.
+func (z *Zone) Replace(records []message.Answer) error {
+	next, err := New(records)
+	if err != nil {
+		return err
+	}
+	if !message.EqualNames(next.origin, z.origin) {
+		return fmt.Errorf("records are for zone %s, not %s", message.Fqdn(next.origin), message.Fqdn(z.origin))
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.swap(next)
+}
+
+// swap installs the contents of next, journaling the change as Replace
+// describes. A signed zone has next signed first. z.mu must be held for
+// writing.
+func (z *Zone) swap(next *Zone) error {
+	if z.signer != nil {
+		if err := z.signer.sign(next, z); err != nil {
+			return err
+		}
+	}
+
+	oldSOA, newSOA := z.soa(), next.soa()
+	deleted, added := diff(z.records(), next.records())
+	z.nodes, z.descendants = next.nodes, next.descendants
+	z.chain, z.nsec3 = next.chain, next.nsec3
+
+	if SerialLess(Serial(oldSOA), Serial(newSOA)) {
+		z.record(Delta{OldSOA: oldSOA, NewSOA: newSOA, Deleted: deleted, Added: added})
+	} else {
+		z.journal = nil
+	}
+	return nil
+}
+
+// RRset returns a copy of the records of type rtype owned by name.
+func (z *Zone) RRset(name string, rtype uint16) []message.Answer {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.rrset(name, rtype)
+}
+
+// rrset is RRset without locking. z.mu must be held.
+func (z *Zone) rrset(name string, rtype uint16) []message.Answer {
+	n, ok := z.nodes[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return append([]message.Answer(nil), n.rrsets[rtype]...)
+}
+
+// Records returns every record in the zone, the SOA first and the rest
+// grouped by owner name in sorted order.
+func (z *Zone) Records() []message.Answer {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return z.records()
+}
+
+// records is Records without locking. z.mu must be held.
+func (z *Zone) records() []message.Answer {
+	keys := make([]string, 0, len(z.nodes))
+	for key := range z.nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := z.rrset(z.origin, message.TypeSOA)
+	for _, key := range keys {
+		n := z.nodes[key]
+		for _, t := range sortedTypes(n.rrsets) {
+			if t != message.TypeSOA || key != strings.ToLower(z.origin) {
+				records = append(records, n.rrsets[t]...)
+			}
+		}
+	}
+	return records
+}
+
+// Lookup answers q from the zone's data following RFC 1034 §4.3.2. It
+// returns referrals for names below a zone cut, follows CNAMEs within the
+// zone, synthesizes answers from wildcards (RFC 4592) and denies missing
+// names or data with the SOA in the authority section (RFC 2308). If
+// dnssec is set, as the DO bit asks (RFC 3225), signatures go with every
+// RRset and negative answers, referrals and wildcard answers carry their
+// proofs (RFC 4035 §3.1). The returned message carries the records, the
+// AA bit and the rcode; the caller fills in the rest of the header.
+func (z *Zone) Lookup(q message.Question, dnssec bool) message.Message {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	response := message.Message{Header: message.Header{AA: 1}}
+	name := q.Name
+	seen := map[string]bool{strings.ToLower(name): true}
+
+	for {
+		// The DS RRset at a zone cut belongs to the parent (RFC 4035
+		// §3.1.4.1)
+		if cut, ok := z.delegation(name); ok && !(q.Type == message.TypeDS && message.EqualNames(cut.name, name)) {
+			// Only the answer records gathered so far are authoritative
+			if len(response.Answers) == 0 {
+				response.Header.AA = 0
+			}
+			ns := cut.rrsets[message.TypeNS]
+			response.Authority = append(response.Authority, ns...)
+			if dnssec {
+				response.Authority = append(response.Authority, z.delegationProof(cut)...)
+			}
+			response.Additional = append(response.Additional, z.addresses(ns)...)
+			return response
+		}
+
+		rrsets, ok := z.find(name)
+		if !ok {
+			response.SetRCode(message.RCodeNameError)
+			response.Authority = append(response.Authority, z.negative(name, dnssec)...)
+			return response
+		}
+		wildcard := dnssec && !z.exists(strings.ToLower(name))
+
+		if cname, ok := rrsets[message.TypeCNAME]; ok && q.Type != message.TypeCNAME && q.Type != message.TypeANY {
+			response.Answers = append(response.Answers, withSignatures(rrsets, message.TypeCNAME, dnssec)...)
+			if wildcard {
+				response.Authority = append(response.Authority, z.wildcardProof(name)...)
+			}
+			rdata, ok := cname[0].RData.(*message.CNAME)
+			if !ok {
+				return response
+			}
+			target := rdata.Target
+			key := strings.ToLower(target)
+
+			// Targets in other zones are left to the client to resolve
+			if !message.IsSubdomain(target, z.origin) || seen[key] || len(seen) > maxCNAMEChain {
+				return response
+			}
+			seen[key] = true
+			name = target
+			continue
+		}
+
+		var records []message.Answer
+		if q.Type == message.TypeANY {
+			for _, t := range sortedTypes(rrsets) {
+				if dnssec || !isDNSSECType(t) {
+					records = append(records, rrsets[t]...)
+				}
+			}
+		} else {
+			records = withSignatures(rrsets, q.Type, dnssec)
+		}
+		if len(records) == 0 {
+			response.Authority = append(response.Authority, z.negative(name, dnssec)...)
+			return response
+		}
+		if wildcard {
+			response.Authority = append(response.Authority, z.wildcardProof(name)...)
+		}
+		response.Answers = append(response.Answers, records...)
+		response.Additional = append(response.Additional, z.addresses(records)...)
+		return response
+	}
+}
+
+// delegation returns the node of the highest zone cut at or above name,
+// if name lies in a delegated child zone. z.mu must be held.
+func (z *Zone) delegation(name string) (*node, bool) {
+	key := strings.ToLower(name)
+	if key == strings.ToLower(z.origin) {
+		return nil, false
+	}
+
+	// Walk down from just below the apex to name itself
+	names := append([]string{key}, z.ancestors(key)...)
+	for i := len(names) - 2; i >= 0; i-- {
+		if n, ok := z.nodes[names[i]]; ok {
+			if _, ok := n.rrsets[message.TypeNS]; ok {
+				return n, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// find returns the RRsets at name. Empty non-terminals exist but hold no
+// data. Names that do not exist are matched against wildcards, whose
+// records are returned renamed to name. z.mu must be held.
+func (z *Zone) find(name string) (map[uint16][]message.Answer, bool) {
+	key := strings.ToLower(name)
+	if n, ok := z.nodes[key]; ok && !n.hashed() {
+		return n.rrsets, true
+	}
+	if z.descendants[key] > 0 {
+		return nil, true
+	}
+
+	// The wildcard that may match is the one at the closest encloser,
+	// the nearest ancestor that exists (RFC 4592 §3.3.1)
+	for _, ancestor := range z.ancestors(key) {
+		if _, ok := z.nodes[ancestor]; !ok && z.descendants[ancestor] == 0 {
+			continue
+		}
+		wildcard, ok := z.nodes[message.WildcardName(ancestor)]
+		if !ok {
+			return nil, false
+		}
+		synthesized := make(map[uint16][]message.Answer, len(wildcard.rrsets))
+		for t, rrset := range wildcard.rrsets {
+			for _, rr := range rrset {
+				rr.Name = name
+				synthesized[t] = append(synthesized[t], rr)
+			}
+		}
+		return synthesized, true
+	}
+	return nil, false
+}
+
+// ancestors returns the names between key (exclusive) and the zone's
+// origin (inclusive), nearest first. key must be lowercase.
+func (z *Zone) ancestors(key string) []string {
+	origin := strings.ToLower(z.origin)
+	if key == origin {
+		return nil
+	}
+	names := message.Ancestors(key)
+	for i, name := range names {
+		if name == origin {
+			return names[:i+1]
+		}
+	}
+	return names
+}
+
+// negativeSOA returns the SOA record for a negative answer. Its TTL is
+// the lesser of the SOA's own TTL and its minimum field (RFC 2308 §3).
+// z.mu must be held.
+func (z *Zone) negativeSOA() message.Answer {
+	soa := z.nodes[strings.ToLower(z.origin)].rrsets[message.TypeSOA][0]
+	if rdata, ok := soa.RData.(*message.SOA); ok {
+		soa.TTL = min(soa.TTL, rdata.Minimum)
+	}
+	return soa
+}
+
+// addresses returns the in-zone A and AAAA records of the hosts named by
+// NS, MX and SRV records, for the additional section. For referrals these
+// are the glue records. z.mu must be held.
+func (z *Zone) addresses(records []message.Answer) []message.Answer {
+	var additional []message.Answer
+	seen := make(map[string]bool)
+	for _, rr := range records {
+		var host string
+		switch rdata := rr.RData.(type) {
+		case *message.NS:
+			host = rdata.Host
+		case *message.MX:
+			host = rdata.Exchange
+		case *message.SRV:
+			host = rdata.Target
+		default:
+			continue
+		}
+		key := strings.ToLower(host)
+		if seen[key] || !message.IsSubdomain(host, z.origin) {
+			continue
+		}
+		seen[key] = true
+		if n, ok := z.nodes[key]; ok {
+			additional = append(additional, n.rrsets[message.TypeA]...)
+			additional = append(additional, n.rrsets[message.TypeAAAA]...)
+		}
+	}
+	return additional
+}
+
+// dataTypes counts the types at n other than those DNSSEC adds, which may
+// sit beside a CNAME (RFC 4035 §2.5).
+func (n *node) dataTypes() int {
+	count := 0
+	for t := range n.rrsets {
+		if !isDNSSECType(t) {
+			count++
+		}
+	}
+	return count
+}
+
+func sortedTypes(rrsets map[uint16][]message.Answer) []uint16 {
+	types := make([]uint16, 0, len(rrsets))
+	for t := range rrsets {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// equalRData reports whether two RDATA values have the same wire format.
+func equalRData(a, b message.RData) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return string(message.PackRData(a)) == string(message.PackRData(b))
+}
@@ -0,0 +1,180 @@
+package zone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/dns-server-starter-go/internal/message"
+)
+
+func TestLookup(t *testing.T) {
+	records, err := Parse(strings.NewReader(`$ORIGIN example.com.
+$TTL 300
+@ SOA ns1 hostmaster 1 3600 900 604800 300
+@ NS ns1
+ns1 A 192.0.2.1
+www A 192.0.2.10
+alias CNAME www
+outside CNAME www.example.net.
+*.wild A 192.0.2.20
+host.wild A 192.0.2.21
+a.b.empty A 192.0.2.30
+sub NS ns.sub
+ns.sub A 192.0.2.40
+@ MX 10 mail
+mail A 192.0.2.50
+mail AAAA 2001:db8::50
+_sip._tcp SRV 0 5 5060 sip.sub
+loop1 CNAME loop2
+loop2 CNAME loop1
+dangling CNAME nowhere
+delegated CNAME www.sub
+*.wildalias CNAME www
+`), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := New(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		qtype      uint16
+		rcode      int
+		aa         bool
+		answers    []string // owner and type of each answer
+		authority  uint16   // type of the authority records, if any
+		additional int
+	}{
+		{"www.example.com", message.TypeA, message.RCodeSuccess, true, []string{"www.example.com A"}, 0, 0},
+		{"WWW.Example.COM", message.TypeA, message.RCodeSuccess, true, []string{"www.example.com A"}, 0, 0},
+		{"alias.example.com", message.TypeA, message.RCodeSuccess, true, []string{"alias.example.com CNAME", "www.example.com A"}, 0, 0},
+		{"alias.example.com", message.TypeCNAME, message.RCodeSuccess, true, []string{"alias.example.com CNAME"}, 0, 0},
+		{"outside.example.com", message.TypeA, message.RCodeSuccess, true, []string{"outside.example.com CNAME"}, 0, 0},
+		{"www.example.com", message.TypeAAAA, message.RCodeSuccess, true, nil, message.TypeSOA, 0},
+		{"missing.example.com", message.TypeA, message.RCodeNameError, true, nil, message.TypeSOA, 0},
+		{"b.empty.example.com", message.TypeA, message.RCodeSuccess, true, nil, message.TypeSOA, 0},
+		{"x.wild.example.com", message.TypeA, message.RCodeSuccess, true, []string{"x.wild.example.com A"}, 0, 0},
+		// A wildcard does not match below an existing name
+		{"x.host.wild.example.com", message.TypeA, message.RCodeNameError, true, nil, message.TypeSOA, 0},
+		{"www.sub.example.com", message.TypeA, message.RCodeSuccess, false, nil, message.TypeNS, 1},
+		{"example.com", message.TypeNS, message.RCodeSuccess, true, []string{"example.com NS"}, 0, 1},
+		// The exchange's addresses go in the additional section
+		{"example.com", message.TypeMX, message.RCodeSuccess, true, []string{"example.com MX"}, 0, 2},
+		// but not those of a target below a zone cut
+		{"_sip._tcp.example.com", message.TypeSRV, message.RCodeSuccess, true, []string{"_sip._tcp.example.com SRV"}, 0, 0},
+		{"x.wild.example.com", message.TypeAAAA, message.RCodeSuccess, true, nil, message.TypeSOA, 0},
+		{"x.wildalias.example.com", message.TypeA, message.RCodeSuccess, true, []string{"x.wildalias.example.com CNAME", "www.example.com A"}, 0, 0},
+		{"loop1.example.com", message.TypeA, message.RCodeSuccess, true, []string{"loop1.example.com CNAME", "loop2.example.com CNAME"}, 0, 0},
+		{"dangling.example.com", message.TypeA, message.RCodeNameError, true, []string{"dangling.example.com CNAME"}, message.TypeSOA, 0},
+		// A chain into a child zone ends in a referral, but the answer
+		// gathered so far is still authoritative
+		{"delegated.example.com", message.TypeA, message.RCodeSuccess, true, []string{"delegated.example.com CNAME"}, message.TypeNS, 1},
+		// The parent side of a zone cut answers for DS
+		{"sub.example.com", message.TypeDS, message.RCodeSuccess, true, nil, message.TypeSOA, 0},
+		{"sub.example.com", message.TypeA, message.RCodeSuccess, false, nil, message.TypeNS, 1},
+		{"mail.example.com", message.TypeANY, message.RCodeSuccess, true, []string{"mail.example.com A", "mail.example.com AAAA"}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+message.TypeString(tt.qtype), func(t *testing.T) {
+			response := z.Lookup(message.Question{Name: tt.name, Type: tt.qtype, Class: message.ClassINET}, false)
+			if rcode := response.RCode(); rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", message.RCodeString(rcode), message.RCodeString(tt.rcode))
+			}
+			if aa := response.Header.AA == 1; aa != tt.aa {
+				t.Errorf("AA = %v, want %v", aa, tt.aa)
+			}
+			var answers []string
+			for _, rr := range response.Answers {
+				answers = append(answers, strings.ToLower(rr.Name)+" "+message.TypeString(rr.Type))
+			}
+			if strings.Join(answers, ", ") != strings.Join(tt.answers, ", ") {
+				t.Errorf("answers = %v, want %v", answers, tt.answers)
+			}
+			if tt.authority == 0 && len(response.Authority) > 0 {
+				t.Errorf("unexpected authority records %v", response.Authority)
+			}
+			if tt.authority != 0 && (len(response.Authority) == 0 || response.Authority[0].Type != tt.authority) {
+				t.Errorf("authority = %v, want %s records", response.Authority, message.TypeString(tt.authority))
+			}
+			if len(response.Additional) != tt.additional {
+				t.Errorf("%d additional records, want %d", len(response.Additional), tt.additional)
+			}
+		})
+	}
+}
+
+func TestNewRejectsBadRDATA(t *testing.T) {
+	good := testZoneRecords(t)
+	tests := []struct {
+		name string
+		rr   message.Answer
+	}{
+		{"CNAME without RDATA", message.Answer{Name: "alias.example.com", Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300}},
+		{"CNAME with A RDATA", message.Answer{Name: "alias.example.com", Type: message.TypeCNAME, Class: message.ClassINET, TTL: 300, RData: good[2].RData}},
+		{"A without address", message.Answer{Name: "host.example.com", Type: message.TypeA, Class: message.ClassINET, TTL: 300, RData: &message.A{}}},
+	}
+	for _, tt := range tests {
+		records := append(append([]message.Answer(nil), good...), tt.rr)
+		if _, err := New(records); err == nil {
+			t.Errorf("%s: New succeeded", tt.name)
+		}
+	}
+
+	// The SOA itself must be usable too
+	records := append([]message.Answer(nil), good...)
+	records[0].RData = nil
+	if _, err := New(records); err == nil {
+		t.Error("SOA without RDATA: New succeeded")
+	}
+}
+
+func TestLookupNegativeTTL(t *testing.T) {
+	records, err := Parse(strings.NewReader(`$ORIGIN example.com.
+@ 3600 SOA ns1 hostmaster 1 3600 900 604800 60
+@ 3600 NS ns1
+ns1 3600 A 192.0.2.1
+`), "test.zone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := New(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ns1.example.com", "missing.example.com"} {
+		response := z.Lookup(message.Question{Name: name, Type: message.TypeAAAA, Class: message.ClassINET}, false)
+		if len(response.Authority) != 1 || response.Authority[0].TTL != 60 {
+			t.Errorf("%s: authority %v, want the SOA with the minimum TTL 60", name, response.Authority)
+		}
+	}
+	// The SOA answering a query for it keeps its own TTL
+	response := z.Lookup(message.Question{Name: "example.com", Type: message.TypeSOA, Class: message.ClassINET}, false)
+	if len(response.Answers) != 1 || response.Answers[0].TTL != 3600 {
+		t.Errorf("SOA answer %v, want TTL 3600", response.Answers)
+	}
+}
+
+func TestNewRejectsInvalidZones(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+	}{
+		{"no SOA", "@ NS ns1\nns1 A 192.0.2.1\n"},
+		{"two SOAs", "@ SOA ns1 hostmaster 1 3600 900 604800 300\nsub SOA ns1 hostmaster 1 3600 900 604800 300\n"},
+		{"outside the zone", "@ SOA ns1 hostmaster 1 3600 900 604800 300\nwww.example.net. A 192.0.2.1\n"},
+		{"class mismatch", "@ SOA ns1 hostmaster 1 3600 900 604800 300\nwww CH A 192.0.2.1\n"},
+		{"CNAME and other data", "@ SOA ns1 hostmaster 1 3600 900 604800 300\nwww CNAME host\nwww TXT hello\n"},
+	}
+	for _, tt := range tests {
+		records, err := Parse(strings.NewReader("$ORIGIN example.com.\n$TTL 300\n"+tt.zone), "test.zone", "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := New(records); err == nil {
+			t.Errorf("%s: New succeeded", tt.name)
+		}
+	}
+}